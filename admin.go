@@ -0,0 +1,85 @@
+package main
+
+import (
+    "context"
+    "github.com/slack-go/slack"
+)
+
+// isAdmin tells whether the user can answer the forwarding requests of the
+// number. When no admin is configured, every member of the admin channel is
+// an admin. Requests without a number forward every number, so they are
+// answered by the users who are also admins of the numbers with their own
+// admins.
+func isAdmin(ctx context.Context, userId string, number string) (bool, error) {
+    if number == "" {
+        for _, phoneNumber := range config.Numbers {
+            if !hasOwnAdmins(phoneNumber.Number) {
+                continue
+            }
+
+            admin, err := isNumberAdmin(ctx, userId, phoneNumber.Number)
+            if err != nil || !admin {
+                return false, err
+            }
+        }
+    }
+
+    return isNumberAdmin(ctx, userId, number)
+}
+
+func isNumberAdmin(ctx context.Context, userId string, number string) (bool, error) {
+    admins, adminGroup := numberAdmins(number)
+
+    if len(admins) == 0 && adminGroup == "" {
+        return true, nil
+    }
+
+    if contains(admins, userId) {
+        return true, nil
+    }
+
+    if adminGroup == "" {
+        return false, nil
+    }
+
+    members, err := slackClient.GetUserGroupMembersContext(ctx, adminGroup)
+    if err != nil {
+        return false, err
+    }
+
+    return contains(members, userId), nil
+}
+
+// hasConfiguredAdmins tells whether admins are configured for the number, as
+// everyone is an admin otherwise.
+func hasConfiguredAdmins(number string) bool {
+    admins, adminGroup := numberAdmins(number)
+    return len(admins) > 0 || adminGroup != ""
+}
+
+// canAnswer checks that the user is an admin of the number and, when
+// accepting, that they are not the requester.
+func canAnswer(ctx context.Context, userId string, action string, requesterId string, number string) (bool, string, error) {
+    admin, err := isAdmin(ctx, userId, number)
+    if err != nil {
+        return false, "", err
+    }
+    if !admin {
+        return false, ":no_entry_sign: You are not allowed to answer forwarding requests.", nil
+    }
+
+    if action == "accept" && userId == requesterId {
+        return false, ":no_entry_sign: You can't approve your own request.", nil
+    }
+
+    return true, "", nil
+}
+
+// rejectAction tells the user, and only them, why their action was ignored.
+func rejectAction(message slack.InteractionCallback, text string) {
+    slackClient.PostMessage(
+        message.Channel.GroupConversation.Conversation.ID,
+        slack.MsgOptionText(text, false),
+        slack.MsgOptionResponseURL(message.ResponseURL, slack.ResponseTypeEphemeral),
+    )
+}
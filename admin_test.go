@@ -0,0 +1,96 @@
+package main
+
+import (
+    "context"
+    "testing"
+)
+
+func TestCanAnswer(t *testing.T) {
+    config.Slack.Admins = []string{"UADMIN", "UADMIN2"}
+    config.Slack.AdminGroup = ""
+    defer func() { config.Slack.Admins = nil }()
+
+    tests := []struct {
+        userId      string
+        action      string
+        requesterId string
+        allowed     bool
+    }{
+        {"UADMIN", "accept", "UREQUESTER", true},
+        {"UADMIN", "refuse", "UREQUESTER", true},
+        {"UADMIN", "revoke", "UREQUESTER", true},
+        {"UOTHER", "accept", "UREQUESTER", false},
+        {"UOTHER", "refuse", "UREQUESTER", false},
+        {"UOTHER", "revoke", "UREQUESTER", false},
+        {"UADMIN", "accept", "UADMIN", false},
+        {"UADMIN2", "accept", "UADMIN", true},
+        {"UADMIN", "refuse", "UADMIN", true},
+    }
+
+    for _, test := range tests {
+        allowed, reason, err := canAnswer(context.Background(), test.userId, test.action, test.requesterId, "")
+        if err != nil {
+            t.Errorf("unexpected error: %s", err)
+        }
+
+        if allowed != test.allowed {
+            t.Errorf("%s %s request of %s: expected allowed to be %t, got: %t", test.userId, test.action, test.requesterId, test.allowed, allowed)
+        }
+
+        if !allowed && reason == "" {
+            t.Errorf("%s %s request of %s: expected a reason", test.userId, test.action, test.requesterId)
+        }
+    }
+}
+
+func TestIsAdmin_NoAdminConfigured(t *testing.T) {
+    config.Slack.Admins = nil
+    config.Slack.AdminGroup = ""
+
+    admin, err := isAdmin(context.Background(), "UANYONE", "")
+    if err != nil {
+        t.Errorf("unexpected error: %s", err)
+    }
+
+    if !admin {
+        t.Errorf("expected everyone to be admin when no admin is configured")
+    }
+}
+
+func TestIsAdmin_NumberAdmins(t *testing.T) {
+    config.Slack.Admins = []string{"UADMIN", "UBOTH"}
+    config.Numbers = []PhoneNumber{
+        {Number: "+33612345678", Admins: []string{"UFINANCE", "UBOTH"}},
+        {Number: "+33698765432"},
+    }
+    defer func() {
+        config.Slack.Admins = nil
+        config.Numbers = nil
+    }()
+
+    tests := []struct {
+        userId string
+        number string
+        admin  bool
+    }{
+        {"UFINANCE", "33612345678", true},
+        {"UADMIN", "33612345678", false},
+        {"UADMIN", "+33698765432", true},
+        {"UFINANCE", "+33698765432", false},
+        // Requests without a number also forward the number of UFINANCE
+        {"UADMIN", "", false},
+        {"UFINANCE", "", false},
+        {"UBOTH", "", true},
+    }
+
+    for _, test := range tests {
+        admin, err := isAdmin(context.Background(), test.userId, test.number)
+        if err != nil {
+            t.Errorf("unexpected error: %s", err)
+        }
+
+        if admin != test.admin {
+            t.Errorf("%s for %q: expected admin to be %t, got: %t", test.userId, test.number, test.admin, admin)
+        }
+    }
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    defaultDuration    = time.Hour
+    defaultMinDuration = time.Minute
+    defaultMaxDuration = 30 * 24 * time.Hour
+)
+
+const durationHelp = "Please enter a number followed by a unit, you can combine several of them (e.g. `1h30m`):\n- `m`, `min`, `minute`, `minutes` for minutes\n- `h`, `hour`, `hours` for hours\n- `d`, `day`, `days` for days\n- `w`, `week`, `weeks` for weeks\n\nYou can also enter an end time, e.g. `until 18:00`, `until tomorrow 9am` or `until friday 17h`.\n\nNote: you can omit the unit for minutes"
+
+const windowHelp = "Please enter a start and an end, e.g. `tomorrow 14:00-16:00`, `friday 9am to 11am` or `tomorrow 14:00 for 2h`."
+
+var windowSeparatorPattern = regexp.MustCompile(`\s*(?:-|–|\sto\s)\s*`)
+
+var durationPartPattern = regexp.MustCompile(`^(\d+)\s*([a-z]*)[\s,]*(and\s+)?`)
+
+var endTimePattern = regexp.MustCompile(`^(\d{1,2})(?:[:h](\d{2})|h)?(am|pm)?$`)
+
+// parseCommandDuration parses the optional duration argument of a slash
+// command. End times are resolved in the user's Slack time zone.
+func parseCommandDuration(ctx context.Context, userId string, args []string) (time.Duration, error) {
+    duration := defaultDuration
+
+    if len(args) > 0 {
+        var err error
+        duration, err = parseDuration(args[0], time.Now(), commandLocation(ctx, userId, args[0]))
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    err := validateDuration(duration)
+    if err != nil {
+        return 0, err
+    }
+
+    return duration, nil
+}
+
+// parseExtensionDuration parses the argument of `/sms extend` for a request
+// expiring at expiresAt.
+func parseExtensionDuration(ctx context.Context, userId string, args []string, expiresAt time.Time) (time.Duration, error) {
+    if len(args) == 0 {
+        return defaultDuration, nil
+    }
+
+    return parseExtension(args[0], time.Now(), expiresAt, commandLocation(ctx, userId, args[0]))
+}
+
+// parseExtension understands the same durations as parseDuration. An end time
+// extends the request up to that time, instead of adding the time left until
+// then to its expiration.
+func parseExtension(input string, now time.Time, expiresAt time.Time, location *time.Location) (time.Duration, error) {
+    duration, err := parseDuration(input, now, location)
+    if err != nil || !isEndTime(input) {
+        return duration, err
+    }
+
+    end := now.Add(duration)
+    if !end.After(expiresAt) {
+        return 0, fmt.Errorf(
+            "Your forwarding request already lasts until <!date^%d^{date_short_pretty} {time}|%s>.",
+            expiresAt.Unix(),
+            expiresAt.In(location).Format("2006-01-02 15:04"),
+        )
+    }
+
+    return (end.Sub(expiresAt) + time.Minute - 1).Truncate(time.Minute), nil
+}
+
+// commandLocation returns the time zone of the user when input is an end
+// time. Durations don't need it, which saves a call to Slack.
+func commandLocation(ctx context.Context, userId string, input string) *time.Location {
+    if !isEndTime(input) {
+        return time.UTC
+    }
+
+    return userLocation(ctx, userId)
+}
+
+func isEndTime(input string) bool {
+    return strings.HasPrefix(strings.ToLower(strings.TrimSpace(input)), "until ")
+}
+
+// userLocation returns the time zone of the user, UTC if it is unknown.
+func userLocation(ctx context.Context, userId string) *time.Location {
+    user, err := slackClient.GetUserInfoContext(ctx, userId)
+    if err != nil {
+        fmt.Printf("Failed to get the time zone of %s: %v\n", userId, err)
+        return time.UTC
+    }
+
+    location, err := time.LoadLocation(user.TZ)
+    if err != nil {
+        fmt.Printf("Unknown time zone %q for %s: %v\n", user.TZ, userId, err)
+        return time.UTC
+    }
+
+    return location
+}
+
+// parseDuration understands durations such as `90`, `2 hours` or `1h30m` and
+// end times such as `until 18:00` or `until tomorrow 9am`, relative to now in
+// the given location.
+func parseDuration(durationStr string, now time.Time, location *time.Location) (time.Duration, error) {
+    input := strings.ToLower(strings.TrimSpace(durationStr))
+
+    if isEndTime(input) {
+        end, err := parseEndTime(strings.TrimSpace(strings.TrimPrefix(input, "until ")), now.In(location))
+        if err != nil {
+            return 0, err
+        }
+
+        if !end.After(now) {
+            return 0, fmt.Errorf("<!date^%d^{date_short_pretty} {time}|%s> is in the past.", end.Unix(), end.Format("2006-01-02 15:04"))
+        }
+
+        // Round up so that the forwarding doesn't stop right before the end time
+        return (end.Sub(now) + time.Minute - 1).Truncate(time.Minute), nil
+    }
+
+    if input == "" {
+        return 0, fmt.Errorf("I don't understand the duration you want. %s", durationHelp)
+    }
+
+    var total time.Duration
+    for rest := input; rest != ""; {
+        result := durationPartPattern.FindStringSubmatch(rest)
+        if result == nil {
+            return 0, fmt.Errorf("I don't understand the duration you want. %s", durationHelp)
+        }
+        rest = rest[len(result[0]):]
+
+        unitDuration, err := getUnitDuration(result[2])
+        if err != nil {
+            return 0, fmt.Errorf("I don't understand the duration you want. %q is not a valid unit. %s", result[2], durationHelp)
+        }
+
+        value, err := strconv.ParseInt(result[1], 10, 64)
+        if err != nil || value > int64((math.MaxInt64-total)/unitDuration) {
+            return 0, fmt.Errorf("The duration you want is way too long, the maximum is %s.", describeDuration(maxDuration()))
+        }
+
+        total += time.Duration(value) * unitDuration
+    }
+
+    return total, nil
+}
+
+func getUnitDuration(unit string) (time.Duration, error) {
+    switch unit {
+    case "", "m", "min", "mins", "minute", "minutes":
+        return time.Minute, nil
+    case "h", "hour", "hours":
+        return time.Hour, nil
+    case "d", "day", "days":
+        return 24 * time.Hour, nil
+    case "w", "week", "weeks":
+        return 7 * 24 * time.Hour, nil
+    }
+
+    return 0, fmt.Errorf("%q is not a valid unit", unit)
+}
+
+// parseWindow understands forwarding windows such as `tomorrow 14:00-16:00`,
+// `friday 9am to 11am` or `tomorrow 14:00 for 2h`, relative to now in the
+// given location.
+func parseWindow(input string, now time.Time, location *time.Location) (time.Time, time.Duration, error) {
+    input = strings.ToLower(strings.TrimSpace(input))
+    localNow := now.In(location)
+
+    var startStr, endStr, durationStr string
+    if parts := strings.SplitN(input, " for ", 2); len(parts) == 2 {
+        startStr, durationStr = parts[0], parts[1]
+    } else if parts := windowSeparatorPattern.Split(input, 2); len(parts) == 2 {
+        startStr, endStr = parts[0], parts[1]
+    } else {
+        return time.Time{}, 0, fmt.Errorf("I don't understand the window you want. %s", windowHelp)
+    }
+
+    start, err := parseEndTime(strings.TrimSpace(startStr), localNow)
+    if err != nil {
+        return time.Time{}, 0, err
+    }
+
+    if !start.After(now) {
+        return time.Time{}, 0, fmt.Errorf("<!date^%d^{date_short_pretty} {time}|%s> is in the past.", start.Unix(), start.Format("2006-01-02 15:04"))
+    }
+
+    if durationStr != "" {
+        duration, err := parseDuration(durationStr, start, location)
+        if err != nil {
+            return time.Time{}, 0, err
+        }
+
+        return start, duration, nil
+    }
+
+    // Without a day, the end is the first occurrence of the time after the start
+    reference := start
+    if hasDay(endStr, localNow) {
+        reference = localNow
+    }
+
+    end, err := parseEndTime(strings.TrimSpace(endStr), reference)
+    if err != nil {
+        return time.Time{}, 0, err
+    }
+
+    if !end.After(start) {
+        return time.Time{}, 0, fmt.Errorf("The window must end after it starts.")
+    }
+
+    return start, end.Sub(start), nil
+}
+
+// parseEndTime resolves a time of the day, optionally preceded or followed by
+// `today`, `tomorrow` or a weekday. Without a day, the next occurrence of the
+// time is used.
+func parseEndTime(input string, now time.Time) (time.Time, error) {
+    fields := strings.Fields(input)
+
+    dayOffset := -1
+    if len(fields) > 0 {
+        if offset, ok := parseDay(fields[0], now); ok {
+            dayOffset = offset
+            fields = fields[1:]
+        } else if offset, ok := parseDay(fields[len(fields)-1], now); ok {
+            dayOffset = offset
+            fields = fields[:len(fields)-1]
+        }
+    }
+
+    hour, minute, err := parseTimeOfDay(strings.Join(fields, ""))
+    if err != nil {
+        return time.Time{}, err
+    }
+
+    if dayOffset == -1 {
+        dayOffset = 0
+        if hour*60+minute <= now.Hour()*60+now.Minute() {
+            dayOffset = 1
+        }
+    }
+
+    return time.Date(now.Year(), now.Month(), now.Day()+dayOffset, hour, minute, 0, 0, now.Location()), nil
+}
+
+// parseTimeOfDay understands times such as `18:00`, `18h`, `18h30` or `6pm`.
+func parseTimeOfDay(input string) (int, int, error) {
+    result := endTimePattern.FindStringSubmatch(input)
+    if result == nil {
+        return 0, 0, fmt.Errorf("I don't understand the time you want. %s", durationHelp)
+    }
+
+    hour, _ := strconv.Atoi(result[1])
+    minute := 0
+    if result[2] != "" {
+        minute, _ = strconv.Atoi(result[2])
+    }
+
+    switch result[3] {
+    case "am", "pm":
+        if hour < 1 || hour > 12 {
+            return 0, 0, fmt.Errorf("%q is not a valid time.", input)
+        }
+        hour = hour % 12
+        if result[3] == "pm" {
+            hour += 12
+        }
+    }
+
+    if hour > 23 || minute > 59 {
+        return 0, 0, fmt.Errorf("%q is not a valid time.", input)
+    }
+
+    return hour, minute, nil
+}
+
+func hasDay(input string, now time.Time) bool {
+    fields := strings.Fields(input)
+    if len(fields) == 0 {
+        return false
+    }
+
+    _, first := parseDay(fields[0], now)
+    _, last := parseDay(fields[len(fields)-1], now)
+
+    return first || last
+}
+
+// parseDay returns the number of days between now and the given day.
+func parseDay(day string, now time.Time) (int, bool) {
+    switch day {
+    case "today":
+        return 0, true
+    case "tomorrow":
+        return 1, true
+    }
+
+    if weekday, ok := parseWeekday(day); ok {
+        return (int(weekday) - int(now.Weekday()) + 7) % 7, true
+    }
+
+    return 0, false
+}
+
+// parseWeekday understands full and abbreviated English day names.
+func parseWeekday(day string) (time.Weekday, bool) {
+    for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+        name := strings.ToLower(weekday.String())
+        if day == name || day == name[:3] {
+            return weekday, true
+        }
+    }
+
+    return 0, false
+}
+
+// validateDuration checks the duration of a request or an extension against
+// the configured limits.
+func validateDuration(duration time.Duration) error {
+    if min := minDuration(); duration < min {
+        return fmt.Errorf("The duration must be at least %s.", describeDuration(min))
+    }
+
+    if max := maxDuration(); duration > max {
+        return fmt.Errorf("The duration must be at most %s.", describeDuration(max))
+    }
+
+    return nil
+}
+
+func minDuration() time.Duration {
+    if config.Forwarding.MinDuration > 0 {
+        return time.Duration(config.Forwarding.MinDuration) * time.Minute
+    }
+
+    return defaultMinDuration
+}
+
+func maxDuration() time.Duration {
+    if config.Forwarding.MaxDuration > 0 {
+        return time.Duration(config.Forwarding.MaxDuration) * time.Minute
+    }
+
+    return defaultMaxDuration
+}
+
+// describeDuration formats a whole number of minutes, e.g. "1 day(s) 2 hour(s)"
+func describeDuration(duration time.Duration) string {
+    minutes := int64(duration / time.Minute)
+
+    var parts []string
+    if days := minutes / (24 * 60); days > 0 {
+        parts = append(parts, fmt.Sprintf("%d day(s)", days))
+    }
+    if hours := minutes / 60 % 24; hours > 0 {
+        parts = append(parts, fmt.Sprintf("%d hour(s)", hours))
+    }
+    if minutes%60 > 0 || len(parts) == 0 {
+        parts = append(parts, fmt.Sprintf("%d minute(s)", minutes%60))
+    }
+
+    return strings.Join(parts, " ")
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseDuration(t *testing.T) {
+    paris, _ := time.LoadLocation("Europe/Paris")
+    // Friday 2023-03-10 16:30:20 in Paris
+    now := time.Date(2023, 3, 10, 15, 30, 20, 0, time.UTC)
+
+    tests := []struct {
+        input    string
+        location *time.Location
+        expected time.Duration
+    }{
+        {"30", time.UTC, 30 * time.Minute},
+        {"30m", time.UTC, 30 * time.Minute},
+        {"2 hours", time.UTC, 2 * time.Hour},
+        {"1d", time.UTC, 24 * time.Hour},
+        {"2w", time.UTC, 14 * 24 * time.Hour},
+        {"1h30m", time.UTC, 90 * time.Minute},
+        {"1h30", time.UTC, 90 * time.Minute},
+        {"1 hour and 30 minutes", time.UTC, 90 * time.Minute},
+        {"1d, 2h", time.UTC, 26 * time.Hour},
+        {"1H30M", time.UTC, 90 * time.Minute},
+        {"until 18:00", paris, 89*time.Minute + 40*time.Second},
+        {"until 18:00", time.UTC, 2*time.Hour + 29*time.Minute + 40*time.Second},
+        {"until 18h", paris, 89*time.Minute + 40*time.Second},
+        {"until 6pm", paris, 89*time.Minute + 40*time.Second},
+        {"until 6 pm", paris, 89*time.Minute + 40*time.Second},
+        {"until 9am", paris, 16*time.Hour + 29*time.Minute + 40*time.Second},
+        {"until tomorrow 9am", paris, 16*time.Hour + 29*time.Minute + 40*time.Second},
+        {"until 9:30 tomorrow", paris, 16*time.Hour + 59*time.Minute + 40*time.Second},
+        {"until today 16:31", paris, 40 * time.Second},
+        {"until monday 9h", paris, 2*24*time.Hour + 16*time.Hour + 29*time.Minute + 40*time.Second},
+        {"until fri 17:00", paris, 29*time.Minute + 40*time.Second},
+    }
+
+    for _, test := range tests {
+        t.Run(test.input, func(t *testing.T) {
+            duration, err := parseDuration(test.input, now, test.location)
+            if err != nil {
+                t.Fatalf("failed to parse %q: %s", test.input, err)
+            }
+
+            // End times are rounded up to the minute
+            expected := (test.expected + time.Minute - 1).Truncate(time.Minute)
+            if duration != expected {
+                t.Errorf("invalid duration for %q, expected: %s, got: %s", test.input, expected, duration)
+            }
+        })
+    }
+}
+
+func TestParseExtension(t *testing.T) {
+    paris, _ := time.LoadLocation("Europe/Paris")
+    // Friday 2023-03-10 16:30:20 in Paris, the request expires at 17:00:20
+    now := time.Date(2023, 3, 10, 15, 30, 20, 0, time.UTC)
+    expiresAt := now.Add(30 * time.Minute)
+
+    tests := []struct {
+        input    string
+        expected time.Duration
+    }{
+        {"30", 30 * time.Minute},
+        {"1h", time.Hour},
+        // The request ends at 18:00, not an hour and a half after 17:00
+        {"until 18:00", time.Hour},
+        {"until tomorrow 9am", 16 * time.Hour},
+    }
+
+    for _, test := range tests {
+        duration, err := parseExtension(test.input, now, expiresAt, paris)
+        if err != nil {
+            t.Errorf("failed to parse %q: %s", test.input, err)
+            continue
+        }
+
+        if duration != test.expected {
+            t.Errorf("invalid extension for %q, expected: %s, got: %s", test.input, test.expected, duration)
+        }
+    }
+
+    _, err := parseExtension("until 17:00", now, expiresAt, paris)
+    if err == nil {
+        t.Errorf("expected an end time before the expiration to be rejected")
+    }
+}
+
+func TestParseDuration_Invalid(t *testing.T) {
+    now := time.Date(2023, 3, 10, 15, 30, 20, 0, time.UTC)
+
+    tests := []string{
+        "",
+        "soon",
+        "3 fortnights",
+        "1h soon",
+        "999999999999 weeks",
+        "99999999999999999999",
+        "until",
+        "until tomorrow",
+        "until 25:00",
+        "until 13pm",
+        "until 12:75",
+        "until today 9:00",
+    }
+
+    for _, input := range tests {
+        t.Run(input, func(t *testing.T) {
+            _, err := parseDuration(input, now, time.UTC)
+            if err == nil {
+                t.Errorf("expected an error for %q", input)
+            }
+        })
+    }
+}
+
+func TestValidateDuration(t *testing.T) {
+    config.Forwarding.MinDuration = 5
+    config.Forwarding.MaxDuration = 24 * 60
+    defer func() {
+        config.Forwarding.MinDuration = 0
+        config.Forwarding.MaxDuration = 0
+    }()
+
+    if err := validateDuration(time.Hour); err != nil {
+        t.Errorf("expected 1h to be valid, got: %s", err)
+    }
+
+    if err := validateDuration(time.Minute); err == nil {
+        t.Errorf("expected 1m to be too short")
+    }
+
+    if err := validateDuration(99999 * 24 * time.Hour); err == nil {
+        t.Errorf("expected 99999 days to be too long")
+    }
+}
+
+func TestDescribeDuration(t *testing.T) {
+    expected := map[time.Duration]string{
+        0:                          "0 minute(s)",
+        45 * time.Minute:           "45 minute(s)",
+        2 * time.Hour:              "2 hour(s)",
+        26*time.Hour + time.Minute: "1 day(s) 2 hour(s) 1 minute(s)",
+    }
+
+    for duration, description := range expected {
+        if got := describeDuration(duration); got != description {
+            t.Errorf("invalid description of %s, expected: %q, got: %q", duration, description, got)
+        }
+    }
+}
+
+func TestParseWindow(t *testing.T) {
+    paris, _ := time.LoadLocation("Europe/Paris")
+    // Friday 2023-03-10 16:30:20 in Paris
+    now := time.Date(2023, 3, 10, 15, 30, 20, 0, time.UTC)
+
+    tests := []struct {
+        input    string
+        start    time.Time
+        duration time.Duration
+    }{
+        {"tomorrow 14:00-16:00", time.Date(2023, 3, 11, 14, 0, 0, 0, paris), 2 * time.Hour},
+        {"tomorrow 14:00 - 16:00", time.Date(2023, 3, 11, 14, 0, 0, 0, paris), 2 * time.Hour},
+        {"tomorrow 14:00–16:00", time.Date(2023, 3, 11, 14, 0, 0, 0, paris), 2 * time.Hour},
+        {"monday 9am to 11:30am", time.Date(2023, 3, 13, 9, 0, 0, 0, paris), 150 * time.Minute},
+        {"tomorrow 14:00 for 2h", time.Date(2023, 3, 11, 14, 0, 0, 0, paris), 2 * time.Hour},
+        {"22:00-6:00", time.Date(2023, 3, 10, 22, 0, 0, 0, paris), 8 * time.Hour},
+        {"friday 22:00 to sunday 10:00", time.Date(2023, 3, 10, 22, 0, 0, 0, paris), 36 * time.Hour},
+    }
+
+    for _, test := range tests {
+        t.Run(test.input, func(t *testing.T) {
+            start, duration, err := parseWindow(test.input, now, paris)
+            if err != nil {
+                t.Fatalf("failed to parse %q: %s", test.input, err)
+            }
+
+            if !start.Equal(test.start) {
+                t.Errorf("invalid start for %q, expected: %s, got: %s", test.input, test.start, start)
+            }
+
+            if duration != test.duration {
+                t.Errorf("invalid duration for %q, expected: %s, got: %s", test.input, test.duration, duration)
+            }
+        })
+    }
+}
+
+func TestParseWindow_Invalid(t *testing.T) {
+    now := time.Date(2023, 3, 10, 15, 30, 20, 0, time.UTC)
+
+    tests := []string{
+        "tomorrow",
+        "tomorrow 14:00",
+        "today 9:00-10:00",
+        "tomorrow 14:00 for soon",
+        "tomorrow 16:00 to tomorrow 14:00",
+    }
+
+    for _, input := range tests {
+        t.Run(input, func(t *testing.T) {
+            _, _, err := parseWindow(input, now, time.UTC)
+            if err == nil {
+                t.Errorf("expected an error for %q", input)
+            }
+        })
+    }
+}
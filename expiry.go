@@ -0,0 +1,120 @@
+package main
+
+import (
+    "context"
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/slack-go/slack"
+    "log"
+    "time"
+)
+
+const expiryCheckInterval = time.Minute
+
+// expiredRequestMaxAge bounds how late an expiration is still handled. It
+// covers restarts of the scheduler without notifying the requests which
+// expired before it was deployed.
+const expiredRequestMaxAge = time.Hour
+
+// runExpiryScheduler periodically warns the requesters whose forwarding
+// request is about to expire, notifies those whose request expired and
+// refreshes the related Slack views.
+func runExpiryScheduler(ctx context.Context) {
+    ticker := time.NewTicker(expiryCheckInterval)
+    defer ticker.Stop()
+
+    for {
+        if config.Forwarding.ExpiryWarning > 0 {
+            warnExpiringRequests(ctx, time.Duration(config.Forwarding.ExpiryWarning)*time.Minute)
+        }
+        handleExpiredRequests(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func warnExpiringRequests(ctx context.Context, within time.Duration) {
+    requests, err := repo.GetExpiringForwardingRequests(ctx, within)
+    if err != nil {
+        log.Printf("Failed to get expiring forwarding requests: %v", err)
+        return
+    }
+
+    for _, request := range requests {
+        claimed, err := repo.MarkForwardingRequestExpiryWarningSent(ctx, request.Id)
+        if err != nil {
+            log.Printf("Failed to mark the expiry warning of request %s as sent: %v", request.Id, err)
+            continue
+        }
+        if !claimed {
+            continue
+        }
+
+        err = notifyUserBlock(ctx, request.RequesterId, messages.ExpiringSoonMessage(request))
+        if err != nil {
+            log.Printf("Failed to warn %s of the expiration of request %s: %v", request.RequesterId, request.Id, err)
+        }
+    }
+}
+
+func handleExpiredRequests(ctx context.Context) {
+    requests, err := repo.GetExpiredForwardingRequests(ctx, expiredRequestMaxAge)
+    if err != nil {
+        log.Printf("Failed to get expired forwarding requests: %v", err)
+        return
+    }
+
+    for _, request := range requests {
+        handled, err := repo.MarkForwardingRequestExpirationHandled(ctx, request.Id)
+        if err != nil {
+            log.Printf("Failed to mark the expiration of request %s as handled: %v", request.Id, err)
+            continue
+        }
+        if !handled {
+            continue
+        }
+
+        handleExpiredRequest(ctx, request)
+    }
+}
+
+func handleExpiredRequest(ctx context.Context, request *model.ForwardingRequest) {
+    // Requesters who stopped their request themselves already know about it
+    if request.StoppedAt == nil {
+        err := notifyUser(ctx, request.RequesterId, "Your forwarding request has expired, you won't receive texts anymore.")
+        if err != nil {
+            log.Printf("Failed to notify %s of the expiration of request %s: %v", request.RequesterId, request.Id, err)
+        }
+    }
+
+    err := UpdateHome(ctx, request.RequesterId)
+    if err != nil {
+        log.Printf("Failed to update the home of %s: %v", request.RequesterId, err)
+    }
+
+    err = updateApprovalMessage(ctx, request)
+    if err != nil {
+        log.Printf("Failed to update the approval message of request %s: %v", request.Id, err)
+    }
+}
+
+// updateApprovalMessage refreshes the message posted to the admins when the
+// request was created.
+func updateApprovalMessage(ctx context.Context, request *model.ForwardingRequest) error {
+    if request.ApprovalChannel == "" || request.ApprovalTs == "" {
+        return nil
+    }
+
+    _, _, _, err := slackClient.UpdateMessageContext(
+        ctx,
+        request.ApprovalChannel,
+        request.ApprovalTs,
+        slack.MsgOptionBlocks(messages.AcceptRefuseRequestMessage(request).Blocks.BlockSet...),
+    )
+
+    return err
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+    "context"
+    "github.com/CedricFinance/phone_operator/model"
+    "strings"
+    "testing"
+    "time"
+)
+
+func expiredRequest() model.ForwardingRequest {
+    request := activeRequest()
+    expiresAt := time.Now().UTC().Add(-time.Minute)
+    request.ExpiresAt = &expiresAt
+
+    return request
+}
+
+func TestWarnExpiringRequests(t *testing.T) {
+    tests := []struct {
+        name     string
+        claimed  int64
+        warnings int
+    }{
+        {"expiring", 1, 1},
+        // Another instance, or a previous run, already warned the requester
+        {"already warned", 0, 0},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+
+            request := activeRequest()
+            db.OnQuery("expiry_warning_sent_at IS NULL\n ORDER BY expires_at", requestRow(request))
+            db.OnExec("UPDATE ForwardingRequests SET expiry_warning_sent_at", test.claimed, nil)
+
+            warnExpiringRequests(context.Background(), 15*time.Minute)
+
+            if claims := db.Executed("UPDATE ForwardingRequests SET expiry_warning_sent_at"); len(claims) != 1 || claims[0].Args[1] != request.Id {
+                t.Errorf("expected the warning of %s to be claimed once, got: %v", request.Id, claims)
+            }
+
+            posts := fake.Calls("chat.postMessage")
+            if len(posts) != test.warnings {
+                t.Fatalf("expected %d warning(s), got: %d", test.warnings, len(posts))
+            }
+            if test.warnings > 0 && (posts[0].Values.Get("channel") != "DFAKE" || !strings.Contains(posts[0].Values.Get("blocks"), "extend_15m")) {
+                t.Errorf("expected the requester to be offered an extension, got: %v", posts[0].Values)
+            }
+        })
+    }
+}
+
+func TestHandleExpiredRequests(t *testing.T) {
+    tests := []struct {
+        name    string
+        handled int64
+        notices int
+    }{
+        {"expired", 1, 1},
+        // Another instance, or a previous run, already handled it
+        {"already handled", 0, 0},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+
+            request := expiredRequest()
+            db.OnQuery("WHERE expires_at > ? AND expires_at <= ? AND expiration_handled_at IS NULL", requestRow(request))
+            db.OnExec("UPDATE ForwardingRequests SET expiration_handled_at", test.handled, nil)
+
+            handleExpiredRequests(context.Background())
+
+            if claims := db.Executed("UPDATE ForwardingRequests SET expiration_handled_at"); len(claims) != 1 || claims[0].Args[1] != request.Id {
+                t.Errorf("expected the expiration of %s to be claimed once, got: %v", request.Id, claims)
+            }
+
+            if notices := len(fake.Calls("conversations.open")); notices != test.notices {
+                t.Errorf("expected %d notification(s) of the requester, got: %d", test.notices, notices)
+            }
+
+            if updates := len(fake.Calls("chat.update")); updates != test.notices {
+                t.Errorf("expected %d update(s) of the approval message, got: %d", test.notices, updates)
+            }
+        })
+    }
+}
+
+func TestHandleExpiredRequest(t *testing.T) {
+    newFakeRepository(t)
+    fake := newFakeSlack(t)
+
+    request := expiredRequest()
+    handleExpiredRequest(context.Background(), &request)
+
+    posts := fake.Calls("chat.postMessage")
+    if len(fake.Calls("conversations.open")) != 1 || len(posts) != 1 || !strings.Contains(posts[0].Values.Get("text"), "has expired") {
+        t.Errorf("expected the requester to be notified of the expiration, got: %v", posts)
+    }
+
+    if len(fake.Calls("views.publish")) != 1 {
+        t.Errorf("expected the home of the requester to be updated")
+    }
+
+    updates := fake.Calls("chat.update")
+    if len(updates) != 1 || updates[0].Values.Get("channel") != request.ApprovalChannel || updates[0].Values.Get("ts") != request.ApprovalTs {
+        t.Errorf("expected the approval message to be updated, got: %v", updates)
+    }
+}
+
+func TestHandleExpiredRequest_Stopped(t *testing.T) {
+    newFakeRepository(t)
+    fake := newFakeSlack(t)
+
+    // Requesters who stopped their request already know about it
+    request := expiredRequest()
+    request.StoppedAt = request.ExpiresAt
+    request.StoppedBy = request.RequesterId
+    handleExpiredRequest(context.Background(), &request)
+
+    if len(fake.Calls("conversations.open")) != 0 {
+        t.Errorf("expected the requester not to be notified")
+    }
+
+    if len(fake.Calls("chat.update")) != 1 {
+        t.Errorf("expected the approval message to be updated")
+    }
+}
+
+func TestUpdateApprovalMessage_NoMessage(t *testing.T) {
+    fake := newFakeSlack(t)
+
+    // Requests created before the approval messages were recorded
+    request := expiredRequest()
+    request.ApprovalChannel = ""
+    request.ApprovalTs = ""
+
+    err := updateApprovalMessage(context.Background(), &request)
+    if err != nil {
+        t.Errorf("unexpected error: %s", err)
+    }
+
+    if len(fake.Calls("chat.update")) != 0 {
+        t.Errorf("expected no message to be updated")
+    }
+}
+
+func TestRunExpiryScheduler(t *testing.T) {
+    db := newFakeRepository(t)
+    fake := newFakeSlack(t)
+
+    request := expiredRequest()
+    db.OnQuery("WHERE expires_at > ? AND expires_at <= ? AND expiration_handled_at IS NULL", requestRow(request))
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        runExpiryScheduler(ctx)
+        close(done)
+    }()
+
+    // The first run happens right away, without waiting for the ticker
+    deadline := time.Now().Add(5 * time.Second)
+    for len(fake.Calls("conversations.open")) == 0 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    cancel()
+    <-done
+
+    if len(db.Executed("UPDATE ForwardingRequests SET expiration_handled_at")) != 1 {
+        t.Errorf("expected the expired request to be handled once")
+    }
+
+    if len(fake.Calls("conversations.open")) != 1 {
+        t.Errorf("expected the requester to be notified")
+    }
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/CedricFinance/phone_operator/repository"
+    "github.com/slack-go/slack"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// defaultMaxAutoExtension lets the requesters use the extension buttons of
+// the expiration warning without an admin.
+const defaultMaxAutoExtension = time.Hour
+
+// extensionNeedsApproval tells whether an admin must accept extending the
+// request by the given duration. Only an admin can make a request last longer
+// than the maximum duration.
+func extensionNeedsApproval(request *model.ForwardingRequest, duration time.Duration) bool {
+    if duration > maxAutoExtension() {
+        return true
+    }
+
+    return time.Duration(request.Duration)*time.Minute+duration > maxDuration()
+}
+
+func maxAutoExtension() time.Duration {
+    if config.Forwarding.MaxAutoExtension < 0 {
+        return 0
+    }
+
+    if config.Forwarding.MaxAutoExtension > 0 {
+        return time.Duration(config.Forwarding.MaxAutoExtension) * time.Minute
+    }
+
+    return defaultMaxAutoExtension
+}
+
+// requestExtension grants the extension right away when it is short enough,
+// otherwise it asks the admins to accept it. It returns a message for the
+// requester.
+func requestExtension(ctx context.Context, request *model.ForwardingRequest, duration time.Duration, requestedBy string) (string, error) {
+    err := validateDuration(duration)
+    if err != nil {
+        return err.Error(), nil
+    }
+
+    extension := repository.NewExtension(request.Id, int(duration/time.Minute), requestedBy)
+
+    if extensionNeedsApproval(request, duration) {
+        err = repo.SaveExtension(ctx, extension)
+        if err != nil {
+            return "", err
+        }
+
+        _, _, err = slackClient.PostMessageContext(
+            ctx,
+            numberChannel(request.Number),
+            slack.MsgOptionBlocks(messages.AcceptRefuseExtensionMessage(request, extension).Blocks.BlockSet...),
+        )
+        if err != nil {
+            return "", err
+        }
+
+        return "I have forwarded your extension request to the admins", nil
+    }
+
+    err = repo.ExtendForwardingRequest(ctx, extension)
+    if err == repository.InactiveRequest {
+        return "Your forwarding request has already ended, please start a new one.", nil
+    }
+    if err != nil {
+        return "", err
+    }
+
+    request, err = announceExtension(ctx, extension)
+    if err != nil {
+        return "", err
+    }
+
+    return fmt.Sprintf(
+        "Your forwarding request has been extended by %s. I'll forward you the messages until <!date^%d^{date_short_pretty} {time}|%s>",
+        describeDuration(duration),
+        request.ExpiresAt.Unix(),
+        request.ExpiresAt.Format("2006-01-02 15:04:05"),
+    ), nil
+}
+
+// announceExtension lets the admins know about an applied extension in the
+// thread of the approval message and refreshes the related views.
+func announceExtension(ctx context.Context, extension *model.Extension) (*model.ForwardingRequest, error) {
+    request, err := repo.GetForwardingRequest(ctx, extension.ForwardingRequestId)
+    if err != nil {
+        return nil, err
+    }
+
+    if request.ApprovalChannel != "" {
+        _, _, err = slackClient.PostMessageContext(
+            ctx,
+            request.ApprovalChannel,
+            slack.MsgOptionBlocks(messages.ExtendedRequestMessage(request, extension).Blocks.BlockSet...),
+            slack.MsgOptionTS(request.ApprovalTs),
+        )
+        if err != nil {
+            log.Printf("Failed to notify the admins of the extension of request %s: %v", request.Id, err)
+        }
+    }
+
+    err = updateApprovalMessage(ctx, request)
+    if err != nil {
+        log.Printf("Failed to update the approval message of request %s: %v", request.Id, err)
+    }
+
+    err = UpdateHome(ctx, request.RequesterId)
+    if err != nil {
+        log.Printf("Failed to update the home of %s: %v", request.RequesterId, err)
+    }
+
+    return request, nil
+}
+
+func extendSMSForward(ctx context.Context, w http.ResponseWriter, requesterId string, args []string) {
+    // The number, or its alias, can lead the duration, e.g. `bank-line 30m`
+    number := ""
+    if len(args) > 0 {
+        words := strings.SplitN(args[0], " ", 2)
+        if phoneNumber := lookupNumber(words[0]); phoneNumber != nil {
+            number = phoneNumber.Number
+            args = words[1:]
+        }
+    }
+
+    requests, _ := repo.GetForwardingRequests(ctx, requesterId)
+    if number != "" {
+        requests = filterRequestsByNumber(requests, number)
+    }
+
+    var active []*model.ForwardingRequest
+    for _, request := range requests {
+        if request.IsActive() {
+            active = append(active, request)
+        }
+    }
+
+    if len(active) == 0 {
+        fmt.Fprint(w, "You don't have any active forwarding request to extend. Use `/sms start` to request one.")
+        return
+    }
+
+    if len(active) > 1 {
+        fmt.Fprintf(w, "You have several active forwarding requests, which one do you want to extend? Use `/sms extend [number] [duration]` with one of %s.", describeNumbers())
+        return
+    }
+
+    request := active[0]
+    duration, err := parseExtensionDuration(ctx, requesterId, args, *request.ExpiresAt)
+    if err != nil {
+        fmt.Fprint(w, err.Error())
+        return
+    }
+
+    response, err := requestExtension(ctx, request, duration, requesterId)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    fmt.Fprint(w, response)
+}
+
+// handleExtendRequestActions handles the extension buttons of the expiration
+// warning sent to the requester.
+func handleExtendRequestActions(message slack.InteractionCallback, r *http.Request, w http.ResponseWriter) {
+    action := message.ActionCallback.BlockActions[0].ActionID
+    requestId := message.ActionCallback.BlockActions[0].Value
+
+    duration, ok := messages.ExtensionOptions[action]
+    if !ok {
+        fmt.Printf("Unknown extension action %q\n", action)
+        return
+    }
+
+    request, err := repo.GetForwardingRequest(r.Context(), requestId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    if request.RequesterId != message.User.ID {
+        fmt.Printf("%s tried to extend request %s of %s\n", message.User.ID, requestId, request.RequesterId)
+        return
+    }
+
+    response, err := requestExtension(r.Context(), request, duration, message.User.ID)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    slackClient.PostMessage(
+        message.Channel.GroupConversation.Conversation.ID,
+        slack.MsgOptionText(response, false),
+        slack.MsgOptionReplaceOriginal(message.ResponseURL),
+    )
+}
+
+// handleExtensionRequestActions handles the admins' answer to an extension
+// request.
+func handleExtensionRequestActions(message slack.InteractionCallback, r *http.Request, w http.ResponseWriter) {
+    ctx := r.Context()
+    action := message.ActionCallback.BlockActions[0].ActionID
+    extensionId := message.ActionCallback.BlockActions[0].Value
+
+    extension, err := repo.GetExtension(ctx, extensionId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    request, err := repo.GetForwardingRequest(ctx, extension.ForwardingRequestId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    allowed, reason, err := canAnswer(ctx, message.User.ID, action, extension.RequestedBy, request.Number)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+    if !allowed {
+        rejectAction(message, reason)
+        return
+    }
+
+    if action == "accept" {
+        err = repo.AcceptExtension(ctx, extensionId, message.User.ID)
+        if err == repository.InactiveRequest {
+            err = repo.RefuseExtension(ctx, extensionId, message.User.ID)
+        }
+    } else {
+        err = repo.RefuseExtension(ctx, extensionId, message.User.ID)
+    }
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    extension, err = repo.GetExtension(ctx, extensionId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    request, err = repo.GetForwardingRequest(ctx, extension.ForwardingRequestId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    if extension.AcceptedAt != nil {
+        request, err = announceExtension(ctx, extension)
+        if err != nil {
+            fmt.Printf("Error: %v", err)
+            return
+        }
+        notifyUser(
+            ctx,
+            extension.RequestedBy,
+            fmt.Sprintf(
+                "Your extension has been accepted. I'll forward you the messages until <!date^%d^{date_short_pretty} {time}|%s>",
+                request.ExpiresAt.Unix(),
+                request.ExpiresAt.Format("2006-01-02 15:04:05"),
+            ),
+        )
+    } else {
+        notifyUser(ctx, extension.RequestedBy, "Sorry, your extension has been refused.")
+    }
+
+    slackClient.PostMessage(
+        message.Channel.GroupConversation.Conversation.ID,
+        slack.MsgOptionBlocks(messages.AcceptRefuseExtensionMessage(request, extension).Blocks.BlockSet...),
+        slack.MsgOptionReplaceOriginal(message.ResponseURL),
+    )
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+    "context"
+    "database/sql/driver"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/slack-go/slack"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestExtensionNeedsApproval(t *testing.T) {
+    defer func() {
+        config.Forwarding.MaxAutoExtension = 0
+        config.Forwarding.MaxDuration = 0
+    }()
+
+    tests := []struct {
+        maxAutoExtension int
+        maxDuration      int
+        requestDuration  int
+        duration         time.Duration
+        needsApproval    bool
+    }{
+        // The buttons of the expiration warning don't need an approval by default
+        {0, 0, 60, 15 * time.Minute, false},
+        {0, 0, 60, time.Hour, false},
+        {0, 0, 60, 2 * time.Hour, true},
+        {30, 0, 60, 15 * time.Minute, false},
+        {30, 0, 60, time.Hour, true},
+        {-1, 0, 60, 15 * time.Minute, true},
+        // The extended request can't last longer than the maximum duration
+        {0, 120, 60, time.Hour, false},
+        {0, 120, 90, time.Hour, true},
+        {0, 0, 30*24*60 - 10, 15 * time.Minute, true},
+    }
+
+    for _, test := range tests {
+        config.Forwarding.MaxAutoExtension = test.maxAutoExtension
+        config.Forwarding.MaxDuration = test.maxDuration
+
+        request := &model.ForwardingRequest{Duration: test.requestDuration}
+        if got := extensionNeedsApproval(request, test.duration); got != test.needsApproval {
+            t.Errorf("extension of %s of a %d minute(s) request with max_auto_extension %d and max_duration %d: expected needs approval to be %t, got: %t", test.duration, test.requestDuration, test.maxAutoExtension, test.maxDuration, test.needsApproval, got)
+        }
+    }
+}
+
+func activeRequest() model.ForwardingRequest {
+    now := time.Now().UTC()
+    expiresAt := now.Add(10 * time.Minute)
+
+    return model.ForwardingRequest{
+        Id:              "REQUEST",
+        RequesterId:     "UREQUESTER",
+        RequesterName:   "requester",
+        Duration:        60,
+        CreatedAt:       now.Add(-50 * time.Minute),
+        AcceptedAt:      &now,
+        ExpiresAt:       &expiresAt,
+        ApprovalChannel: "CADMIN",
+        ApprovalTs:      "1600000000.000001",
+    }
+}
+
+func TestRequestExtension_Automatic(t *testing.T) {
+    db := newFakeRepository(t)
+    fake := newFakeSlack(t)
+
+    request := activeRequest()
+    db.OnQuery("FROM ForwardingRequests WHERE id = ?", requestRow(request))
+
+    response, err := requestExtension(context.Background(), &request, 15*time.Minute, "UREQUESTER")
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    if !strings.Contains(response, "has been extended by 15 minute(s)") {
+        t.Errorf("expected the extension to be granted, got: %q", response)
+    }
+
+    if extended := db.Executed("UPDATE ForwardingRequests SET expires_at = DATE_ADD"); len(extended) != 1 {
+        t.Errorf("expected the request to be extended once, got: %d", len(extended))
+    }
+
+    // The admins are told in the thread of the approval message
+    posts := fake.Calls("chat.postMessage")
+    if len(posts) != 1 || posts[0].Values.Get("thread_ts") != request.ApprovalTs {
+        t.Errorf("expected the extension to be announced in the approval thread, got: %v", posts)
+    }
+}
+
+func TestRequestExtension_NeedsApproval(t *testing.T) {
+    db := newFakeRepository(t)
+    fake := newFakeSlack(t)
+
+    request := activeRequest()
+
+    response, err := requestExtension(context.Background(), &request, 2*time.Hour, "UREQUESTER")
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    if response != "I have forwarded your extension request to the admins" {
+        t.Errorf("expected the extension to be forwarded to the admins, got: %q", response)
+    }
+
+    if extended := db.Executed("UPDATE ForwardingRequests SET expires_at"); len(extended) != 0 {
+        t.Errorf("expected the request not to be extended before an approval")
+    }
+
+    saved := db.Executed("INSERT INTO Extensions")
+    if len(saved) != 1 || saved[0].Args[5] != nil {
+        t.Fatalf("expected a pending extension to be saved, got: %v", saved)
+    }
+
+    posts := fake.Calls("chat.postMessage")
+    if len(posts) != 1 || posts[0].Values.Get("channel") != config.Slack.Channel {
+        t.Errorf("expected the admins to be asked in their channel, got: %v", posts)
+    }
+}
+
+func TestRequestExtension_MaxDuration(t *testing.T) {
+    config.Forwarding.MaxDuration = 120
+    defer func() { config.Forwarding.MaxDuration = 0 }()
+
+    db := newFakeRepository(t)
+    newFakeSlack(t)
+
+    request := activeRequest()
+    request.Duration = 110
+
+    response, err := requestExtension(context.Background(), &request, 15*time.Minute, "UREQUESTER")
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    if response != "I have forwarded your extension request to the admins" {
+        t.Errorf("expected the extension to be forwarded to the admins, got: %q", response)
+    }
+
+    if extended := db.Executed("UPDATE ForwardingRequests SET expires_at"); len(extended) != 0 {
+        t.Errorf("expected the request not to be extended beyond the maximum duration")
+    }
+}
+
+func TestExtendSMSForward(t *testing.T) {
+    config.Numbers = twoNumbers()
+    defer func() { config.Numbers = nil }()
+
+    bank := activeRequest()
+    bank.Id = "BANK"
+    bank.Number = "+33612345678"
+    other := activeRequest()
+    other.Id = "OTHER"
+    other.Number = "+33698765432"
+
+    tests := []struct {
+        name     string
+        args     []string
+        extended string
+        response string
+    }{
+        {"ambiguous", nil, "", "several active forwarding requests"},
+        {"alias", []string{"bank-line 15m"}, "BANK", "has been extended by 15 minute(s)"},
+        {"number", []string{"+33698765432"}, "OTHER", "has been extended by 1 hour(s)"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newFakeRepository(t)
+            newFakeSlack(t)
+            db.OnQuery("WHERE requester_id = ?", requestRow(bank), requestRow(other))
+            db.OnQuery("FROM ForwardingRequests WHERE id = ?", requestRow(bank))
+
+            w := httptest.NewRecorder()
+            extendSMSForward(context.Background(), w, "UREQUESTER", test.args)
+
+            if !strings.Contains(w.Body.String(), test.response) {
+                t.Errorf("expected the response to contain %q, got: %q", test.response, w.Body.String())
+            }
+
+            extended := db.Executed("UPDATE ForwardingRequests SET expires_at = DATE_ADD")
+            if test.extended == "" {
+                if len(extended) != 0 {
+                    t.Errorf("expected no request to be extended, got: %v", extended)
+                }
+                return
+            }
+
+            if len(extended) != 1 || extended[0].Args[2] != test.extended {
+                t.Errorf("expected %s to be extended, got: %v", test.extended, extended)
+            }
+        })
+    }
+}
+
+func TestHandleExtendRequestActions(t *testing.T) {
+    tests := []struct {
+        userId   string
+        action   string
+        extended bool
+    }{
+        {"UREQUESTER", "extend_15m", true},
+        // Only the requester can extend their request
+        {"UOTHER", "extend_15m", false},
+        {"UREQUESTER", "extend_forever", false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.userId+" "+test.action, func(t *testing.T) {
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+
+            request := activeRequest()
+            db.OnQuery("FROM ForwardingRequests WHERE id = ?", requestRow(request))
+
+            message := slack.InteractionCallback{User: slack.User{ID: test.userId}, ResponseURL: fake.URL + "/response"}
+            message.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: test.action, Value: request.Id, BlockID: "extend_request"}}
+
+            handleExtendRequestActions(message, httptest.NewRequest("POST", "/interactive", nil), httptest.NewRecorder())
+
+            extended := db.Executed("UPDATE ForwardingRequests SET expires_at = DATE_ADD")
+            if (len(extended) == 1) != test.extended {
+                t.Fatalf("expected extended to be %t, got: %v", test.extended, extended)
+            }
+
+            // The warning is replaced by the outcome of the extension
+            responses := fake.Calls("response")
+            if test.extended && (len(responses) != 1 || !strings.Contains(responses[0].Body, "has been extended by 15 minute(s)")) {
+                t.Errorf("expected the warning to be replaced, got: %v", responses)
+            }
+            if !test.extended && len(responses) != 0 {
+                t.Errorf("expected the warning to be kept, got: %v", responses)
+            }
+        })
+    }
+}
+
+func extensionAnswer(fake *fakeSlack, userId string, action string) slack.InteractionCallback {
+    message := slack.InteractionCallback{
+        User:        slack.User{ID: userId},
+        ResponseURL: fake.URL + "/response",
+    }
+    message.Channel.ID = "CADMIN"
+    message.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: action, Value: "EXTENSION"}}
+
+    return message
+}
+
+func TestHandleExtensionRequestActions(t *testing.T) {
+    config.Slack.Admins = []string{"UADMIN"}
+    defer func() { config.Slack.Admins = nil }()
+
+    tests := []struct {
+        userId   string
+        action   string
+        accepted bool
+        refused  bool
+        notified bool
+    }{
+        {"UADMIN", "accept", true, false, true},
+        {"UADMIN", "refuse", false, true, true},
+        {"UOTHER", "accept", false, false, false},
+        {"UREQUESTER", "accept", false, false, false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.userId+" "+test.action, func(t *testing.T) {
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+
+            request := activeRequest()
+            db.OnQuery("FROM ForwardingRequests WHERE id = ?", requestRow(request))
+            db.OnQuery("FROM Extensions WHERE id = ? AND accepted_at IS NULL", []driver.Value{request.Id, int64(120)})
+            db.OnQuery("FROM Extensions WHERE id = ? LIMIT 1", []driver.Value{"EXTENSION", request.Id, int64(120), "UREQUESTER", time.Now().UTC(), nil, nil, ""})
+
+            handleExtensionRequestActions(extensionAnswer(fake, test.userId, test.action), httptest.NewRequest("POST", "/interactive", nil), httptest.NewRecorder())
+
+            if accepted := len(db.Executed("UPDATE Extensions SET accepted_at")) == 1; accepted != test.accepted {
+                t.Errorf("expected accepted to be %t, got: %t", test.accepted, accepted)
+            }
+
+            if extended := len(db.Executed("UPDATE ForwardingRequests SET expires_at = DATE_ADD")) == 1; extended != test.accepted {
+                t.Errorf("expected extended to be %t, got: %t", test.accepted, extended)
+            }
+
+            if refused := len(db.Executed("UPDATE Extensions SET refused_at")) == 1; refused != test.refused {
+                t.Errorf("expected refused to be %t, got: %t", test.refused, refused)
+            }
+
+            if notified := len(fake.Calls("conversations.open")) == 1; notified != test.notified {
+                t.Errorf("expected the requester to be notified: %t, got: %t", test.notified, notified)
+            }
+
+            if len(fake.Calls("response")) != 1 {
+                t.Errorf("expected the answer to be shown to %s", test.userId)
+            }
+        })
+    }
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/CedricFinance/phone_operator/repository"
+    "github.com/slack-go/slack"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// fakeDB is a database/sql driver answering the queries of the repository
+// with canned rows, so that the handlers can be tested without MySQL.
+// Unexpected queries return no rows and unexpected statements affect 1 row.
+type fakeDB struct {
+    mu         sync.Mutex
+    results    []fakeResult
+    statements []fakeStatement
+}
+
+type fakeResult struct {
+    query    string
+    rows     [][]driver.Value
+    affected int64
+    err      error
+}
+
+type fakeStatement struct {
+    Query string
+    Args  []driver.Value
+}
+
+// newFakeRepository replaces the repository with one backed by a fakeDB for
+// the duration of the test.
+func newFakeRepository(t *testing.T) *fakeDB {
+    db := &fakeDB{}
+
+    previous := repo
+    repo = repository.New(sql.OpenDB(db))
+    t.Cleanup(func() { repo = previous })
+
+    return db
+}
+
+// OnQuery answers the queries containing query with rows.
+func (db *fakeDB) OnQuery(query string, rows ...[]driver.Value) {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    db.results = append(db.results, fakeResult{query: query, rows: rows})
+}
+
+// OnExec makes the statements containing query affect the given number of
+// rows, or fail when err isn't nil.
+func (db *fakeDB) OnExec(query string, affected int64, err error) {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    db.results = append(db.results, fakeResult{query: query, affected: affected, err: err})
+}
+
+// Executed returns the queries and statements containing query which were
+// executed.
+func (db *fakeDB) Executed(query string) []fakeStatement {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+
+    var statements []fakeStatement
+    for _, statement := range db.statements {
+        if strings.Contains(statement.Query, query) {
+            statements = append(statements, statement)
+        }
+    }
+    return statements
+}
+
+func (db *fakeDB) record(query string, args []driver.NamedValue) {
+    values := make([]driver.Value, len(args))
+    for i, arg := range args {
+        values[i] = arg.Value
+    }
+
+    db.mu.Lock()
+    defer db.mu.Unlock()
+    db.statements = append(db.statements, fakeStatement{Query: query, Args: values})
+}
+
+func (db *fakeDB) result(query string) (fakeResult, bool) {
+    db.mu.Lock()
+    defer db.mu.Unlock()
+
+    for _, result := range db.results {
+        if strings.Contains(query, result.query) {
+            return result, true
+        }
+    }
+    return fakeResult{}, false
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) {
+    return &fakeConn{db: db}, nil
+}
+
+func (db *fakeDB) Driver() driver.Driver {
+    return nil
+}
+
+type fakeConn struct {
+    db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+    return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+    return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+    return fakeTx{}, nil
+}
+
+// fakeTx runs the statements of a transaction like the other ones
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+    return nil
+}
+
+func (fakeTx) Rollback() error {
+    return nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+    c.db.record(query, args)
+
+    result, _ := c.db.result(query)
+    if result.err != nil {
+        return nil, result.err
+    }
+
+    return &fakeRows{rows: result.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+    c.db.record(query, args)
+
+    result, ok := c.db.result(query)
+    if !ok {
+        return driver.RowsAffected(1), nil
+    }
+    if result.err != nil {
+        return nil, result.err
+    }
+
+    return driver.RowsAffected(result.affected), nil
+}
+
+type fakeRows struct {
+    rows [][]driver.Value
+    next int
+}
+
+func (r *fakeRows) Columns() []string {
+    if len(r.rows) == 0 {
+        return nil
+    }
+
+    columns := make([]string, len(r.rows[0]))
+    for i := range columns {
+        columns[i] = fmt.Sprintf("column%d", i)
+    }
+    return columns
+}
+
+func (r *fakeRows) Close() error {
+    return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+    if r.next >= len(r.rows) {
+        return io.EOF
+    }
+
+    copy(dest, r.rows[r.next])
+    r.next++
+    return nil
+}
+
+// requestRow returns the columns of the repository's forwarding request
+// queries for request.
+func requestRow(request model.ForwardingRequest) []driver.Value {
+    row := []driver.Value{
+        request.Id,
+        request.RequesterId,
+        request.RequesterName,
+        int64(request.Duration),
+        request.CreatedAt,
+        nil,
+        nil,
+        nil,
+        request.AnsweredBy,
+        nil,
+        request.StoppedBy,
+        request.ApprovalChannel,
+        request.ApprovalTs,
+        nil,
+        request.ScheduleId,
+        request.Number,
+    }
+
+    if request.AcceptedAt != nil {
+        row[5] = *request.AcceptedAt
+    }
+    if request.RefusedAt != nil {
+        row[6] = *request.RefusedAt
+    }
+    if request.ExpiresAt != nil {
+        row[7] = *request.ExpiresAt
+    }
+    if request.StoppedAt != nil {
+        row[9] = *request.StoppedAt
+    }
+    if request.StartsAt != nil {
+        row[13] = *request.StartsAt
+    }
+
+    return row
+}
+
+// fakeSlack records the calls made to the Slack Web API.
+type fakeSlack struct {
+    URL      string
+    mu       sync.Mutex
+    calls    []fakeSlackCall
+    failures map[string]bool
+}
+
+type fakeSlackCall struct {
+    Method string
+    Values url.Values
+    Body   string
+}
+
+// newFakeSlack replaces the Slack client with one calling a fake API for
+// the duration of the test. Response URLs can point to URL + "/response".
+func newFakeSlack(t *testing.T) *fakeSlack {
+    fake := &fakeSlack{failures: map[string]bool{}}
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        values, _ := url.ParseQuery(string(body))
+        method := strings.TrimPrefix(r.URL.Path, "/")
+
+        fake.mu.Lock()
+        fake.calls = append(fake.calls, fakeSlackCall{Method: method, Values: values, Body: string(body)})
+        failed := fake.failures[method]
+        fake.mu.Unlock()
+
+        w.Header().Set("Content-Type", "application/json")
+        if failed {
+            fmt.Fprint(w, `{"ok":false,"error":"fatal_error"}`)
+            return
+        }
+
+        switch method {
+        case "conversations.open":
+            fmt.Fprint(w, `{"ok":true,"channel":{"id":"DFAKE"}}`)
+        case "chat.postMessage", "chat.update":
+            fmt.Fprint(w, `{"ok":true,"channel":"CFAKE","ts":"1600000000.000100"}`)
+        case "users.info":
+            fmt.Fprint(w, `{"ok":true,"user":{"id":"UFAKE","tz":"Europe/Paris"}}`)
+        case "views.open", "views.publish":
+            fmt.Fprint(w, `{"ok":true,"view":{"id":"VFAKE"}}`)
+        default:
+            fmt.Fprint(w, `{"ok":true}`)
+        }
+    }))
+
+    fake.URL = server.URL
+    previous := slackClient
+    slackClient = slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/"))
+    t.Cleanup(func() {
+        slackClient = previous
+        server.Close()
+    })
+
+    return fake
+}
+
+// Fail makes the calls to the given API method fail.
+func (s *fakeSlack) Fail(method string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.failures[method] = true
+}
+
+// Calls returns the calls made to the given API method.
+func (s *fakeSlack) Calls(method string) []fakeSlackCall {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var calls []fakeSlackCall
+    for _, call := range s.calls {
+        if call.Method == method {
+            calls = append(calls, call)
+        }
+    }
+    return calls
+}
+
+// recipientRow returns the columns of the repository's outbox entry queries
+// for a post of the message to the user.
+func recipientRow(messageId string, userId string) []driver.Value {
+    now := time.Now().UTC()
+    return []driver.Value{"ENTRY-" + userId, messageId, model.OutboxKindUser, userId, "", "", int64(1), now, now, now, nil, nil}
+}
+
+// SentSMS is a text sent through a FakeSMSSender.
+type SentSMS struct {
+    From string
+    To   string
+    Body string
+}
+
+// FakeSMSSender records the texts instead of sending them.
+type FakeSMSSender struct {
+    // Err is returned by SendSMS when set
+    Err error
+
+    mu   sync.Mutex
+    sent []SentSMS
+}
+
+func (s *FakeSMSSender) SendSMS(ctx context.Context, from string, to string, body string) error {
+    if s.Err != nil {
+        return s.Err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.sent = append(s.sent, SentSMS{From: from, To: to, Body: body})
+
+    return nil
+}
+
+// Sent returns the recorded texts.
+func (s *FakeSMSSender) Sent() []SentSMS {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return append([]SentSMS{}, s.sent...)
+}
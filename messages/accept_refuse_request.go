@@ -4,20 +4,41 @@ import (
 	"fmt"
 	"github.com/CedricFinance/phone_operator/model"
 	"github.com/slack-go/slack"
+	"time"
 )
 
 func AcceptRefuseRequestMessage(request *model.ForwardingRequest) slack.Message {
-	return slack.NewBlockMessage(
+	blocks := []slack.Block{
 		acceptRefuseMessageBlock(request),
 		acceptRefuseActionsBlock(request),
-	)
+	}
+
+	if request.IsActive() {
+		blocks = append(blocks, revokeActionsBlock(request))
+	}
+
+	return slack.NewBlockMessage(blocks...)
 }
 
 func acceptRefuseMessageBlock(request *model.ForwardingRequest) *slack.SectionBlock {
+	text := fmt.Sprintf("<@%s> want's to receive texts%s for %d minute(s)", request.RequesterId, numberText(request), request.Duration)
+	if request.StartsAt != nil {
+		end := request.End()
+		text = fmt.Sprintf(
+			"<@%s> want's to receive texts%s from <!date^%d^{date_short_pretty} {time}|%s> to <!date^%d^{date_short_pretty} {time}|%s>",
+			request.RequesterId,
+			numberText(request),
+			request.StartsAt.Unix(),
+			request.StartsAt.String(),
+			end.Unix(),
+			end.String(),
+		)
+	}
+
 	return slack.NewSectionBlock(
 		slack.NewTextBlockObject(
 			slack.MarkdownType,
-			fmt.Sprintf("<@%s> want's to receive texts for %d minute(s)", request.RequesterId, request.Duration),
+			text,
 			false,
 			false,
 		),
@@ -28,48 +49,154 @@ func acceptRefuseMessageBlock(request *model.ForwardingRequest) *slack.SectionBl
 
 func acceptRefuseActionsBlock(request *model.ForwardingRequest) slack.Block {
 	if request.AcceptedAt != nil {
+		elements := []slack.MixedElement{
+			answerTextObject(":thumbsup: Accepted", request.AcceptedAt, requestAnsweredBy(request)),
+		}
+
+		if end := requestEndText(request); end != "" {
+			elements = append(elements, slack.NewTextBlockObject(slack.MarkdownType, end, false, false))
+		}
+
+		return slack.NewContextBlock("accepted", elements...)
+	}
+
+	if request.RefusedAt != nil {
 		return slack.NewContextBlock(
-			"accepted",
+			"refused",
+			answerTextObject(":thumbsdown: Refused", request.RefusedAt, requestAnsweredBy(request)),
+		)
+	}
+
+	if request.StoppedAt != nil {
+		return slack.NewContextBlock(
+			"cancelled",
+			answerTextObject(":no_entry_sign: Cancelled", request.StoppedAt, userReference(request.StoppedBy)),
+		)
+	}
+
+	return acceptRefuseButtons("forwarding_request", request.Id)
+}
+
+func revokeActionsBlock(request *model.ForwardingRequest) *slack.ActionBlock {
+	return slack.NewActionBlock(
+		"forwarding_request",
+		slack.NewButtonBlockElement(
+			"revoke",
+			request.Id,
+			slack.NewTextBlockObject(slack.PlainTextType, ":no_entry: Revoke", false, false)).WithStyle(slack.StyleDanger),
+	)
+}
+
+// AcceptRefuseExtensionMessage asks the admins to answer an extension that
+// exceeds what can be granted automatically.
+func AcceptRefuseExtensionMessage(request *model.ForwardingRequest, extension *model.Extension) slack.Message {
+	return slack.NewBlockMessage(
+		slack.NewSectionBlock(
 			slack.NewTextBlockObject(
 				slack.MarkdownType,
 				fmt.Sprintf(
-					":thumbsup: Accepted <!date^%d^{date_short_pretty}|%s> by <@%s>",
-					request.AcceptedAt.Unix(),
-					request.AcceptedAt.String(),
-					request.AnsweredBy,
+					"<@%s> want's to extend their forwarding by %d minute(s). It currently ends <!date^%d^{date_short_pretty} {time}|%s>",
+					extension.RequestedBy,
+					extension.Duration,
+					request.ExpiresAt.Unix(),
+					request.ExpiresAt.String(),
 				),
 				false,
 				false,
 			),
+			nil,
+			nil,
+		),
+		acceptRefuseExtensionActionsBlock(extension),
+	)
+}
+
+func acceptRefuseExtensionActionsBlock(extension *model.Extension) slack.Block {
+	if extension.AcceptedAt != nil {
+		return slack.NewContextBlock(
+			"accepted",
+			answerTextObject(":thumbsup: Accepted", extension.AcceptedAt, userReference(extension.AnsweredBy)),
 		)
 	}
 
-	if request.RefusedAt != nil {
+	if extension.RefusedAt != nil {
 		return slack.NewContextBlock(
 			"refused",
-			slack.NewTextBlockObject(
-				slack.MarkdownType,
-				fmt.Sprintf(
-					":thumbsdown: Refused <!date^%d^{date_short_pretty}|%s> by <@%s>",
-					request.RefusedAt.Unix(),
-					request.RefusedAt.String(),
-					request.AnsweredBy,
-				),
-				false,
-				false,
-			),
+			answerTextObject(":thumbsdown: Refused", extension.RefusedAt, userReference(extension.AnsweredBy)),
 		)
 	}
 
+	return acceptRefuseButtons("extension_request", extension.Id)
+}
+
+func answerTextObject(status string, answeredAt *time.Time, answeredBy string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(
+		slack.MarkdownType,
+		fmt.Sprintf(
+			"%s <!date^%d^{date_short_pretty}|%s> by %s",
+			status,
+			answeredAt.Unix(),
+			answeredAt.String(),
+			answeredBy,
+		),
+		false,
+		false,
+	)
+}
+
+func acceptRefuseButtons(blockId string, value string) *slack.ActionBlock {
 	return slack.NewActionBlock(
-		"forwarding_request",
+		blockId,
 		slack.NewButtonBlockElement(
 			"accept",
-			request.Id,
+			value,
 			slack.NewTextBlockObject(slack.PlainTextType, ":thumbsup: Accept", false, false)).WithStyle(slack.StylePrimary),
 		slack.NewButtonBlockElement(
 			"refuse",
-			request.Id,
+			value,
 			slack.NewTextBlockObject(slack.PlainTextType, ":thumbsdown: Refuse", false, false)).WithStyle(slack.StyleDanger),
 	)
 }
+
+func requestEndText(request *model.ForwardingRequest) string {
+	if request.IsRevoked() {
+		return fmt.Sprintf(
+			":no_entry: Revoked <!date^%d^{date_short_pretty} {time}|%s> by <@%s>",
+			request.StoppedAt.Unix(),
+			request.StoppedAt.String(),
+			request.StoppedBy,
+		)
+	}
+
+	if request.StoppedAt != nil {
+		return fmt.Sprintf(
+			":octagonal_sign: Stopped <!date^%d^{date_short_pretty} {time}|%s>",
+			request.StoppedAt.Unix(),
+			request.StoppedAt.String(),
+		)
+	}
+
+	if request.IsExpired() {
+		return fmt.Sprintf(
+			":hourglass: Expired <!date^%d^{date_short_pretty} {time}|%s>",
+			request.ExpiresAt.Unix(),
+			request.ExpiresAt.String(),
+		)
+	}
+
+	return ""
+}
+
+// requestAnsweredBy references the admin or the auto-approval policy which
+// answered the request.
+func requestAnsweredBy(request *model.ForwardingRequest) string {
+	if policy, ok := request.AnsweredByPolicy(); ok {
+		return fmt.Sprintf(":robot_face: policy *%s*", policy)
+	}
+
+	return userReference(request.AnsweredBy)
+}
+
+func userReference(userId string) string {
+	return fmt.Sprintf("<@%s>", userId)
+}
@@ -0,0 +1,67 @@
+package messages
+
+import (
+	"fmt"
+	"github.com/CedricFinance/phone_operator/model"
+	"github.com/slack-go/slack"
+	"time"
+)
+
+// ExtensionOptions are the extensions offered with the expiration warning,
+// indexed by action id.
+var ExtensionOptions = map[string]time.Duration{
+	"extend_15m": 15 * time.Minute,
+	"extend_1h":  time.Hour,
+}
+
+func ExpiringSoonMessage(request *model.ForwardingRequest) slack.Message {
+	return slack.NewBlockMessage(
+		slack.NewSectionBlock(
+			slack.NewTextBlockObject(
+				slack.MarkdownType,
+				fmt.Sprintf(
+					":hourglass_flowing_sand: Your forwarding request expires <!date^%d^{time}|%s>. Do you need more time?",
+					request.ExpiresAt.Unix(),
+					request.ExpiresAt.Format("2006-01-02 15:04:05"),
+				),
+				false,
+				false,
+			),
+			nil,
+			nil,
+		),
+		slack.NewActionBlock(
+			"extend_request",
+			slack.NewButtonBlockElement(
+				"extend_15m",
+				request.Id,
+				slack.NewTextBlockObject(slack.PlainTextType, "Extend 15m", false, false)).WithStyle(slack.StylePrimary),
+			slack.NewButtonBlockElement(
+				"extend_1h",
+				request.Id,
+				slack.NewTextBlockObject(slack.PlainTextType, "Extend 1h", false, false)),
+		),
+	)
+}
+
+func ExtendedRequestMessage(request *model.ForwardingRequest, extension *model.Extension) slack.Message {
+	return slack.NewBlockMessage(
+		slack.NewSectionBlock(
+			slack.NewTextBlockObject(
+				slack.MarkdownType,
+				fmt.Sprintf(
+					":heavy_plus_sign: <@%s> extended the forwarding by %d minute(s). Texts will be forwarded to <@%s> until <!date^%d^{date_short_pretty} {time}|%s>",
+					extension.RequestedBy,
+					extension.Duration,
+					request.RequesterId,
+					request.ExpiresAt.Unix(),
+					request.ExpiresAt.Format("2006-01-02 15:04:05"),
+				),
+				false,
+				false,
+			),
+			nil,
+			nil,
+		),
+	)
+}
@@ -4,17 +4,19 @@ import (
 	"fmt"
 	"github.com/CedricFinance/phone_operator/model"
 	"github.com/slack-go/slack"
-	"time"
 )
 
-func HomeMessage(requests []*model.ForwardingRequest) slack.Message {
+func HomeMessage(requests []*model.ForwardingRequest, schedules []*model.RecurringSchedule) slack.Message {
 	var activeRequests []*model.ForwardingRequest
+	var scheduledRequests []*model.ForwardingRequest
 	var pendingRequests []*model.ForwardingRequest
 	var pastRequests []*model.ForwardingRequest
 
 	for _, request := range requests {
 		if request.IsActive() {
 			activeRequests = append(activeRequests, request)
+		} else if request.IsScheduled() {
+			scheduledRequests = append(scheduledRequests, request)
 		} else if request.IsPending() {
 			pendingRequests = append(pendingRequests, request)
 		} else {
@@ -38,6 +40,34 @@ func HomeMessage(requests []*model.ForwardingRequest) slack.Message {
 	blocks = addRequestsBlocks(activeRequests, blocks)
 	blocks = append(blocks, slack.NewContextBlock("", slack.NewImageBlockElement("https://api.slack.com/img/blocks/bkb_template_images/placeholder.png", "placeholder")))
 
+	blocks = append(blocks, slack.NewSectionBlock(
+		slack.NewTextBlockObject(
+			slack.MarkdownType,
+			"*Your scheduled requests*",
+			false,
+			false,
+		),
+		nil,
+		nil,
+	))
+	blocks = append(blocks, slack.NewDividerBlock())
+	blocks = addRequestsBlocks(scheduledRequests, blocks)
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewImageBlockElement("https://api.slack.com/img/blocks/bkb_template_images/placeholder.png", "placeholder")))
+
+	blocks = append(blocks, slack.NewSectionBlock(
+		slack.NewTextBlockObject(
+			slack.MarkdownType,
+			"*Your recurring schedules*",
+			false,
+			false,
+		),
+		nil,
+		nil,
+	))
+	blocks = append(blocks, slack.NewDividerBlock())
+	blocks = addSchedulesBlocks(schedules, blocks)
+	blocks = append(blocks, slack.NewContextBlock("", slack.NewImageBlockElement("https://api.slack.com/img/blocks/bkb_template_images/placeholder.png", "placeholder")))
+
 	blocks = append(blocks, slack.NewSectionBlock(
 		slack.NewTextBlockObject(
 			slack.MarkdownType,
@@ -88,20 +118,37 @@ func addRequestsBlocks(activeRequests []*model.ForwardingRequest, blocks []slack
 func getMessage(request *model.ForwardingRequest) string {
 	if request.IsActive() {
 		return fmt.Sprintf(
-			"*<!date^%d^{date_short_pretty} {time}|%s>* You'll receive text messages until <!date^%d^{date_short_pretty} {time}|%s>\n*Status*: %s",
+			"*<!date^%d^{date_short_pretty} {time}|%s>* You'll receive text messages%s until <!date^%d^{date_short_pretty} {time}|%s>\n*Status*: %s",
 			request.CreatedAt.Unix(),
 			request.CreatedAt.String(),
+			numberText(request),
 			request.ExpiresAt.Unix(),
 			request.ExpiresAt.String(),
 			getStatus(request),
 		)
 	}
 
+	if request.IsScheduled() {
+		end := request.End()
+		return fmt.Sprintf(
+			"*<!date^%d^{date_short_pretty} {time}|%s>* You'll receive text messages%s from <!date^%d^{date_short_pretty} {time}|%s> to <!date^%d^{date_short_pretty} {time}|%s>\n*Status*: %s",
+			request.CreatedAt.Unix(),
+			request.CreatedAt.String(),
+			numberText(request),
+			request.StartsAt.Unix(),
+			request.StartsAt.String(),
+			end.Unix(),
+			end.String(),
+			getStatus(request),
+		)
+	}
+
 	if request.AcceptedAt != nil {
 		return fmt.Sprintf(
-			"*<!date^%d^{date_short_pretty} {time}|%s>* You receveid text messages from <!date^%d^{date_short_pretty} {time}|%s> to <!date^%d^{date_short_pretty} {time}|%s>\n*Status*: %s",
+			"*<!date^%d^{date_short_pretty} {time}|%s>* You receveid text messages%s from <!date^%d^{date_short_pretty} {time}|%s> to <!date^%d^{date_short_pretty} {time}|%s>\n*Status*: %s",
 			request.CreatedAt.Unix(),
 			request.CreatedAt.String(),
+			numberText(request),
 			request.AcceptedAt.Unix(),
 			request.AcceptedAt.String(),
 			request.ExpiresAt.Unix(),
@@ -111,19 +158,25 @@ func getMessage(request *model.ForwardingRequest) string {
 	}
 
 	return fmt.Sprintf(
-		"*<!date^%d^{date_short_pretty} {time}|%s>* You asked to receive text messages for %d minute(s)\n*Status*: %s",
+		"*<!date^%d^{date_short_pretty} {time}|%s>* You asked to receive text messages%s for %d minute(s)\n*Status*: %s",
 		request.CreatedAt.Unix(),
 		request.CreatedAt.String(),
+		numberText(request),
 		request.Duration,
 		getStatus(request),
 	)
 }
 
 func getAccessory(request *model.ForwardingRequest) *slack.Accessory {
-	if request.AcceptedAt != nil && request.ExpiresAt.After(time.Now().UTC()) {
+	if request.IsCancelable() {
+		label := "Stop"
+		if !request.IsActive() {
+			label = "Cancel"
+		}
+
 		return slack.NewAccessory(
 			slack.NewButtonBlockElement(
-				"stop", request.Id, slack.NewTextBlockObject(slack.PlainTextType, "Stop", false, false),
+				"stop", request.Id, slack.NewTextBlockObject(slack.PlainTextType, label, false, false),
 			).WithStyle(slack.StyleDanger),
 		)
 	}
@@ -132,11 +185,15 @@ func getAccessory(request *model.ForwardingRequest) *slack.Accessory {
 
 func getStatus(request *model.ForwardingRequest) interface{} {
 	if request.AcceptedAt != nil {
-		return fmt.Sprintf(":thumbsup: Accepted by <@%s>", request.AnsweredBy)
+		return fmt.Sprintf(":thumbsup: Accepted by %s", requestAnsweredBy(request))
 	}
 
 	if request.RefusedAt != nil {
-		return fmt.Sprintf(":thumbsdown: Refused by <@%s>", request.AnsweredBy)
+		return fmt.Sprintf(":thumbsdown: Refused by %s", requestAnsweredBy(request))
+	}
+
+	if request.StoppedAt != nil {
+		return ":no_entry_sign: Cancelled"
 	}
 
 	return ":question: Waiting for admin's answer"
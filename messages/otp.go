@@ -0,0 +1,161 @@
+package messages
+
+import (
+    "regexp"
+    "strings"
+)
+
+var codePattern = regexp.MustCompile(`\b(\d{4,8}|\d{3}[- ]\d{3})\b`)
+
+// servicePatterns find the sender's service in common code templates. The
+// service is the first group.
+var servicePatterns = []*regexp.Regexp{
+    // Your Acme verification code is 123456, 123456 is your Acme code
+    regexp.MustCompile(`(?i)\byour ([\w.&' -]{1,30}?) (?:(?:verification|security|login|sign-in|one[- ]time|confirmation|access|authentication) )?(?:code|pin|otp|passcode|password)\b`),
+    // Your code for Acme is 123456
+    regexp.MustCompile(`(?i)\b(?:code|pin|otp|passcode) for ([\w.&' -]{1,30}?)(?: is\b|:|\.|,|$)`),
+    // [Acme] 123456 is your code, Acme: your code is 123456
+    regexp.MustCompile(`^\s*\[([\w.&' -]{1,30})\]`),
+    regexp.MustCompile(`^\s*([\w.&' -]{1,30}):`),
+}
+
+// genericServices are words captured by servicePatterns which don't name a
+// service.
+var genericServices = []string{"verification", "security", "login", "sign-in", "one-time", "one time", "confirmation", "access", "authentication", "2fa", "the", "a", "this", "otp", "code"}
+
+// VerificationCode is a one-time code found in a text.
+type VerificationCode struct {
+    Code string
+    // Service is the name of the service sending the code, if found
+    Service string
+}
+
+// FindVerificationCode looks for a code in texts mentioning one.
+func FindVerificationCode(body string) (VerificationCode, bool) {
+    if !hasOTPKeyword(body, nil) {
+        return VerificationCode{}, false
+    }
+
+    code := codePattern.FindString(body)
+    if code == "" {
+        return VerificationCode{}, false
+    }
+
+    return VerificationCode{
+        Code:    strings.NewReplacer("-", "", " ", "").Replace(code),
+        Service: findService(body),
+    }, true
+}
+
+func findService(body string) string {
+    for _, pattern := range servicePatterns {
+        result := pattern.FindStringSubmatch(body)
+        if result == nil {
+            continue
+        }
+
+        service := strings.TrimSpace(result[1])
+        if service != "" && !isGenericService(service) && !hasOTPKeyword(service, nil) {
+            return service
+        }
+    }
+
+    return ""
+}
+
+func isGenericService(service string) bool {
+    for _, generic := range genericServices {
+        if strings.EqualFold(service, generic) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// OTPKeywords are the words announcing a one-time code.
+var OTPKeywords = []string{
+    "code",
+    "otp",
+    "one-time",
+    "one time",
+    "passcode",
+    "password",
+    "pin",
+    "verification",
+    "verify",
+    "2fa",
+    "token",
+    "login",
+    "sign in",
+    "authenticate",
+    "authentication",
+    "authenticator",
+}
+
+// otpKeywordPattern matches the OTPKeywords as whole words, e.g. not the "pin"
+// of "shipping".
+var otpKeywordPattern = keywordPattern(OTPKeywords)
+
+// Redaction hides the one-time codes of the texts posted in channels.
+type Redaction struct {
+    Enabled bool
+    // AllCodes redacts every 4 to 8 digit number, not only the ones of texts
+    // containing one of the keywords.
+    AllCodes bool
+    // Keywords are added to OTPKeywords
+    Keywords []string
+}
+
+// Redact replaces the codes of the body by bullets. It returns false when
+// nothing was redacted.
+func (r Redaction) Redact(body string) (string, bool) {
+    if !r.Enabled || (!r.AllCodes && !hasOTPKeyword(body, r.Keywords)) {
+        return body, false
+    }
+
+    redacted := codePattern.ReplaceAllStringFunc(body, func(code string) string {
+        return strings.Repeat("•", len(code))
+    })
+
+    return redacted, redacted != body
+}
+
+func hasOTPKeyword(body string, keywords []string) bool {
+    if otpKeywordPattern.MatchString(body) {
+        return true
+    }
+
+    pattern := keywordPattern(keywords)
+    return pattern != nil && pattern.MatchString(body)
+}
+
+// keywordPattern returns a case insensitive regular expression matching any of
+// the keywords as a whole word, nil when there is no keyword.
+func keywordPattern(keywords []string) *regexp.Regexp {
+    var alternatives []string
+    for _, keyword := range keywords {
+        if keyword == "" {
+            continue
+        }
+
+        pattern := regexp.QuoteMeta(keyword)
+        if isWordCharacter(keyword[0]) {
+            pattern = `\b` + pattern
+        }
+        if isWordCharacter(keyword[len(keyword)-1]) {
+            pattern += `\b`
+        }
+        alternatives = append(alternatives, pattern)
+    }
+
+    if len(alternatives) == 0 {
+        return nil
+    }
+
+    return regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+}
+
+func isWordCharacter(c byte) bool {
+    return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,87 @@
+package messages
+
+import (
+	"fmt"
+	"github.com/CedricFinance/phone_operator/model"
+	"github.com/slack-go/slack"
+	"strings"
+)
+
+// DescribeSchedule returns e.g. "every Mon, Tue from 19:00 to 08:00 (Europe/Paris)"
+func DescribeSchedule(schedule *model.RecurringSchedule) string {
+	days := make([]string, len(schedule.Weekdays))
+	for i, weekday := range schedule.Weekdays {
+		days[i] = weekday.String()[:3]
+	}
+
+	description := fmt.Sprintf(
+		"every %s from %s to %s (%s)",
+		strings.Join(days, ", "),
+		schedule.StartTime,
+		schedule.EndTime,
+		schedule.Timezone,
+	)
+	if schedule.Number != "" {
+		description += fmt.Sprintf(" on `%s`", schedule.Number)
+	}
+
+	return description
+}
+
+func AcceptRefuseScheduleMessage(schedule *model.RecurringSchedule) slack.Message {
+	return slack.NewBlockMessage(
+		markdownSection(fmt.Sprintf("<@%s> want's to receive texts %s", schedule.RequesterId, DescribeSchedule(schedule))),
+		acceptRefuseScheduleActionsBlock(schedule),
+	)
+}
+
+func acceptRefuseScheduleActionsBlock(schedule *model.RecurringSchedule) slack.Block {
+	if schedule.ApprovedAt != nil {
+		return slack.NewContextBlock(
+			"accepted",
+			answerTextObject(":thumbsup: Accepted", schedule.ApprovedAt, userReference(schedule.AnsweredBy)),
+		)
+	}
+
+	if schedule.RefusedAt != nil {
+		return slack.NewContextBlock(
+			"refused",
+			answerTextObject(":thumbsdown: Refused", schedule.RefusedAt, userReference(schedule.AnsweredBy)),
+		)
+	}
+
+	return acceptRefuseButtons("recurring_schedule", schedule.Id)
+}
+
+func addSchedulesBlocks(schedules []*model.RecurringSchedule, blocks []slack.Block) []slack.Block {
+	for _, schedule := range schedules {
+		blocks = append(blocks, slack.NewSectionBlock(
+			slack.NewTextBlockObject(
+				slack.MarkdownType,
+				fmt.Sprintf("You'll receive texts %s\n*Status*: %s", DescribeSchedule(schedule), getScheduleStatus(schedule)),
+				false,
+				false,
+			),
+			nil,
+			slack.NewAccessory(
+				slack.NewButtonBlockElement(
+					"delete_schedule", schedule.Id, slack.NewTextBlockObject(slack.PlainTextType, "Delete", false, false),
+				).WithStyle(slack.StyleDanger),
+			),
+		))
+		blocks = append(blocks, slack.NewDividerBlock())
+	}
+	return blocks
+}
+
+func getScheduleStatus(schedule *model.RecurringSchedule) string {
+	if schedule.ApprovedAt != nil {
+		return fmt.Sprintf(":thumbsup: Accepted by <@%s>", schedule.AnsweredBy)
+	}
+
+	if schedule.RefusedAt != nil {
+		return fmt.Sprintf(":thumbsdown: Refused by <@%s>", schedule.AnsweredBy)
+	}
+
+	return ":question: Waiting for admin's answer, each forwarding will need to be accepted"
+}
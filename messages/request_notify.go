@@ -11,7 +11,7 @@ func AcceptedRequestMessage(request *model.ForwardingRequest) slack.Message {
         slack.NewSectionBlock(
             slack.NewTextBlockObject(
                 slack.MarkdownType,
-                fmt.Sprintf("Your request has been accepted. I'll forward you the messages until <!date^%d^{date_short_pretty} {time}|%s>", request.ExpiresAt.Unix(), request.ExpiresAt.Format("2006-01-02 15:04:05")),
+                AcceptedRequestText(request),
                 false,
                 false,
             ),
@@ -20,3 +20,18 @@ func AcceptedRequestMessage(request *model.ForwardingRequest) slack.Message {
         ),
     )
 }
+
+func AcceptedRequestText(request *model.ForwardingRequest) string {
+    text := fmt.Sprintf("Your request has been accepted. I'll forward you the messages until <!date^%d^{date_short_pretty} {time}|%s>", request.ExpiresAt.Unix(), request.ExpiresAt.Format("2006-01-02 15:04:05"))
+    if request.IsScheduled() {
+        text = fmt.Sprintf(
+            "Your request has been accepted. I'll forward you the messages from <!date^%d^{date_short_pretty} {time}|%s> until <!date^%d^{date_short_pretty} {time}|%s>",
+            request.StartsAt.Unix(),
+            request.StartsAt.Format("2006-01-02 15:04:05"),
+            request.ExpiresAt.Unix(),
+            request.ExpiresAt.Format("2006-01-02 15:04:05"),
+        )
+    }
+
+    return text
+}
@@ -7,11 +7,18 @@ import (
     "strings"
 )
 
-func SmsChannelNotifyMessage(message model.SMS, userIds []string) slack.Message {
+const ReplyCallbackID = "reply_sms"
+
+// SmsChannelNotifyMessage shows the text in the channel, with its codes
+// redacted. Authorized users can reveal them with a button.
+func SmsChannelNotifyMessage(message model.SMS, userIds []string, redaction Redaction) slack.Message {
     usersList := generateUserReferences(userIds)
 
-    return slack.NewBlockMessage(
-        smsMessageBlock(message),
+    body, redacted := redaction.Redact(message.Body)
+    message.Body = body
+
+    blocks := append(
+        smsMessageBlocks(message),
         slack.NewContextBlock(
             "context",
             slack.NewTextBlockObject(
@@ -22,25 +29,79 @@ func SmsChannelNotifyMessage(message model.SMS, userIds []string) slack.Message
             ),
         ),
     )
+
+    buttons := []slack.BlockElement{replyButton(message)}
+    if redacted {
+        buttons = append(buttons, slack.NewButtonBlockElement(
+            "reveal",
+            message.Id,
+            slack.NewTextBlockObject(slack.PlainTextType, ":eye: Reveal", false, false)))
+    }
+    blocks = append(blocks, slack.NewActionBlock("sms_message", buttons...))
+
+    result := slack.NewBlockMessage(blocks...)
+    result.Text = smsNotificationText(message)
+
+    return result
 }
 
 func SmsUserNotifyMessage(message model.SMS) slack.Message {
-    return slack.NewBlockMessage(
-        smsMessageBlock(message),
+    result := slack.NewBlockMessage(
+        append(smsMessageBlocks(message), slack.NewActionBlock("sms_message", replyButton(message)))...,
     )
+    result.Text = smsNotificationText(message)
+
+    return result
 }
 
-func smsMessageBlock(message model.SMS) *slack.SectionBlock {
-    return slack.NewSectionBlock(
-        slack.NewTextBlockObject(
-            slack.MarkdownType,
-            fmt.Sprintf("*Message from:* %s\n```\n%s\n```", message.From, message.Body),
-            false,
-            false,
+// smsMessageBlocks shows the text and, when it contains a verification code,
+// the code on its own so that it is easy to read and copy.
+func smsMessageBlocks(message model.SMS) []slack.Block {
+    blocks := []slack.Block{
+        slack.NewSectionBlock(
+            slack.NewTextBlockObject(
+                slack.MarkdownType,
+                fmt.Sprintf("*Message from:* %s\n```\n%s\n```", message.From, message.Body),
+                false,
+                false,
+            ),
+            nil,
+            nil,
         ),
-        nil,
-        nil,
-    )
+    }
+
+    if code, ok := FindVerificationCode(message.Body); ok {
+        blocks = append(blocks, slack.NewSectionBlock(
+            slack.NewTextBlockObject(
+                slack.MarkdownType,
+                fmt.Sprintf(":key: *%s*\n`%s`", codeTitle(code), code.Code),
+                false,
+                false,
+            ),
+            nil,
+            nil,
+        ))
+    }
+
+    return blocks
+}
+
+// smsNotificationText is the text of the push notifications, which can't
+// show blocks.
+func smsNotificationText(message model.SMS) string {
+    if code, ok := FindVerificationCode(message.Body); ok {
+        return fmt.Sprintf("%s: %s", codeTitle(code), code.Code)
+    }
+
+    return fmt.Sprintf("Message from %s", message.From)
+}
+
+func codeTitle(code VerificationCode) string {
+    if code.Service == "" {
+        return "Verification code"
+    }
+
+    return code.Service + " code"
 }
 
 func generateUserReferences(userIds []string) interface{} {
@@ -71,3 +132,36 @@ func phoneCallMessageBlock(event model.PhoneCallEvent) *slack.SectionBlock {
         nil,
     )
 }
+
+func replyButton(message model.SMS) *slack.ButtonBlockElement {
+    return slack.NewButtonBlockElement(
+        "reply",
+        message.Id,
+        slack.NewTextBlockObject(slack.PlainTextType, ":leftwards_arrow_with_hook: Reply", false, false))
+}
+
+// ReplyModal asks for the text sent back to the sender of the message.
+func ReplyModal(message model.SMS) slack.ModalViewRequest {
+    input := slack.NewPlainTextInputBlockElement(nil, "body")
+    input.Multiline = true
+    input.MaxLength = 1600
+
+    return slack.ModalViewRequest{
+        Type:            slack.VTModal,
+        CallbackID:      ReplyCallbackID,
+        PrivateMetadata: message.Id,
+        Title:           slack.NewTextBlockObject(slack.PlainTextType, "Reply", false, false),
+        Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Send", false, false),
+        Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+        Blocks: slack.Blocks{
+            BlockSet: []slack.Block{
+                smsMessageBlocks(message)[0],
+                slack.NewInputBlock(
+                    "reply",
+                    slack.NewTextBlockObject(slack.PlainTextType, fmt.Sprintf("Your reply to %s", message.From), false, false),
+                    input,
+                ),
+            },
+        },
+    }
+}
@@ -0,0 +1,80 @@
+package messages
+
+import (
+	"fmt"
+	"github.com/CedricFinance/phone_operator/model"
+	"github.com/slack-go/slack"
+)
+
+const (
+	StartRequestCallbackID = "start_request"
+	StartNumberBlockID     = "number"
+	StartNumberActionID    = "number"
+	StartDurationBlockID   = "duration"
+	StartDurationActionID  = "duration"
+)
+
+// NumberOption is a phone number the requesters can choose.
+type NumberOption struct {
+	Number string
+	Label  string
+}
+
+// StartRequestModal lets the requester choose the number to forward. The
+// duration input is prefilled with duration.
+func StartRequestModal(numbers []NumberOption, duration string) slack.ModalViewRequest {
+	var options []*slack.OptionBlockObject
+	for _, number := range numbers {
+		options = append(options, slack.NewOptionBlockObject(
+			number.Number,
+			slack.NewTextBlockObject(slack.PlainTextType, number.Label, false, false),
+			nil,
+		))
+	}
+
+	durationInput := slack.NewPlainTextInputBlockElement(
+		slack.NewTextBlockObject(slack.PlainTextType, "1h30m, until 18:00...", false, false),
+		StartDurationActionID,
+	)
+	durationInput.InitialValue = duration
+
+	durationBlock := slack.NewInputBlock(
+		StartDurationBlockID,
+		slack.NewTextBlockObject(slack.PlainTextType, "Duration", false, false),
+		durationInput,
+	)
+	durationBlock.Optional = true
+
+	return slack.ModalViewRequest{
+		Type:       slack.VTModal,
+		CallbackID: StartRequestCallbackID,
+		Title:      slack.NewTextBlockObject(slack.PlainTextType, "Receive texts", false, false),
+		Submit:     slack.NewTextBlockObject(slack.PlainTextType, "Request", false, false),
+		Close:      slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewInputBlock(
+					StartNumberBlockID,
+					slack.NewTextBlockObject(slack.PlainTextType, "Number", false, false),
+					slack.NewOptionsSelectBlockElement(
+						slack.OptTypeStatic,
+						slack.NewTextBlockObject(slack.PlainTextType, "Choose a number", false, false),
+						StartNumberActionID,
+						options...,
+					),
+				),
+				durationBlock,
+			},
+		},
+	}
+}
+
+// numberText tells which number the request forwards, if it is limited to
+// one.
+func numberText(request *model.ForwardingRequest) string {
+	if request.Number == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" on `%s`", request.Number)
+}
@@ -0,0 +1,90 @@
+package messages
+
+import (
+	"fmt"
+	"github.com/CedricFinance/phone_operator/model"
+	"github.com/slack-go/slack"
+	"time"
+)
+
+// StatusMessage summarizes the active, scheduled and pending requests of a
+// user in answer to `/sms status`.
+func StatusMessage(requests []*model.ForwardingRequest) slack.Message {
+	var activeRequests []*model.ForwardingRequest
+	var scheduledRequests []*model.ForwardingRequest
+	var pendingRequests []*model.ForwardingRequest
+
+	for _, request := range requests {
+		if request.IsActive() {
+			activeRequests = append(activeRequests, request)
+		} else if request.IsPending() {
+			pendingRequests = append(pendingRequests, request)
+		} else if request.IsScheduled() {
+			scheduledRequests = append(scheduledRequests, request)
+		}
+	}
+
+	if len(activeRequests) == 0 && len(scheduledRequests) == 0 && len(pendingRequests) == 0 {
+		return slack.NewBlockMessage(markdownSection("Texts are not forwarded to you. Use `/sms start` to request it."))
+	}
+
+	var blocks []slack.Block
+
+	if len(activeRequests) > 0 {
+		blocks = append(blocks, markdownSection("*Your active requests*"))
+		for _, request := range activeRequests {
+			blocks = append(blocks, markdownSection(fmt.Sprintf(
+				"%s\n*Remaining*: %s",
+				getMessage(request),
+				formatRemaining(request.ExpiresAt.Sub(time.Now().UTC())),
+			)))
+		}
+	}
+
+	if len(scheduledRequests) > 0 {
+		blocks = append(blocks, markdownSection("*Your scheduled requests*"))
+		for _, request := range scheduledRequests {
+			blocks = append(blocks, markdownSection(getMessage(request)))
+		}
+	}
+
+	if len(pendingRequests) > 0 {
+		blocks = append(blocks, markdownSection("*Your pending requests*"))
+		for _, request := range pendingRequests {
+			blocks = append(blocks, markdownSection(getMessage(request)))
+		}
+	}
+
+	return slack.NewBlockMessage(blocks...)
+}
+
+func markdownSection(text string) *slack.SectionBlock {
+	return slack.NewSectionBlock(
+		slack.NewTextBlockObject(
+			slack.MarkdownType,
+			text,
+			false,
+			false,
+		),
+		nil,
+		nil,
+	)
+}
+
+func formatRemaining(remaining time.Duration) string {
+	minutes := int(remaining.Round(time.Minute).Minutes())
+
+	if minutes < 1 {
+		return "less than a minute"
+	}
+
+	if minutes < 60 {
+		return fmt.Sprintf("%d minute(s)", minutes)
+	}
+
+	if minutes%60 == 0 {
+		return fmt.Sprintf("%dh", minutes/60)
+	}
+
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
@@ -0,0 +1,90 @@
+package messages
+
+import (
+	"github.com/CedricFinance/phone_operator/model"
+	"github.com/slack-go/slack"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sectionTexts(message slack.Message) []string {
+	var texts []string
+	for _, block := range message.Blocks.BlockSet {
+		if section, ok := block.(*slack.SectionBlock); ok {
+			texts = append(texts, section.Text.Text)
+		}
+	}
+
+	return texts
+}
+
+func TestStatusMessage(t *testing.T) {
+	now := time.Now().UTC()
+	inTwoHours := now.Add(2*time.Hour + 30*time.Second)
+	tomorrow := now.Add(24 * time.Hour)
+	tomorrowEnd := tomorrow.Add(time.Hour)
+	anHourAgo := now.Add(-time.Hour)
+
+	active := &model.ForwardingRequest{Id: "ACTIVE", Duration: 180, CreatedAt: anHourAgo, AcceptedAt: &anHourAgo, ExpiresAt: &inTwoHours}
+	scheduled := &model.ForwardingRequest{Id: "SCHEDULED", Duration: 60, CreatedAt: now, AcceptedAt: &now, StartsAt: &tomorrow, ExpiresAt: &tomorrowEnd}
+	pending := &model.ForwardingRequest{Id: "PENDING", Duration: 60, CreatedAt: now}
+	expired := &model.ForwardingRequest{Id: "EXPIRED", Duration: 60, CreatedAt: anHourAgo, AcceptedAt: &anHourAgo, ExpiresAt: &now}
+
+	tests := []struct {
+		name     string
+		requests []*model.ForwardingRequest
+		expected []string
+	}{
+		{"no request", nil, []string{"Texts are not forwarded to you"}},
+		{"expired", []*model.ForwardingRequest{expired}, []string{"Texts are not forwarded to you"}},
+		{"active", []*model.ForwardingRequest{active, expired}, []string{"*Your active requests*", "*Remaining*: 2h"}},
+		{"scheduled", []*model.ForwardingRequest{scheduled}, []string{"*Your scheduled requests*", "from <!date^"}},
+		{"pending", []*model.ForwardingRequest{pending}, []string{"*Your pending requests*", "*Status*:"}},
+		{
+			"all",
+			[]*model.ForwardingRequest{pending, expired, scheduled, active},
+			[]string{"*Your active requests*", "*Remaining*: 2h", "*Your scheduled requests*", "from <!date^", "*Your pending requests*", "*Status*:"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			texts := strings.Join(sectionTexts(StatusMessage(test.requests)), "\n")
+
+			// The sections come in the expected order
+			rest := texts
+			for _, expected := range test.expected {
+				index := strings.Index(rest, expected)
+				if index < 0 {
+					t.Fatalf("expected %q after the previous sections, got: %q", expected, texts)
+				}
+				rest = rest[index+len(expected):]
+			}
+		})
+	}
+}
+
+func TestFormatRemaining(t *testing.T) {
+	tests := []struct {
+		remaining time.Duration
+		expected  string
+	}{
+		{0, "less than a minute"},
+		{-time.Minute, "less than a minute"},
+		{29 * time.Second, "less than a minute"},
+		// Durations are rounded to the nearest minute
+		{30 * time.Second, "1 minute(s)"},
+		{59*time.Minute + 29*time.Second, "59 minute(s)"},
+		{59*time.Minute + 30*time.Second, "1h"},
+		{2 * time.Hour, "2h"},
+		{2*time.Hour + 5*time.Minute, "2h05m"},
+		{26*time.Hour + 45*time.Minute, "26h45m"},
+	}
+
+	for _, test := range tests {
+		if got := formatRemaining(test.remaining); got != test.expected {
+			t.Errorf("remaining %s: expected %q, got: %q", test.remaining, test.expected, got)
+		}
+	}
+}
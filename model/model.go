@@ -1,6 +1,7 @@
 package model
 
 import (
+    "strings"
     "time"
 )
 
@@ -14,24 +15,228 @@ type ForwardingRequest struct {
     RefusedAt     *time.Time
     ExpiresAt     *time.Time
     AnsweredBy    string
+    StoppedAt     *time.Time
+    StoppedBy     string
+    // StartsAt is set for requests scheduled in advance
+    StartsAt *time.Time
+    // ScheduleId is set for requests created from a RecurringSchedule
+    ScheduleId string
+    // Number is the forwarded phone number, empty for every number
+    Number string
+    // ApprovalChannel and ApprovalTs locate the message posted to the admins
+    ApprovalChannel string
+    ApprovalTs      string
+}
+
+const policyAnswerPrefix = "policy:"
+
+// PolicyAnswer is the AnsweredBy value of requests accepted by an
+// auto-approval policy.
+func PolicyAnswer(policyName string) string {
+    return policyAnswerPrefix + policyName
+}
+
+// AnsweredByPolicy returns the name of the policy which accepted the request,
+// if any.
+func (r ForwardingRequest) AnsweredByPolicy() (string, bool) {
+    if strings.HasPrefix(r.AnsweredBy, policyAnswerPrefix) {
+        return strings.TrimPrefix(r.AnsweredBy, policyAnswerPrefix), true
+    }
+
+    return "", false
 }
 
 func (r ForwardingRequest) IsActive() bool {
-    return r.ExpiresAt != nil && r.ExpiresAt.After(time.Now().UTC())
+    now := time.Now().UTC()
+    return r.ExpiresAt != nil && r.ExpiresAt.After(now) && (r.StartsAt == nil || !r.StartsAt.After(now))
+}
+
+// IsScheduled tells whether the request is for a window which hasn't started
+// yet.
+func (r ForwardingRequest) IsScheduled() bool {
+    return r.StartsAt != nil && r.StartsAt.After(time.Now().UTC()) && r.RefusedAt == nil && r.StoppedAt == nil
+}
+
+// End returns the time at which the forwarding ends, or is expected to end
+// for requests which haven't been accepted yet.
+func (r ForwardingRequest) End() time.Time {
+    if r.ExpiresAt != nil {
+        return *r.ExpiresAt
+    }
+
+    start := time.Now().UTC()
+    if r.StartsAt != nil {
+        start = *r.StartsAt
+    }
+
+    return start.Add(time.Duration(r.Duration) * time.Minute)
+}
+
+func (r ForwardingRequest) IsExpired() bool {
+    return r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now().UTC())
+}
+
+// IsRevoked tells whether an admin stopped the request.
+func (r ForwardingRequest) IsRevoked() bool {
+    return r.StoppedAt != nil && r.StoppedBy != r.RequesterId
 }
 
 func (r ForwardingRequest) IsPending() bool {
-    return r.AcceptedAt == nil && r.RefusedAt == nil
+    return r.AcceptedAt == nil && r.RefusedAt == nil && r.StoppedAt == nil
+}
+
+// IsCancelable tells whether the requester can still stop or cancel the
+// request.
+func (r ForwardingRequest) IsCancelable() bool {
+    return r.IsActive() || r.IsScheduled() || r.IsPending()
+}
+
+const (
+    ProviderTwilio = "twilio"
+    ProviderNexmo  = "nexmo"
+)
+
+// RecurringSchedule periodically creates forwarding requests, e.g. every
+// weeknight for the on-call engineer. The requests are created already
+// accepted once an admin approved the schedule, pending otherwise.
+type RecurringSchedule struct {
+    Id            string
+    RequesterId   string
+    RequesterName string
+    // Number is the forwarded phone number, empty for every number
+    Number   string
+    Weekdays []time.Weekday
+    // StartTime and EndTime ("15:04") are expressed in Timezone. The window
+    // ends the next day when EndTime is before StartTime.
+    StartTime  string
+    EndTime    string
+    Timezone   string
+    CreatedAt  time.Time
+    ApprovedAt *time.Time
+    RefusedAt  *time.Time
+    AnsweredBy string
+    DeletedAt  *time.Time
+    // MaterializedUntil is the time up to which the occurrences have been
+    // created as forwarding requests
+    MaterializedUntil time.Time
+}
+
+func (s RecurringSchedule) IsApproved() bool {
+    return s.ApprovedAt != nil
+}
+
+func (s RecurringSchedule) IsEnabled() bool {
+    return s.RefusedAt == nil && s.DeletedAt == nil
+}
+
+// Extension pushes out the expiration of an active ForwardingRequest. Long
+// extensions must be accepted by an admin first.
+type Extension struct {
+    Id                  string
+    ForwardingRequestId string
+    Duration            int
+    RequestedBy         string
+    CreatedAt           time.Time
+    AcceptedAt          *time.Time
+    RefusedAt           *time.Time
+    AnsweredBy          string
+}
+
+func (e Extension) IsPending() bool {
+    return e.AcceptedAt == nil && e.RefusedAt == nil
 }
 
 type SMS struct {
-    From string
-    Body string
+    Id         string
+    ReceivedAt time.Time
+    Provider   string
+    From       string
+    To         string
+    Body       string
+    // SlackChannel and SlackTs locate the channel post of the SMS, in the
+    // thread SlackThreadTs when it follows previous messages of the sender.
+    SlackChannel  string
+    SlackTs       string
+    SlackThreadTs string
+}
+
+// Delivery records the forwarding of an SMS to the requester of a
+// ForwardingRequest.
+type Delivery struct {
+    Id        string
+    MessageId string
+    // ForwardingRequestId is empty for the texts forwarded without a
+    // request, e.g. to the people on call or the recipients of a routing rule
+    ForwardingRequestId string
+    UserId              string
+    CreatedAt           time.Time
+    Error               *string
+}
+
+func (d Delivery) IsSuccessful() bool {
+    return d.Error == nil
+}
+
+// Reply is a text sent back to the sender of an SMS.
+type Reply struct {
+    Id        string
+    MessageId string
+    UserId    string
+    Body      string
+    CreatedAt time.Time
+    Error     *string
+}
+
+// Reveal records that a user saw the redacted codes of an SMS.
+type Reveal struct {
+    Id        string
+    MessageId string
+    UserId    string
+    CreatedAt time.Time
+}
+
+const (
+    OutboxKindUser    = "user"
+    OutboxKindChannel = "channel"
+)
+
+// OutboxEntry is a Slack post waiting to be delivered. Failed posts are
+// retried with an exponential backoff.
+type OutboxEntry struct {
+    Id                   string
+    MessageId            string
+    Kind                 string
+    Recipient            string
+    UserIds              []string
+    ForwardingRequestIds []string
+    Attempts             int
+    NextAttemptAt        time.Time
+    CreatedAt            time.Time
+    SentAt               *time.Time
+    FailedAt             *time.Time
+    LastError            *string
+}
+
+// RoutingRule sends the texts matching its patterns to its own recipients
+// instead of the forwarders of the number.
+type RoutingRule struct {
+    Id string
+    // SenderPattern matches the sender, `*` matching any characters
+    SenderPattern string
+    // BodyPattern is a regular expression matching the body
+    BodyPattern string
+    // Number restricts the rule to the texts sent to this number
+    Number    string
+    UserIds   []string
+    Channel   string
+    CreatedBy string
+    CreatedAt time.Time
 }
 
 type PhoneCallEvent struct {
     Status   string
     From     string
+    To       string
     Duration string
     Start    string
     End      string
@@ -1,10 +1,23 @@
 package main
 
 import (
+    "crypto/hmac"
+    "crypto/md5"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/base64"
+    "encoding/hex"
     "encoding/json"
+    "errors"
     "fmt"
     "github.com/CedricFinance/phone_operator/model"
+    "hash"
     "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
 )
 
 type nexmoIncomingSms struct {
@@ -24,8 +37,10 @@ func ParseNexmoSMS(r *http.Request) (model.SMS, error) {
         }
 
         message := model.SMS{
-            Body: incomingSms.Text,
-            From: incomingSms.From,
+            Provider: model.ProviderNexmo,
+            Body:     incomingSms.Text,
+            From:     incomingSms.From,
+            To:       incomingSms.To,
         }
 
         return message, nil
@@ -33,8 +48,10 @@ func ParseNexmoSMS(r *http.Request) (model.SMS, error) {
 
     if r.Method == http.MethodGet {
         message := model.SMS{
-            Body: r.URL.Query().Get("text"),
-            From: r.URL.Query().Get("msisdn"),
+            Provider: model.ProviderNexmo,
+            Body:     r.URL.Query().Get("text"),
+            From:     r.URL.Query().Get("msisdn"),
+            To:       r.URL.Query().Get("to"),
         }
 
         return message, nil
@@ -58,6 +75,7 @@ func ParseNexmoPhoneCallEvent(r *http.Request) (model.PhoneCallEvent, error) {
         message := model.PhoneCallEvent{
             Status:   status,
             From:     query.Get("from"),
+            To:       query.Get("to"),
             Start:    query.Get("call_start"),
             End:      query.Get("call_end"),
             Duration: query.Get("call_duration"),
@@ -68,3 +86,214 @@ func ParseNexmoPhoneCallEvent(r *http.Request) (model.PhoneCallEvent, error) {
 
     return model.PhoneCallEvent{}, fmt.Errorf("can't handle %q HTTP method", r.Method)
 }
+
+const nexmoSignatureMaxAge = 5 * time.Minute
+
+// NexmoSignatureVerifier checks incoming Vonage webhooks. Requests carrying a
+// bearer token are verified as signed JWTs, the others must include a `sig`
+// parameter computed with the signature secret.
+// See https://developer.vonage.com/en/getting-started/concepts/signing-messages
+func NexmoSignatureVerifier(secret string, method string) func(r *http.Request) error {
+    return func(r *http.Request) error {
+        if secret == "" {
+            return errors.New("no Nexmo signature secret configured")
+        }
+
+        // The JWT only signs the body, the query string of GET webhooks must
+        // carry its own signature.
+        if authorization := r.Header.Get("Authorization"); r.Method != http.MethodGet && strings.HasPrefix(authorization, "Bearer ") {
+            return verifyNexmoJWT(r, secret, strings.TrimPrefix(authorization, "Bearer "), time.Now())
+        }
+
+        params, err := nexmoSignedParams(r)
+        if err != nil {
+            return err
+        }
+
+        return verifyNexmoSignature(params, secret, method, time.Now())
+    }
+}
+
+// nexmoSignedParams extracts the webhook parameters from the query string or
+// the JSON body. The body is restored so that it can be parsed again.
+func nexmoSignedParams(r *http.Request) (map[string]string, error) {
+    params := make(map[string]string)
+
+    if r.Method == http.MethodGet {
+        for key, values := range r.URL.Query() {
+            params[key] = values[0]
+        }
+        return params, nil
+    }
+
+    body, err := readBody(r)
+    if err != nil {
+        return nil, err
+    }
+
+    var fields map[string]interface{}
+    err = json.Unmarshal(body, &fields)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse request body: %w", err)
+    }
+
+    for key, value := range fields {
+        switch v := value.(type) {
+        case string:
+            params[key] = v
+        case nil:
+            params[key] = ""
+        default:
+            params[key] = fmt.Sprint(v)
+        }
+    }
+
+    return params, nil
+}
+
+func verifyNexmoSignature(params map[string]string, secret string, method string, now time.Time) error {
+    signature := params["sig"]
+    if signature == "" {
+        return errors.New("missing sig parameter")
+    }
+
+    timestamp, err := strconv.ParseInt(params["timestamp"], 10, 64)
+    if err != nil {
+        return fmt.Errorf("invalid timestamp parameter %q", params["timestamp"])
+    }
+    if age := now.Sub(time.Unix(timestamp, 0)); age > nexmoSignatureMaxAge || age < -nexmoSignatureMaxAge {
+        return fmt.Errorf("signature timestamp is too old (%s)", age)
+    }
+
+    expected, err := nexmoSignature(params, secret, method)
+    if err != nil {
+        return err
+    }
+
+    if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+        return errors.New("invalid sig parameter")
+    }
+
+    return nil
+}
+
+func nexmoSignature(params map[string]string, secret string, method string) (string, error) {
+    keys := make([]string, 0, len(params))
+    for key := range params {
+        if key == "sig" {
+            continue
+        }
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    sanitizer := strings.NewReplacer("&", "_", "=", "_")
+
+    var data strings.Builder
+    for _, key := range keys {
+        data.WriteString("&")
+        data.WriteString(sanitizer.Replace(key))
+        data.WriteString("=")
+        data.WriteString(sanitizer.Replace(params[key]))
+    }
+
+    var mac hash.Hash
+    switch method {
+    case "", "md5hash":
+        mac = md5.New()
+        data.WriteString(secret)
+    case "md5":
+        mac = hmac.New(md5.New, []byte(secret))
+    case "sha1":
+        mac = hmac.New(sha1.New, []byte(secret))
+    case "sha256":
+        mac = hmac.New(sha256.New, []byte(secret))
+    case "sha512":
+        mac = hmac.New(sha512.New, []byte(secret))
+    default:
+        return "", fmt.Errorf("unknown signature method %q", method)
+    }
+
+    mac.Write([]byte(data.String()))
+
+    return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+type nexmoJWTClaims struct {
+    IssuedAt    int64  `json:"iat"`
+    PayloadHash string `json:"payload_hash"`
+}
+
+// verifyNexmoJWT checks a HS256 JWT signed with the signature secret, issued
+// less than 5 minutes ago. Requests with a body must carry its payload_hash.
+// It doesn't cover the query string, so it can't verify GET requests.
+func verifyNexmoJWT(r *http.Request, secret string, token string, now time.Time) error {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return errors.New("malformed JWT")
+    }
+
+    var header struct {
+        Alg string `json:"alg"`
+    }
+    err := decodeJWTSegment(parts[0], &header)
+    if err != nil {
+        return err
+    }
+    if header.Alg != "HS256" {
+        return fmt.Errorf("unsupported JWT algorithm %q", header.Alg)
+    }
+
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(parts[0] + "." + parts[1]))
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+        return errors.New("invalid JWT signature")
+    }
+
+    var claims nexmoJWTClaims
+    err = decodeJWTSegment(parts[1], &claims)
+    if err != nil {
+        return err
+    }
+
+    if claims.IssuedAt == 0 {
+        return errors.New("missing JWT iat claim")
+    }
+    if age := now.Sub(time.Unix(claims.IssuedAt, 0)); age > nexmoSignatureMaxAge || age < -nexmoSignatureMaxAge {
+        return fmt.Errorf("JWT is too old (%s)", age)
+    }
+
+    body, err := readBody(r)
+    if err != nil {
+        return err
+    }
+
+    if claims.PayloadHash == "" {
+        if len(body) > 0 {
+            return errors.New("missing JWT payload_hash claim")
+        }
+        return nil
+    }
+
+    payloadHash := sha256.Sum256(body)
+    if !hmac.Equal([]byte(strings.ToLower(claims.PayloadHash)), []byte(hex.EncodeToString(payloadHash[:]))) {
+        return errors.New("JWT payload_hash doesn't match the request body")
+    }
+
+    return nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+    data, err := base64.RawURLEncoding.DecodeString(segment)
+    if err != nil {
+        return fmt.Errorf("malformed JWT: %w", err)
+    }
+
+    err = json.Unmarshal(data, v)
+    if err != nil {
+        return fmt.Errorf("malformed JWT: %w", err)
+    }
+
+    return nil
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/model"
     "net/http"
+    "net/url"
+    "strconv"
     "strings"
     "testing"
+    "time"
 )
 
 func TestParseNexmoSMS_POST(t *testing.T) {
@@ -22,10 +31,18 @@ func TestParseNexmoSMS_POST(t *testing.T) {
     if message.From != "0123456789" {
         t.Errorf("invalid From, expected: %q, got: %q", "0123456789", message.From)
     }
+
+    if message.To != "0612345678" {
+        t.Errorf("invalid To, expected: %q, got: %q", "0612345678", message.To)
+    }
+
+    if message.Provider != model.ProviderNexmo {
+        t.Errorf("invalid Provider, expected: %q, got: %q", model.ProviderNexmo, message.Provider)
+    }
 }
 
 func TestParseNexmoSMS_GET(t *testing.T) {
-    r, _ := http.NewRequest(http.MethodGet, "http://localhost?text=HelloWorld&msisdn=0123456789", nil)
+    r, _ := http.NewRequest(http.MethodGet, "http://localhost?text=HelloWorld&msisdn=0123456789&to=0612345678", nil)
     r.Header.Set("Content-Type", "application/json")
 
     message, err := ParseNexmoSMS(r)
@@ -40,6 +57,10 @@ func TestParseNexmoSMS_GET(t *testing.T) {
     if message.From != "0123456789" {
         t.Errorf("invalid From, expected: %q, got: %q", "0123456789", message.From)
     }
+
+    if message.To != "0612345678" {
+        t.Errorf("invalid To, expected: %q, got: %q", "0612345678", message.To)
+    }
 }
 
 func TestParseNexmoPhoneCallEvent_GET(t *testing.T) {
@@ -68,3 +89,200 @@ func TestParseNexmoPhoneCallEvent_GET(t *testing.T) {
     }
 
 }
+
+func signedNexmoQuery(t *testing.T, secret string, method string, params map[string]string) url.Values {
+    sig, err := nexmoSignature(params, secret, method)
+    if err != nil {
+        t.Fatalf("failed to sign params: %s", err)
+    }
+
+    query := url.Values{}
+    for key, value := range params {
+        query.Set(key, value)
+    }
+    query.Set("sig", sig)
+
+    return query
+}
+
+func TestNexmoSignatureVerifier_Valid(t *testing.T) {
+    for _, method := range []string{"md5hash", "md5", "sha1", "sha256", "sha512"} {
+        query := signedNexmoQuery(t, "secret", method, map[string]string{
+            "text":      "Hello&World",
+            "msisdn":    "0123456789",
+            "timestamp": strconv.FormatInt(time.Now().Unix(), 10),
+        })
+        r, _ := http.NewRequest(http.MethodGet, "http://localhost?"+query.Encode(), nil)
+
+        err := NexmoSignatureVerifier("secret", method)(r)
+        if err != nil {
+            t.Errorf("expected a valid %s signature, got: %s", method, err)
+        }
+    }
+}
+
+func TestNexmoSignatureVerifier_ValidPOST(t *testing.T) {
+    params := map[string]string{
+        "text":      "HelloWorld",
+        "msisdn":    "0123456789",
+        "to":        "0612345678",
+        "timestamp": strconv.FormatInt(time.Now().Unix(), 10),
+    }
+    sig, _ := nexmoSignature(params, "secret", "sha256")
+    body := "{ \"text\": \"HelloWorld\", \"to\": \"0612345678\", \"msisdn\": \"0123456789\", \"timestamp\": \"" + params["timestamp"] + "\", \"sig\": \"" + sig + "\" }"
+    r, _ := http.NewRequest(http.MethodPost, "http://localhost", strings.NewReader(body))
+    r.Header.Set("Content-Type", "application/json")
+
+    err := NexmoSignatureVerifier("secret", "sha256")(r)
+    if err != nil {
+        t.Errorf("expected a valid signature, got: %s", err)
+    }
+
+    message, err := ParseNexmoSMS(r)
+    if err != nil {
+        t.Errorf("failed to parse incoming SMS after verification: %s", err)
+    }
+
+    if message.Body != "HelloWorld" {
+        t.Errorf("invalid Body, expected: %q, got: %q", "HelloWorld", message.Body)
+    }
+}
+
+func TestNexmoSignatureVerifier_Tampered(t *testing.T) {
+    query := signedNexmoQuery(t, "secret", "sha256", map[string]string{
+        "text":      "HelloWorld",
+        "msisdn":    "0123456789",
+        "timestamp": strconv.FormatInt(time.Now().Unix(), 10),
+    })
+    query.Set("text", "Forged")
+    r, _ := http.NewRequest(http.MethodGet, "http://localhost?"+query.Encode(), nil)
+
+    err := NexmoSignatureVerifier("secret", "sha256")(r)
+    if err == nil {
+        t.Errorf("expected tampered request to be rejected")
+    }
+}
+
+func TestNexmoSignatureVerifier_Expired(t *testing.T) {
+    query := signedNexmoQuery(t, "secret", "sha256", map[string]string{
+        "text":      "HelloWorld",
+        "msisdn":    "0123456789",
+        "timestamp": strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+    })
+    r, _ := http.NewRequest(http.MethodGet, "http://localhost?"+query.Encode(), nil)
+
+    err := NexmoSignatureVerifier("secret", "sha256")(r)
+    if err == nil {
+        t.Errorf("expected replayed request to be rejected")
+    }
+}
+
+func TestNexmoSignatureVerifier_Missing(t *testing.T) {
+    r, _ := http.NewRequest(http.MethodGet, "http://localhost?text=HelloWorld&msisdn=0123456789", nil)
+
+    err := NexmoSignatureVerifier("secret", "sha256")(r)
+    if err == nil {
+        t.Errorf("expected unsigned request to be rejected")
+    }
+}
+
+func nexmoJWT(secret string, claims string) string {
+    header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+    payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(header + "." + payload))
+
+    return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestNexmoSignatureVerifier_JWT(t *testing.T) {
+    body := "{ \"text\": \"HelloWorld\", \"msisdn\": \"0123456789\" }"
+    hash := sha256.Sum256([]byte(body))
+    token := nexmoJWT("secret", fmt.Sprintf(`{"iat":%d,"payload_hash":"%s"}`, time.Now().Unix(), hex.EncodeToString(hash[:])))
+
+    r, _ := http.NewRequest(http.MethodPost, "http://localhost", strings.NewReader(body))
+    r.Header.Set("Authorization", "Bearer "+token)
+
+    err := NexmoSignatureVerifier("secret", "sha256")(r)
+    if err != nil {
+        t.Errorf("expected a valid JWT, got: %s", err)
+    }
+
+    message, err := ParseNexmoSMS(r)
+    if err != nil {
+        t.Errorf("failed to parse incoming SMS after verification: %s", err)
+    }
+
+    if message.Body != "HelloWorld" {
+        t.Errorf("invalid Body, expected: %q, got: %q", "HelloWorld", message.Body)
+    }
+}
+
+func TestNexmoSignatureVerifier_JWTTampered(t *testing.T) {
+    body := "{ \"text\": \"HelloWorld\", \"msisdn\": \"0123456789\" }"
+    hash := sha256.Sum256([]byte(body))
+    token := nexmoJWT("secret", fmt.Sprintf(`{"iat":%d,"payload_hash":"%s"}`, time.Now().Unix(), hex.EncodeToString(hash[:])))
+
+    r, _ := http.NewRequest(http.MethodPost, "http://localhost", strings.NewReader("{ \"text\": \"Forged\", \"msisdn\": \"0123456789\" }"))
+    r.Header.Set("Authorization", "Bearer "+token)
+
+    err := NexmoSignatureVerifier("secret", "sha256")(r)
+    if err == nil {
+        t.Errorf("expected tampered body to be rejected")
+    }
+
+    r.Header.Set("Authorization", "Bearer "+nexmoJWT("other", fmt.Sprintf(`{"iat":%d}`, time.Now().Unix())))
+
+    err = NexmoSignatureVerifier("secret", "sha256")(r)
+    if err == nil {
+        t.Errorf("expected JWT signed with another secret to be rejected")
+    }
+}
+
+func TestNexmoSignatureVerifier_JWTGET(t *testing.T) {
+    token := nexmoJWT("secret", fmt.Sprintf(`{"iat":%d}`, time.Now().Unix()))
+
+    r, _ := http.NewRequest(http.MethodGet, "http://localhost?text=Forged&msisdn=0123456789", nil)
+    r.Header.Set("Authorization", "Bearer "+token)
+
+    err := NexmoSignatureVerifier("secret", "sha256")(r)
+    if err == nil {
+        t.Errorf("expected a GET request without sig to be rejected")
+    }
+    query := signedNexmoQuery(t, "secret", "sha256", map[string]string{
+        "text":      "HelloWorld",
+        "msisdn":    "0123456789",
+        "timestamp": strconv.FormatInt(time.Now().Unix(), 10),
+    })
+    r, _ = http.NewRequest(http.MethodGet, "http://localhost?"+query.Encode(), nil)
+    r.Header.Set("Authorization", "Bearer "+token)
+
+    err = NexmoSignatureVerifier("secret", "sha256")(r)
+    if err != nil {
+        t.Errorf("expected a GET request with a valid sig to be accepted, got: %s", err)
+    }
+}
+
+func TestNexmoSignatureVerifier_JWTReplayed(t *testing.T) {
+    body := "{ \"text\": \"HelloWorld\", \"msisdn\": \"0123456789\" }"
+    hash := sha256.Sum256([]byte(body))
+
+    tests := map[string]string{
+        "too old":              fmt.Sprintf(`{"iat":%d,"payload_hash":"%s"}`, time.Now().Add(-6*time.Minute).Unix(), hex.EncodeToString(hash[:])),
+        "missing iat":          fmt.Sprintf(`{"payload_hash":"%s"}`, hex.EncodeToString(hash[:])),
+        "missing payload_hash": fmt.Sprintf(`{"iat":%d}`, time.Now().Unix()),
+    }
+
+    for name, claims := range tests {
+        t.Run(name, func(t *testing.T) {
+            r, _ := http.NewRequest(http.MethodPost, "http://localhost", strings.NewReader(body))
+            r.Header.Set("Authorization", "Bearer "+nexmoJWT("secret", claims))
+
+            err := NexmoSignatureVerifier("secret", "sha256")(r)
+            if err == nil {
+                t.Errorf("expected the JWT to be rejected")
+            }
+        })
+    }
+}
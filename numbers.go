@@ -0,0 +1,138 @@
+package main
+
+import (
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "strings"
+)
+
+// PhoneNumber is a number of the registry with its own channel and admins.
+type PhoneNumber struct {
+    Number string
+    // Alias is a short name users can give to `/sms start`, e.g. bank-line
+    Alias string
+    // Channel receives the texts and the forwarding requests of the number.
+    // It defaults to the Slack channel.
+    Channel string
+    // Admins and the members of AdminGroup answer the forwarding requests
+    // of the number. They default to the Slack admins.
+    Admins     []string
+    AdminGroup string `yaml:"admin_group"`
+}
+
+// normalizeNumber only keeps the digits of a number as providers don't agree
+// on the format, e.g. Twilio sends "+33612345678" and Vonage "33612345678".
+func normalizeNumber(number string) string {
+    var digits strings.Builder
+    for _, c := range number {
+        if c >= '0' && c <= '9' {
+            digits.WriteRune(c)
+        }
+    }
+
+    return digits.String()
+}
+
+// findNumber returns the registry entry of the number, or nil when the number
+// isn't registered.
+func findNumber(number string) *PhoneNumber {
+    normalized := normalizeNumber(number)
+    if normalized == "" {
+        return nil
+    }
+
+    for i := range config.Numbers {
+        if normalizeNumber(config.Numbers[i].Number) == normalized {
+            return &config.Numbers[i]
+        }
+    }
+
+    return nil
+}
+
+// lookupNumber finds a registered number from its alias or the number
+// itself.
+func lookupNumber(name string) *PhoneNumber {
+    for i := range config.Numbers {
+        if config.Numbers[i].Alias != "" && strings.EqualFold(config.Numbers[i].Alias, name) {
+            return &config.Numbers[i]
+        }
+    }
+
+    return findNumber(name)
+}
+
+// numberOptions lists the registered numbers for the number picker.
+func numberOptions() []messages.NumberOption {
+    var options []messages.NumberOption
+    for _, phoneNumber := range config.Numbers {
+        label := phoneNumber.Number
+        if phoneNumber.Alias != "" {
+            label = phoneNumber.Alias + " (" + phoneNumber.Number + ")"
+        }
+        options = append(options, messages.NumberOption{Number: phoneNumber.Number, Label: label})
+    }
+
+    return options
+}
+
+// describeNumbers lists the aliases, or the numbers, of the registered numbers
+// for the users to pick one in a command.
+func describeNumbers() string {
+    var names []string
+    for _, phoneNumber := range config.Numbers {
+        name := phoneNumber.Number
+        if phoneNumber.Alias != "" {
+            name = phoneNumber.Alias
+        }
+        names = append(names, "`"+name+"`")
+    }
+
+    return strings.Join(names, ", ")
+}
+
+// numberChannel returns the channel receiving the texts and the requests of
+// the number.
+func numberChannel(number string) string {
+    phoneNumber := findNumber(number)
+    if phoneNumber == nil || phoneNumber.Channel == "" {
+        return config.Slack.Channel
+    }
+
+    return phoneNumber.Channel
+}
+
+// numberAdmins returns the admins answering the requests of the number.
+func numberAdmins(number string) ([]string, string) {
+    if !hasOwnAdmins(number) {
+        return config.Slack.Admins, config.Slack.AdminGroup
+    }
+
+    phoneNumber := findNumber(number)
+    return phoneNumber.Admins, phoneNumber.AdminGroup
+}
+
+// hasOwnAdmins tells whether the number is answered by other admins than the
+// Slack ones.
+func hasOwnAdmins(number string) bool {
+    phoneNumber := findNumber(number)
+    return phoneNumber != nil && (len(phoneNumber.Admins) > 0 || phoneNumber.AdminGroup != "")
+}
+
+// forwardsNumber tells whether the request forwards the texts sent to the
+// number. Requests without a number forward every number.
+func forwardsNumber(request string, number string) bool {
+    return request == "" || normalizeNumber(request) == normalizeNumber(number)
+}
+
+// filterRequestsByNumber keeps the requests forwarding the number.
+func filterRequestsByNumber(requests []*model.ForwardingRequest, number string) []*model.ForwardingRequest {
+    var filtered []*model.ForwardingRequest
+    for _, request := range requests {
+        if forwardsNumber(request.Number, number) {
+            filtered = append(filtered, request)
+        }
+    }
+
+    return filtered
+}
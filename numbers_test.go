@@ -0,0 +1,73 @@
+package main
+
+import (
+    "github.com/CedricFinance/phone_operator/model"
+    "testing"
+)
+
+func TestNumberChannel(t *testing.T) {
+    config.Slack.Channel = "CDEFAULT"
+    config.Numbers = []PhoneNumber{
+        {Number: "+33 6 12 34 56 78", Channel: "CBANK"},
+        {Number: "+33698765432"},
+    }
+    defer func() {
+        config.Slack.Channel = ""
+        config.Numbers = nil
+    }()
+
+    expected := map[string]string{
+        "+33612345678": "CBANK",
+        "33612345678":  "CBANK",
+        "+33698765432": "CDEFAULT",
+        "+33700000000": "CDEFAULT",
+        "":             "CDEFAULT",
+    }
+
+    for number, channel := range expected {
+        if got := numberChannel(number); got != channel {
+            t.Errorf("invalid channel for %q, expected: %q, got: %q", number, channel, got)
+        }
+    }
+}
+
+func TestFilterRequestsByNumber(t *testing.T) {
+    requests := []*model.ForwardingRequest{
+        {Id: "any"},
+        {Id: "bank", Number: "+33612345678"},
+        {Id: "support", Number: "+33698765432"},
+    }
+
+    filtered := filterRequestsByNumber(requests, "33612345678")
+    if len(filtered) != 2 || filtered[0].Id != "any" || filtered[1].Id != "bank" {
+        t.Errorf("expected the any and bank requests, got: %v", filtered)
+    }
+}
+
+func TestLookupNumber(t *testing.T) {
+    config.Numbers = []PhoneNumber{
+        {Number: "+33612345678", Alias: "bank-line"},
+        {Number: "+33698765432"},
+    }
+    defer func() { config.Numbers = nil }()
+
+    expected := map[string]string{
+        "bank-line":    "+33612345678",
+        "Bank-Line":    "+33612345678",
+        "33612345678":  "+33612345678",
+        "+33698765432": "+33698765432",
+    }
+
+    for name, number := range expected {
+        phoneNumber := lookupNumber(name)
+        if phoneNumber == nil || phoneNumber.Number != number {
+            t.Errorf("invalid number for %q, expected: %q, got: %v", name, number, phoneNumber)
+        }
+    }
+
+    for _, name := range []string{"2h", "support", ""} {
+        if phoneNumber := lookupNumber(name); phoneNumber != nil {
+            t.Errorf("expected no number for %q, got: %v", name, phoneNumber)
+        }
+    }
+}
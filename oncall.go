@@ -0,0 +1,538 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const defaultOnCallRefreshInterval = 5 * time.Minute
+
+var slackUserIdPattern = regexp.MustCompile(`^[UW][A-Z0-9]{6,}$`)
+
+// isSlackUserId tells whether the value looks like a Slack user id. Ids contain
+// digits, unlike words such as WEEKEND.
+func isSlackUserId(value string) bool {
+    return slackUserIdPattern.MatchString(value) && strings.ContainsAny(value, "0123456789")
+}
+
+// calendarEvent is the subset of an iCalendar VEVENT needed to find who is on
+// call.
+type calendarEvent struct {
+    UID       string
+    Summary   string
+    Attendees []string
+    Start     time.Time
+    End       time.Time
+    // Recurrence repeats the event, nil for single events
+    Recurrence *recurrenceRule
+    // Exceptions are the starts of the occurrences which were cancelled or
+    // moved to another event
+    Exceptions []time.Time
+}
+
+func (e calendarEvent) isActive(now time.Time) bool {
+    if e.Recurrence == nil {
+        return !now.Before(e.Start) && now.Before(e.End)
+    }
+
+    duration := e.End.Sub(e.Start)
+    for _, start := range e.Recurrence.starts(e.Start, now) {
+        if now.Before(start.Add(duration)) && !e.isException(start) {
+            return true
+        }
+    }
+
+    return false
+}
+
+func (e calendarEvent) isException(start time.Time) bool {
+    for _, exception := range e.Exceptions {
+        if exception.Equal(start) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// recurrenceRule is a daily or weekly RRULE.
+type recurrenceRule struct {
+    Weekly   bool
+    Interval int
+    // Count limits the number of occurrences, 0 for no limit
+    Count int
+    // Until is the last possible start, zero for no limit
+    Until time.Time
+    // Weekdays are the days of the week of weekly rules, the day of the
+    // first occurrence when empty
+    Weekdays []time.Weekday
+}
+
+// parseRecurrenceRule parses rules such as `FREQ=WEEKLY;INTERVAL=2;BYDAY=MO`.
+func parseRecurrenceRule(value string) (*recurrenceRule, error) {
+    rule := &recurrenceRule{Interval: 1}
+    frequency := ""
+
+    for _, part := range strings.Split(value, ";") {
+        keyValue := strings.SplitN(part, "=", 2)
+        if len(keyValue) != 2 {
+            return nil, fmt.Errorf("invalid recurrence rule %q", value)
+        }
+
+        var err error
+        switch strings.ToUpper(keyValue[0]) {
+        case "FREQ":
+            frequency = strings.ToUpper(keyValue[1])
+        case "INTERVAL":
+            rule.Interval, err = strconv.Atoi(keyValue[1])
+            if err == nil && rule.Interval < 1 {
+                err = fmt.Errorf("invalid interval %q", keyValue[1])
+            }
+        case "COUNT":
+            rule.Count, err = strconv.Atoi(keyValue[1])
+        case "UNTIL":
+            rule.Until, err = parseCalendarTime(nil, keyValue[1])
+        case "BYDAY":
+            for _, day := range strings.Split(keyValue[1], ",") {
+                weekday, ok := calendarWeekdays[strings.ToUpper(day)]
+                if !ok {
+                    return nil, fmt.Errorf("unsupported day %q in recurrence rule %q", day, value)
+                }
+                rule.Weekdays = append(rule.Weekdays, weekday)
+            }
+        case "WKST":
+            // Only matters for weekly rules with an interval spanning a
+            // week start other than Monday, which calendars don't generate
+        default:
+            return nil, fmt.Errorf("unsupported %s in recurrence rule %q", keyValue[0], value)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("invalid recurrence rule %q: %w", value, err)
+        }
+    }
+
+    switch frequency {
+    case "DAILY":
+        if len(rule.Weekdays) > 0 {
+            return nil, fmt.Errorf("unsupported BYDAY in daily recurrence rule %q", value)
+        }
+    case "WEEKLY":
+        rule.Weekly = true
+    default:
+        return nil, fmt.Errorf("unsupported frequency in recurrence rule %q", value)
+    }
+
+    return rule, nil
+}
+
+var calendarWeekdays = map[string]time.Weekday{
+    "MO": time.Monday,
+    "TU": time.Tuesday,
+    "WE": time.Wednesday,
+    "TH": time.Thursday,
+    "FR": time.Friday,
+    "SA": time.Saturday,
+    "SU": time.Sunday,
+}
+
+// starts returns the starts of the occurrences beginning at first, up to
+// before.
+func (r recurrenceRule) starts(first time.Time, before time.Time) []time.Time {
+    weekdays := r.Weekdays
+    if !r.Weekly || len(weekdays) == 0 {
+        weekdays = []time.Weekday{first.Weekday()}
+    }
+
+    step := r.Interval
+    if r.Weekly {
+        step *= 7
+    }
+
+    // Weeks start on Monday
+    periodStart := first
+    if r.Weekly {
+        periodStart = first.AddDate(0, 0, -((int(first.Weekday()) + 6) % 7))
+    }
+
+    var starts []time.Time
+    for period := 0; ; period++ {
+        for _, weekday := range weekdays {
+            start := periodStart.AddDate(0, 0, period*step)
+            if r.Weekly {
+                start = start.AddDate(0, 0, (int(weekday)+6)%7)
+            }
+
+            if start.Before(first) {
+                continue
+            }
+            if start.After(before) || (!r.Until.IsZero() && start.After(r.Until)) {
+                return starts
+            }
+            if r.Count > 0 && len(starts) >= r.Count {
+                return starts
+            }
+
+            starts = append(starts, start)
+        }
+    }
+}
+
+// OnCallCalendar reads the on-call rotation from an iCalendar feed, either a
+// local file or an http(s) URL. The feed is cached for refreshInterval.
+type OnCallCalendar struct {
+    source          string
+    users           map[string]string
+    refreshInterval time.Duration
+    client          *http.Client
+
+    mu       sync.Mutex
+    events   []calendarEvent
+    loadedAt time.Time
+}
+
+// NewOnCallCalendar creates a calendar reading source. users maps the
+// attendees' emails or the events' summaries to Slack user ids.
+func NewOnCallCalendar(source string, users map[string]string, refreshInterval time.Duration) *OnCallCalendar {
+    if refreshInterval <= 0 {
+        refreshInterval = defaultOnCallRefreshInterval
+    }
+
+    normalizedUsers := make(map[string]string, len(users))
+    for key, userId := range users {
+        normalizedUsers[strings.ToLower(key)] = userId
+    }
+
+    return &OnCallCalendar{
+        source:          source,
+        users:           normalizedUsers,
+        refreshInterval: refreshInterval,
+        client:          &http.Client{Timeout: 10 * time.Second},
+    }
+}
+
+// CurrentUsers returns the Slack user ids of the people on call at now. When
+// the feed can't be refreshed, the previous events are used.
+func (c *OnCallCalendar) CurrentUsers(ctx context.Context, now time.Time) ([]string, error) {
+    events, err := c.getEvents(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    var userIds []string
+    for _, event := range events {
+        if !event.isActive(now) {
+            continue
+        }
+
+        userId, ok := c.resolveUser(event)
+        if !ok {
+            log.Printf("No Slack user found for on-call event %q", event.Summary)
+            continue
+        }
+
+        if !contains(userIds, userId) {
+            userIds = append(userIds, userId)
+        }
+    }
+
+    return userIds, nil
+}
+
+func (c *OnCallCalendar) resolveUser(event calendarEvent) (string, bool) {
+    candidates := append([]string{}, event.Attendees...)
+    for _, candidate := range append(candidates, event.Summary) {
+        if userId, ok := c.users[strings.ToLower(candidate)]; ok {
+            return userId, true
+        }
+
+        if isSlackUserId(candidate) {
+            return candidate, true
+        }
+    }
+
+    return "", false
+}
+
+func (c *OnCallCalendar) getEvents(ctx context.Context) ([]calendarEvent, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < c.refreshInterval {
+        return c.events, nil
+    }
+
+    events, err := c.load(ctx)
+    if err != nil {
+        if c.loadedAt.IsZero() {
+            return nil, err
+        }
+        log.Printf("Failed to refresh the on-call calendar, using the previous version: %v", err)
+        return c.events, nil
+    }
+
+    c.events = events
+    c.loadedAt = time.Now()
+
+    return events, nil
+}
+
+func (c *OnCallCalendar) load(ctx context.Context) ([]calendarEvent, error) {
+    if !strings.HasPrefix(c.source, "http://") && !strings.HasPrefix(c.source, "https://") {
+        f, err := os.Open(c.source)
+        if err != nil {
+            return nil, fmt.Errorf("failed to open the on-call calendar: %w", err)
+        }
+        defer f.Close()
+
+        return parseCalendar(f)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "GET", c.source, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    res, err := c.client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to download the on-call calendar: %w", err)
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("failed to download the on-call calendar: %s", res.Status)
+    }
+
+    return parseCalendar(res.Body)
+}
+
+// onCallUserIds returns the people currently on call for the number, if a
+// calendar is configured. Errors are only logged so that texts are still
+// forwarded.
+func onCallUserIds(ctx context.Context, number string) []string {
+    if onCall == nil || !onCallForwards(number) {
+        return nil
+    }
+
+    userIds, err := onCall.CurrentUsers(ctx, time.Now())
+    if err != nil {
+        log.Printf("Failed to get the on-call users: %v", err)
+        return nil
+    }
+
+    return userIds
+}
+
+// onCallForwards tells whether the texts sent to the number are forwarded to
+// the people on call.
+func onCallForwards(number string) bool {
+    if len(config.OnCall.Numbers) == 0 {
+        return !hasOwnAdmins(number)
+    }
+
+    for _, name := range config.OnCall.Numbers {
+        phoneNumber := lookupNumber(name)
+        if phoneNumber != nil {
+            name = phoneNumber.Number
+        }
+
+        if normalizeNumber(name) == normalizeNumber(number) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// parseCalendar extracts the events of an iCalendar (RFC 5545) document.
+// Daily and weekly recurrences are supported. The events repeating with other
+// rules or with invalid dates are ignored with an error in the logs, so that
+// they don't prevent the other people from being on call.
+func parseCalendar(r io.Reader) ([]calendarEvent, error) {
+    lines, err := unfoldCalendarLines(r)
+    if err != nil {
+        return nil, err
+    }
+
+    var events []calendarEvent
+    var event *calendarEvent
+    var cancelled, allDay bool
+    var recurrenceId time.Time
+    // eventErr is the first error found in the current event
+    var eventErr error
+    // moved holds the original starts of the occurrences overridden by
+    // another event, by UID
+    moved := make(map[string][]time.Time)
+
+    for _, line := range lines {
+        name, params, value := parseCalendarLine(line)
+
+        if name == "BEGIN" && strings.EqualFold(value, "VEVENT") {
+            event = &calendarEvent{}
+            cancelled, allDay = false, false
+            recurrenceId, eventErr = time.Time{}, nil
+            continue
+        }
+
+        if event == nil {
+            continue
+        }
+
+        switch name {
+        case "END":
+            if !strings.EqualFold(value, "VEVENT") {
+                continue
+            }
+            if event.End.IsZero() && allDay {
+                event.End = event.Start.AddDate(0, 0, 1)
+            }
+            if eventErr != nil {
+                log.Printf("Ignoring the on-call event %q: %v", event.Summary, eventErr)
+                event = nil
+                continue
+            }
+            if !recurrenceId.IsZero() {
+                moved[event.UID] = append(moved[event.UID], recurrenceId)
+            }
+            if !cancelled && !event.Start.IsZero() {
+                events = append(events, *event)
+            }
+            event = nil
+        case "UID":
+            event.UID = value
+        case "RRULE":
+            event.Recurrence, err = parseRecurrenceRule(value)
+        case "EXDATE":
+            for _, exdate := range strings.Split(value, ",") {
+                var exception time.Time
+                exception, err = parseCalendarTime(params, exdate)
+                if err != nil {
+                    break
+                }
+                event.Exceptions = append(event.Exceptions, exception)
+            }
+        case "RECURRENCE-ID":
+            recurrenceId, err = parseCalendarTime(params, value)
+        case "SUMMARY":
+            event.Summary = unescapeCalendarText(value)
+        case "ATTENDEE":
+            if strings.HasPrefix(strings.ToLower(value), "mailto:") {
+                event.Attendees = append(event.Attendees, value[len("mailto:"):])
+            }
+            if cn, ok := params["CN"]; ok {
+                event.Attendees = append(event.Attendees, cn)
+            }
+        case "STATUS":
+            cancelled = strings.EqualFold(value, "CANCELLED")
+        case "DTSTART":
+            event.Start, err = parseCalendarTime(params, value)
+            allDay = len(value) == len("20060102")
+        case "DTEND":
+            event.End, err = parseCalendarTime(params, value)
+        }
+
+        if err != nil && eventErr == nil {
+            eventErr = err
+        }
+        err = nil
+    }
+
+    for i := range events {
+        if events[i].Recurrence != nil {
+            events[i].Exceptions = append(events[i].Exceptions, moved[events[i].UID]...)
+        }
+    }
+
+    return events, nil
+}
+
+// unfoldCalendarLines joins the lines split by the folding of RFC 5545.
+func unfoldCalendarLines(r io.Reader) ([]string, error) {
+    var lines []string
+
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        line := strings.TrimRight(scanner.Text(), "\r")
+        if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+            lines[len(lines)-1] += line[1:]
+            continue
+        }
+        lines = append(lines, line)
+    }
+
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read the calendar: %w", err)
+    }
+
+    return lines, nil
+}
+
+// parseCalendarLine splits a content line such as
+// `DTSTART;TZID=Europe/Paris:20230310T090000`.
+func parseCalendarLine(line string) (string, map[string]string, string) {
+    nameAndParams, value := line, ""
+    inQuotes := false
+    for i, c := range line {
+        if c == '"' {
+            inQuotes = !inQuotes
+        }
+        if c == ':' && !inQuotes {
+            nameAndParams, value = line[:i], line[i+1:]
+            break
+        }
+    }
+
+    parts := strings.Split(nameAndParams, ";")
+    params := make(map[string]string)
+    for _, param := range parts[1:] {
+        keyValue := strings.SplitN(param, "=", 2)
+        if len(keyValue) == 2 {
+            params[strings.ToUpper(keyValue[0])] = strings.Trim(keyValue[1], `"`)
+        }
+    }
+
+    return strings.ToUpper(parts[0]), params, value
+}
+
+// parseCalendarTime parses UTC, TZID-qualified, floating and date values.
+// Floating times and dates use the local timezone.
+func parseCalendarTime(params map[string]string, value string) (time.Time, error) {
+    location := time.Local
+    if tzid, ok := params["TZID"]; ok {
+        var err error
+        location, err = time.LoadLocation(tzid)
+        if err != nil {
+            return time.Time{}, fmt.Errorf("unknown calendar timezone %q: %w", tzid, err)
+        }
+    }
+
+    var t time.Time
+    var err error
+    switch {
+    case len(value) == len("20060102"):
+        t, err = time.ParseInLocation("20060102", value, location)
+    case strings.HasSuffix(value, "Z"):
+        t, err = time.Parse("20060102T150405Z", value)
+    default:
+        t, err = time.ParseInLocation("20060102T150405", value, location)
+    }
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid calendar time %q: %w", value, err)
+    }
+
+    return t, nil
+}
+
+func unescapeCalendarText(value string) string {
+    return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
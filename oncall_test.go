@@ -0,0 +1,291 @@
+package main
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+const onCallCalendar = "BEGIN:VCALENDAR\r\n" +
+    "VERSION:2.0\r\n" +
+    "BEGIN:VEVENT\r\n" +
+    "SUMMARY:On call: Alice\r\n" +
+    "ATTENDEE;CN=Alice;ROLE=REQ-PARTICIPANT:mailto:alice@example.com\r\n" +
+    "DTSTART;TZID=Europe/Paris:20230310T090000\r\n" +
+    "DTEND;TZID=Europe/Paris:20230313T090000\r\n" +
+    "END:VEVENT\r\n" +
+    "BEGIN:VEVENT\r\n" +
+    "SUMMARY:U0123ABCD\r\n" +
+    "DTSTART:20230311T000000Z\r\n" +
+    "DTEND:20230311T120000Z\r\n" +
+    "END:VEVENT\r\n" +
+    "BEGIN:VEVENT\r\n" +
+    "SUMMARY:On call: Bob\\, backup\r\n" +
+    "DESCRIPTION:A long description that is folded\r\n" +
+    "  over two lines\r\n" +
+    "DTSTART:20230311T000000Z\r\n" +
+    "DTEND:20230311T120000Z\r\n" +
+    "STATUS:CANCELLED\r\n" +
+    "END:VEVENT\r\n" +
+    "END:VCALENDAR\r\n"
+
+func TestParseCalendar(t *testing.T) {
+    paris, _ := time.LoadLocation("Europe/Paris")
+
+    events, err := parseCalendar(strings.NewReader(onCallCalendar))
+    if err != nil {
+        t.Fatalf("failed to parse the calendar: %s", err)
+    }
+
+    if len(events) != 2 {
+        t.Fatalf("expected 2 events, got: %d", len(events))
+    }
+
+    if events[0].Summary != "On call: Alice" {
+        t.Errorf("invalid summary, expected: %q, got: %q", "On call: Alice", events[0].Summary)
+    }
+
+    expectedAttendees := []string{"alice@example.com", "Alice"}
+    if !reflect.DeepEqual(events[0].Attendees, expectedAttendees) {
+        t.Errorf("invalid attendees, expected: %v, got: %v", expectedAttendees, events[0].Attendees)
+    }
+
+    if !events[0].Start.Equal(time.Date(2023, 3, 10, 9, 0, 0, 0, paris)) {
+        t.Errorf("invalid start, got: %s", events[0].Start)
+    }
+
+    if !events[1].End.Equal(time.Date(2023, 3, 11, 12, 0, 0, 0, time.UTC)) {
+        t.Errorf("invalid end, got: %s", events[1].End)
+    }
+}
+
+func TestParseCalendar_AllDayEvent(t *testing.T) {
+    events, err := parseCalendar(strings.NewReader("BEGIN:VEVENT\nSUMMARY:Alice\nDTSTART;VALUE=DATE:20230310\nEND:VEVENT\n"))
+    if err != nil {
+        t.Fatalf("failed to parse the calendar: %s", err)
+    }
+
+    if len(events) != 1 || events[0].End.Sub(events[0].Start) != 24*time.Hour {
+        t.Errorf("expected a one day event, got: %v", events)
+    }
+}
+
+func TestOnCallCalendar_CurrentUsers(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "on-call.ics")
+    err := os.WriteFile(path, []byte(onCallCalendar), 0600)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    calendar := NewOnCallCalendar(path, map[string]string{"Alice@example.com": "UALICE"}, time.Minute)
+
+    tests := []struct {
+        now      time.Time
+        expected []string
+    }{
+        {time.Date(2023, 3, 10, 7, 59, 0, 0, time.UTC), nil},
+        {time.Date(2023, 3, 10, 8, 0, 0, 0, time.UTC), []string{"UALICE"}},
+        {time.Date(2023, 3, 11, 6, 0, 0, 0, time.UTC), []string{"UALICE", "U0123ABCD"}},
+        {time.Date(2023, 3, 13, 8, 0, 0, 0, time.UTC), nil},
+    }
+
+    for _, test := range tests {
+        userIds, err := calendar.CurrentUsers(context.Background(), test.now)
+        if err != nil {
+            t.Fatalf("failed to get the on-call users: %s", err)
+        }
+
+        if !reflect.DeepEqual(userIds, test.expected) {
+            t.Errorf("invalid on-call users at %s, expected: %v, got: %v", test.now, test.expected, userIds)
+        }
+    }
+}
+
+func TestOnCallCalendar_MissingFile(t *testing.T) {
+    calendar := NewOnCallCalendar(filepath.Join(t.TempDir(), "missing.ics"), nil, time.Minute)
+
+    _, err := calendar.CurrentUsers(context.Background(), time.Now())
+    if err == nil {
+        t.Errorf("expected an error for a missing calendar")
+    }
+}
+
+const recurringOnCallCalendar = "BEGIN:VCALENDAR\r\n" +
+    "BEGIN:VEVENT\r\n" +
+    "UID:alice-rotation\r\n" +
+    "SUMMARY:UALICE01\r\n" +
+    "DTSTART;TZID=Europe/Paris:20230306T090000\r\n" +
+    "DTEND;TZID=Europe/Paris:20230313T090000\r\n" +
+    "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO\r\n" +
+    "EXDATE;TZID=Europe/Paris:20230403T090000\r\n" +
+    "END:VEVENT\r\n" +
+    "BEGIN:VEVENT\r\n" +
+    "UID:bob-rotation\r\n" +
+    "SUMMARY:UBOB0001\r\n" +
+    "DTSTART;TZID=Europe/Paris:20230313T090000\r\n" +
+    "DTEND;TZID=Europe/Paris:20230320T090000\r\n" +
+    "RRULE:FREQ=WEEKLY;INTERVAL=2;UNTIL=20230403T000000Z\r\n" +
+    "END:VEVENT\r\n" +
+    "BEGIN:VEVENT\r\n" +
+    "UID:alice-rotation\r\n" +
+    "RECURRENCE-ID;TZID=Europe/Paris:20230417T090000\r\n" +
+    "SUMMARY:UCAROL01\r\n" +
+    "DTSTART;TZID=Europe/Paris:20230417T090000\r\n" +
+    "DTEND;TZID=Europe/Paris:20230424T090000\r\n" +
+    "END:VEVENT\r\n" +
+    "BEGIN:VEVENT\r\n" +
+    "UID:monthly\r\n" +
+    "SUMMARY:UDAVE001\r\n" +
+    "DTSTART:20230301T000000Z\r\n" +
+    "DTEND:20230401T000000Z\r\n" +
+    "RRULE:FREQ=MONTHLY\r\n" +
+    "END:VEVENT\r\n" +
+    "END:VCALENDAR\r\n"
+
+func TestParseCalendar_RecurringEvents(t *testing.T) {
+    events, err := parseCalendar(strings.NewReader(recurringOnCallCalendar))
+    if err != nil {
+        t.Fatalf("failed to parse the calendar: %s", err)
+    }
+
+    // The monthly rotation isn't supported
+    if len(events) != 3 {
+        t.Fatalf("expected 3 events, got: %d", len(events))
+    }
+
+    calendar := &OnCallCalendar{events: events, loadedAt: time.Now(), refreshInterval: time.Hour}
+
+    tests := []struct {
+        now      time.Time
+        expected []string
+    }{
+        {time.Date(2023, 3, 8, 12, 0, 0, 0, time.UTC), []string{"UALICE01"}},
+        {time.Date(2023, 3, 15, 12, 0, 0, 0, time.UTC), []string{"UBOB0001"}},
+        {time.Date(2023, 3, 22, 12, 0, 0, 0, time.UTC), []string{"UALICE01"}},
+        // The occurrences start at 9:00 in Paris after the summer time change
+        {time.Date(2023, 3, 27, 6, 59, 0, 0, time.UTC), []string{"UALICE01"}},
+        {time.Date(2023, 3, 27, 8, 0, 0, 0, time.UTC), []string{"UBOB0001"}},
+        // Excluded occurrence of Alice
+        {time.Date(2023, 4, 5, 12, 0, 0, 0, time.UTC), nil},
+        // Bob's rotation ended
+        {time.Date(2023, 4, 12, 12, 0, 0, 0, time.UTC), nil},
+        // Carol replaces Alice
+        {time.Date(2023, 4, 19, 12, 0, 0, 0, time.UTC), []string{"UCAROL01"}},
+        {time.Date(2023, 5, 3, 12, 0, 0, 0, time.UTC), []string{"UALICE01"}},
+    }
+
+    for _, test := range tests {
+        userIds, err := calendar.CurrentUsers(context.Background(), test.now)
+        if err != nil {
+            t.Fatalf("failed to get the on-call users: %s", err)
+        }
+
+        if !reflect.DeepEqual(userIds, test.expected) {
+            t.Errorf("invalid on-call users at %s, expected: %v, got: %v", test.now, test.expected, userIds)
+        }
+    }
+}
+
+func TestParseRecurrenceRule_Invalid(t *testing.T) {
+    for _, value := range []string{
+        "FREQ=MONTHLY",
+        "FREQ=YEARLY;BYMONTH=1",
+        "FREQ=WEEKLY;BYDAY=1MO",
+        "FREQ=DAILY;BYDAY=MO",
+        "FREQ=WEEKLY;INTERVAL=0",
+        "FREQ=WEEKLY;BYSETPOS=1",
+    } {
+        if _, err := parseRecurrenceRule(value); err == nil {
+            t.Errorf("expected %q to be rejected", value)
+        }
+    }
+}
+
+func TestOnCallForwards(t *testing.T) {
+    config.Numbers = []PhoneNumber{
+        {Number: "+33612345678", Alias: "bank-line", Admins: []string{"UFINANCE"}},
+        {Number: "+33698765432"},
+    }
+    defer func() {
+        config.Numbers = nil
+        config.OnCall.Numbers = nil
+    }()
+
+    // By default, the numbers answered by the Slack admins
+    if !onCallForwards("33698765432") || !onCallForwards("33600000000") {
+        t.Errorf("expected the numbers of the Slack admins to be forwarded to the people on call")
+    }
+    if onCallForwards("33612345678") {
+        t.Errorf("expected a number with its own admins not to be forwarded to the people on call")
+    }
+
+    config.OnCall.Numbers = []string{"bank-line"}
+    if !onCallForwards("33612345678") || onCallForwards("33698765432") {
+        t.Errorf("expected only the configured numbers to be forwarded to the people on call")
+    }
+}
+
+func TestParseCalendar_InvalidEvent(t *testing.T) {
+    calendar := "BEGIN:VCALENDAR\r\n" +
+        "BEGIN:VEVENT\r\n" +
+        "SUMMARY:UBROKEN1\r\n" +
+        "DTSTART;TZID=Mars/Olympus:20230310T090000\r\n" +
+        "DTEND;TZID=Mars/Olympus:20230313T090000\r\n" +
+        "END:VEVENT\r\n" +
+        "BEGIN:VEVENT\r\n" +
+        "SUMMARY:UALICE01\r\n" +
+        "DTSTART:20230310T080000Z\r\n" +
+        "DTEND:20230313T080000Z\r\n" +
+        "END:VEVENT\r\n" +
+        "BEGIN:VEVENT\r\n" +
+        "SUMMARY:UBOB0001\r\n" +
+        "DTSTART:not-a-date\r\n" +
+        "END:VEVENT\r\n" +
+        "END:VCALENDAR\r\n"
+
+    events, err := parseCalendar(strings.NewReader(calendar))
+    if err != nil {
+        t.Fatalf("failed to parse the calendar: %s", err)
+    }
+
+    // The invalid events don't prevent Alice from being on call
+    if len(events) != 1 || events[0].Summary != "UALICE01" {
+        t.Errorf("expected only the valid event, got: %v", events)
+    }
+}
+
+func TestOnCallCalendar_ResolveUser(t *testing.T) {
+    calendar := NewOnCallCalendar("", map[string]string{"alice@example.com": "UALICE", "owner@example.com": "UOWNER"}, time.Minute)
+
+    tests := []struct {
+        event    calendarEvent
+        userId   string
+        resolved bool
+    }{
+        {calendarEvent{Summary: "U0123ABCD"}, "U0123ABCD", true},
+        {calendarEvent{Summary: "On call", Attendees: []string{"alice@example.com"}}, "UALICE", true},
+        // Words looking like user ids
+        {calendarEvent{Summary: "WEEKEND"}, "", false},
+        {calendarEvent{Summary: "UNASSIGNED"}, "", false},
+    }
+
+    for _, test := range tests {
+        userId, ok := calendar.resolveUser(test.event)
+        if userId != test.userId || ok != test.resolved {
+            t.Errorf("%+v: expected %q (%t), got: %q (%t)", test.event, test.userId, test.resolved, userId, ok)
+        }
+    }
+
+    // The organizer created the event but isn't on call
+    events, err := parseCalendar(strings.NewReader("BEGIN:VEVENT\nSUMMARY:On call\nORGANIZER;CN=Owner:mailto:owner@example.com\nDTSTART:20230310T080000Z\nEND:VEVENT\n"))
+    if err != nil {
+        t.Fatalf("failed to parse the calendar: %s", err)
+    }
+    if len(events) != 1 || len(events[0].Attendees) != 0 {
+        t.Errorf("expected the organizer not to be an attendee, got: %v", events)
+    }
+}
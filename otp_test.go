@@ -0,0 +1,71 @@
+package main
+
+import (
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/slack-go/slack"
+    "testing"
+)
+
+func TestFindVerificationCode(t *testing.T) {
+    tests := []struct {
+        body    string
+        code    string
+        service string
+    }{
+        {"Your Acme verification code is 123456", "123456", "Acme"},
+        {"G-483920 is your Google verification code.", "483920", "Google"},
+        {"123-456 is your Big Bank code", "123456", "Big Bank"},
+        {"Your code for Acme is 8842. Don't share it.", "8842", "Acme"},
+        {"[Acme] 5531 is your login code", "5531", "Acme"},
+        {"Acme: use 991204 to sign in", "991204", "Acme"},
+        {"Your verification code is 123456", "123456", ""},
+        {"Your one-time code: 40981", "40981", ""},
+    }
+
+    for _, test := range tests {
+        code, ok := messages.FindVerificationCode(test.body)
+        if !ok {
+            t.Errorf("expected a code in %q", test.body)
+            continue
+        }
+
+        if code.Code != test.code || code.Service != test.service {
+            t.Errorf("invalid code in %q, expected: %q from %q, got: %q from %q", test.body, test.code, test.service, code.Code, code.Service)
+        }
+    }
+}
+
+func TestFindVerificationCode_NoCode(t *testing.T) {
+    tests := []string{
+        "Your parcel 1234 arrives on 2023-03-10",
+        "Your code expired, please ask for a new one",
+        "Your verification code is ••••••",
+        // Keywords are only matched as whole words
+        "Your order is shipping, tracking 48213",
+        "Barcode 84213 scanned at the depot",
+        "Your tokens balance is 2500",
+    }
+
+    for _, body := range tests {
+        if code, ok := messages.FindVerificationCode(body); ok {
+            t.Errorf("expected no code in %q, got: %q", body, code.Code)
+        }
+    }
+}
+
+func TestSmsUserNotifyMessage_NotificationText(t *testing.T) {
+    message := messages.SmsUserNotifyMessage(model.SMS{From: "ACME", Body: "Your Acme verification code is 123456"})
+    if message.Text != "Acme code: 123456" {
+        t.Errorf("invalid notification text, expected: %q, got: %q", "Acme code: 123456", message.Text)
+    }
+
+    if _, ok := message.Blocks.BlockSet[1].(*slack.SectionBlock); !ok {
+        t.Errorf("expected the code in its own section, got: %v", message.Blocks.BlockSet[1])
+    }
+
+    message = messages.SmsChannelNotifyMessage(model.SMS{From: "ACME", Body: "Your Acme verification code is 123456"}, nil, messages.Redaction{Enabled: true})
+    if message.Text != "Message from ACME" {
+        t.Errorf("redacted codes must not appear in the notification text, got: %q", message.Text)
+    }
+}
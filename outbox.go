@@ -0,0 +1,194 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/CedricFinance/phone_operator/repository"
+    "github.com/slack-go/slack"
+    "log"
+    "time"
+)
+
+const (
+    outboxPollInterval = 30 * time.Second
+    outboxBatchSize    = 20
+    outboxLease        = time.Minute
+    outboxMaxAttempts  = 12
+    outboxBaseBackoff  = 15 * time.Second
+    outboxMaxBackoff   = 30 * time.Minute
+)
+
+// OutboxWorker delivers the Slack posts queued in the outbox, retrying failed
+// ones with an exponential backoff.
+type OutboxWorker struct {
+    wakeUp chan struct{}
+}
+
+func NewOutboxWorker() *OutboxWorker {
+    return &OutboxWorker{
+        wakeUp: make(chan struct{}, 1),
+    }
+}
+
+// Notify asks the worker to process the outbox without waiting for the next
+// poll.
+func (o *OutboxWorker) Notify() {
+    select {
+    case o.wakeUp <- struct{}{}:
+    default:
+    }
+}
+
+func (o *OutboxWorker) Run(ctx context.Context) {
+    ticker := time.NewTicker(outboxPollInterval)
+    defer ticker.Stop()
+
+    for {
+        o.processDueEntries(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        case <-o.wakeUp:
+        }
+    }
+}
+
+func (o *OutboxWorker) processDueEntries(ctx context.Context) {
+    entries, err := repo.GetDueOutboxEntries(ctx, outboxBatchSize)
+    if err != nil {
+        log.Printf("Failed to load the outbox: %v", err)
+        return
+    }
+
+    for _, entry := range entries {
+        claimed, err := repo.ClaimOutboxEntry(ctx, entry, time.Now().UTC().Add(outboxLease))
+        if err != nil {
+            log.Printf("Failed to claim outbox entry %s: %v", entry.Id, err)
+            continue
+        }
+        if !claimed {
+            continue
+        }
+
+        err = deliverOutboxEntry(ctx, entry)
+        if err == nil {
+            err = repo.MarkOutboxEntrySent(ctx, entry.Id)
+            if err != nil {
+                log.Printf("Failed to mark outbox entry %s as sent: %v", entry.Id, err)
+            }
+            continue
+        }
+
+        attempts := entry.Attempts + 1
+        if attempts >= outboxMaxAttempts {
+            log.Printf("Giving up outbox entry %s after %d attempts: %v", entry.Id, attempts, err)
+            err = repo.AbandonOutboxEntry(ctx, entry.Id, err.Error())
+        } else {
+            log.Printf("Failed to deliver outbox entry %s (attempt %d): %v", entry.Id, attempts, err)
+            err = repo.RescheduleOutboxEntry(ctx, entry.Id, time.Now().UTC().Add(outboxBackoff(attempts)), err.Error())
+        }
+        if err != nil {
+            log.Printf("Failed to update outbox entry %s: %v", entry.Id, err)
+        }
+    }
+}
+
+// outboxBackoff returns the delay before the next attempt once attempts
+// deliveries have failed.
+func outboxBackoff(attempts int) time.Duration {
+    backoff := outboxBaseBackoff
+    for i := 1; i < attempts; i++ {
+        backoff *= 2
+        if backoff >= outboxMaxBackoff {
+            return outboxMaxBackoff
+        }
+    }
+
+    return backoff
+}
+
+func deliverOutboxEntry(ctx context.Context, entry *model.OutboxEntry) error {
+    message, err := repo.GetMessage(ctx, entry.MessageId)
+    if err != nil {
+        return err
+    }
+
+    switch entry.Kind {
+    case model.OutboxKindUser:
+        deliveryErr := notifyUserBlock(ctx, entry.Recipient, messages.SmsUserNotifyMessage(*message))
+
+        // Recipients without a request still get a delivery for the audit
+        requestIds := entry.ForwardingRequestIds
+        if len(requestIds) == 0 {
+            requestIds = []string{""}
+        }
+
+        for _, requestId := range requestIds {
+            err = repo.SaveDelivery(ctx, repository.NewDelivery(message.Id, requestId, entry.Recipient, deliveryErr))
+            if err != nil {
+                log.Printf("Failed to save delivery of SMS %s to %s: %v", message.Id, entry.Recipient, err)
+            }
+        }
+
+        return deliveryErr
+
+    case model.OutboxKindChannel:
+        threadTs, err := senderThread(ctx, message, entry.Recipient)
+        if err != nil {
+            log.Printf("Failed to find the thread of %s: %v", message.From, err)
+        }
+
+        channelMessage := messages.SmsChannelNotifyMessage(*message, entry.UserIds, redaction())
+        options := []slack.MsgOption{
+            slack.MsgOptionBlocks(channelMessage.Blocks.BlockSet...),
+            slack.MsgOptionText(channelMessage.Text, false),
+        }
+        if threadTs != "" {
+            options = append(options, slack.MsgOptionTS(threadTs))
+        }
+
+        channel, ts, err := slackClient.PostMessageContext(ctx, entry.Recipient, options...)
+        if err != nil {
+            return fmt.Errorf("failed to publish SMS to Slack: %w", err)
+        }
+
+        // The post must not be retried if it can't be recorded
+        err = repo.SetMessageSlackPost(ctx, message.Id, channel, ts, threadTs)
+        if err != nil {
+            log.Printf("Failed to save the Slack post of SMS %s: %v", message.Id, err)
+        }
+
+        return nil
+    }
+
+    return fmt.Errorf("unknown outbox entry kind %q", entry.Kind)
+}
+
+// senderThread returns the thread in which the message must be posted, empty
+// when it starts a new conversation with the sender. The window is measured
+// from the first post of the thread, so a chatty sender doesn't keep a thread
+// open forever.
+func senderThread(ctx context.Context, message *model.SMS, recipient string) (string, error) {
+    window := time.Duration(config.Slack.ThreadWindow) * time.Minute
+    if window <= 0 {
+        return "", nil
+    }
+
+    since := message.ReceivedAt.Add(-window)
+    posts, err := repo.GetSenderPosts(ctx, message.From, recipient, since)
+    if err != nil {
+        return "", err
+    }
+
+    for _, post := range posts {
+        if post.SlackThreadTs == "" && !post.ReceivedAt.Before(since) {
+            return post.SlackTs, nil
+        }
+    }
+
+    return "", nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+    "context"
+    "database/sql/driver"
+    "github.com/CedricFinance/phone_operator/model"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+    expected := map[int]time.Duration{
+        1:  15 * time.Second,
+        2:  30 * time.Second,
+        3:  time.Minute,
+        5:  4 * time.Minute,
+        8:  30 * time.Minute,
+        20: 30 * time.Minute,
+    }
+
+    for attempts, backoff := range expected {
+        if got := outboxBackoff(attempts); got != backoff {
+            t.Errorf("invalid backoff after %d attempt(s), expected: %s, got: %s", attempts, backoff, got)
+        }
+    }
+}
+
+func TestOutboxWorker_Notify(t *testing.T) {
+    worker := NewOutboxWorker()
+
+    // Notify must never block, even when a wake up is already pending
+    worker.Notify()
+    worker.Notify()
+
+    select {
+    case <-worker.wakeUp:
+    default:
+        t.Errorf("expected a pending wake up")
+    }
+}
+
+func TestSenderThread_Disabled(t *testing.T) {
+    config.Slack.ThreadWindow = 0
+
+    // The repository isn't needed when threads are disabled
+    threadTs, err := senderThread(context.Background(), &model.SMS{From: "ACME"}, "CDEFAULT")
+    if err != nil || threadTs != "" {
+        t.Errorf("expected no thread, got: %q, %v", threadTs, err)
+    }
+}
+
+func TestSenderThread(t *testing.T) {
+    config.Slack.ThreadWindow = 30
+    defer func() { config.Slack.ThreadWindow = 0 }()
+
+    now := time.Now().UTC()
+    message := &model.SMS{Id: "MESSAGE", From: "ACME", ReceivedAt: now}
+
+    first := model.SMS{Id: "FIRST", From: "ACME", ReceivedAt: now.Add(-40 * time.Minute), SlackChannel: "CDEFAULT", SlackTs: "1600000000.000001"}
+    inThread := model.SMS{Id: "SECOND", From: "ACME", ReceivedAt: now.Add(-10 * time.Minute), SlackChannel: "CDEFAULT", SlackTs: "1600000000.000002", SlackThreadTs: first.SlackTs}
+    recent := model.SMS{Id: "RECENT", From: "ACME", ReceivedAt: now.Add(-5 * time.Minute), SlackChannel: "CDEFAULT", SlackTs: "1600000000.000003"}
+
+    tests := []struct {
+        name     string
+        posts    []model.SMS
+        expected string
+    }{
+        {"inside the window", []model.SMS{recent}, recent.SlackTs},
+        {"after a reply in the thread", []model.SMS{recent, inThread}, recent.SlackTs},
+        // The last message is recent, but the thread started too long ago
+        {"outside the window", []model.SMS{inThread, first}, ""},
+        {"first message", nil, ""},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newFakeRepository(t)
+            var rows [][]driver.Value
+            for _, post := range test.posts {
+                rows = append(rows, messageRow(post))
+            }
+            db.OnQuery("JOIN OutboxEntries", rows...)
+
+            threadTs, err := senderThread(context.Background(), message, "CDEFAULT")
+            if err != nil {
+                t.Fatalf("unexpected error: %s", err)
+            }
+
+            if threadTs != test.expected {
+                t.Errorf("invalid thread, expected: %q, got: %q", test.expected, threadTs)
+            }
+        })
+    }
+}
+
+func TestSenderThread_OtherChannel(t *testing.T) {
+    config.Slack.ThreadWindow = 30
+    defer func() { config.Slack.ThreadWindow = 0 }()
+
+    db := newFakeRepository(t)
+
+    // The sender's threads of other channels aren't continued
+    message := &model.SMS{Id: "MESSAGE", From: "ACME", ReceivedAt: time.Now().UTC()}
+    threadTs, err := senderThread(context.Background(), message, "CFINANCE")
+    if err != nil || threadTs != "" {
+        t.Errorf("expected no thread, got: %q, %v", threadTs, err)
+    }
+
+    queries := db.Executed("JOIN OutboxEntries")
+    if len(queries) != 1 || !strings.Contains(queries[0].Query, "o.recipient = ?") || queries[0].Args[2] != "CFINANCE" {
+        t.Errorf("expected the posts to be looked up in the channel, got: %v", queries)
+    }
+}
+
+func messageRow(message model.SMS) []driver.Value {
+    return []driver.Value{message.Id, message.Provider, message.From, message.To, message.Body, message.ReceivedAt, message.SlackChannel, message.SlackTs, message.SlackThreadTs}
+}
+
+func TestDeliverOutboxEntry_RecordsDeliveries(t *testing.T) {
+    tests := []struct {
+        name       string
+        requestIds []string
+        expected   []string
+    }{
+        {"forwarding requests", []string{"REQUEST1", "REQUEST2"}, []string{"REQUEST1", "REQUEST2"}},
+        // e.g. the people on call
+        {"no request", nil, []string{""}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newFakeRepository(t)
+            newFakeSlack(t)
+            db.OnQuery("FROM Messages WHERE id = ?", messageRow(model.SMS{Id: "MESSAGE", From: "33698765432", Body: "Hello", ReceivedAt: time.Now().UTC()}))
+
+            entry := &model.OutboxEntry{MessageId: "MESSAGE", Kind: model.OutboxKindUser, Recipient: "UONCALL", ForwardingRequestIds: test.requestIds}
+            err := deliverOutboxEntry(context.Background(), entry)
+            if err != nil {
+                t.Fatalf("unexpected error: %s", err)
+            }
+
+            var requestIds []string
+            for _, statement := range db.Executed("INSERT INTO Deliveries") {
+                if statement.Args[3] != "UONCALL" {
+                    t.Errorf("expected a delivery to UONCALL, got: %v", statement.Args[3])
+                }
+                requestIds = append(requestIds, statement.Args[2].(string))
+            }
+
+            if !reflect.DeepEqual(requestIds, test.expected) {
+                t.Errorf("invalid deliveries, expected: %v, got: %v", test.expected, requestIds)
+            }
+        })
+    }
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+    "bytes"
     "context"
     "encoding/json"
     "fmt"
@@ -10,20 +11,91 @@ import (
     "github.com/CedricFinance/phone_operator/repository"
     "github.com/slack-go/slack"
     "gopkg.in/yaml.v3"
+    "io"
     "log"
     "net/http"
     "os"
-    "regexp"
-    "strconv"
     "strings"
+    "time"
 )
 
 type Config struct {
     Slack struct {
-        VerificationToken string `yaml:"verification_token"`
-        Token             string
-        Channel           string
-    }
+        SigningSecret string `yaml:"signing_secret"`
+        // VerificationToken is only checked for unsigned requests when
+        // AllowVerificationToken is enabled. Slack deprecated this method.
+        VerificationToken      string `yaml:"verification_token"`
+        AllowVerificationToken bool   `yaml:"allow_verification_token"`
+        Token                  string
+        Channel                string
+        // Admins and the members of AdminGroup can answer forwarding
+        // requests. When both are empty, anyone in Channel can.
+        Admins     []string
+        AdminGroup string `yaml:"admin_group"`
+        // ThreadWindow is the number of minutes during which the texts of a
+        // sender are posted in the thread of their previous text. 0 posts
+        // every text at the top level.
+        ThreadWindow int `yaml:"thread_window"`
+        // ThreadReplies sends the messages written in the thread of a text
+        // back to its sender. The Reply button works without it.
+        ThreadReplies bool `yaml:"thread_replies"`
+    }
+    Twilio struct {
+        AuthToken string `yaml:"auth_token"`
+        // AccountSid enables the replies through Twilio
+        AccountSid string `yaml:"account_sid"`
+    }
+    Nexmo struct {
+        SignatureSecret string `yaml:"signature_secret"`
+        // SignatureMethod is one of md5hash, md5, sha1, sha256 or sha512
+        SignatureMethod string `yaml:"signature_method"`
+        // ApiKey and ApiSecret enable the replies through Vonage
+        ApiKey    string `yaml:"api_key"`
+        ApiSecret string `yaml:"api_secret"`
+    }
+    Forwarding struct {
+        // ExpiryWarning is the number of minutes before the expiration of a
+        // request at which the requester is offered to extend it. 0 disables
+        // the warning.
+        ExpiryWarning int `yaml:"expiry_warning"`
+        // MaxAutoExtension is the longest extension, in minutes, granted
+        // without an admin's approval. It defaults to 60 minutes, a negative
+        // value requires an approval for every extension.
+        MaxAutoExtension int `yaml:"max_auto_extension"`
+        // MinDuration and MaxDuration, in minutes, limit the duration of
+        // requests and extensions. They default to 1 minute and 30 days.
+        MinDuration int `yaml:"min_duration"`
+        MaxDuration int `yaml:"max_duration"`
+    }
+    // Numbers registers the phone numbers having their own channel or
+    // admins. Unregistered numbers use the Slack channel and admins.
+    Numbers  []PhoneNumber
+    Policies []AutoApprovalPolicy
+    // Redaction hides the one-time codes of the texts posted in channels.
+    // The forwarders still receive the full texts.
+    Redaction struct {
+        Enabled bool
+        // AllCodes redacts every 4 to 8 digit number instead of only the
+        // ones of texts mentioning a code.
+        AllCodes bool `yaml:"all_codes"`
+        // Keywords are added to the words announcing a code
+        Keywords []string
+    }
+    OnCall struct {
+        // Calendar is the path or the http(s) URL of an iCalendar feed. The
+        // people on call receive the texts as if they had an active request.
+        Calendar string
+        // Users maps the attendees' emails or names, or the events'
+        // summaries, to Slack user ids. Events whose summary is a Slack user
+        // id don't need a mapping.
+        Users map[string]string
+        // RefreshInterval is the number of minutes a URL feed is cached.
+        // It defaults to 5 minutes.
+        RefreshInterval int `yaml:"refresh_interval"`
+        // Numbers, or their aliases, are the numbers forwarded to the people
+        // on call. It defaults to the numbers answered by the Slack admins.
+        Numbers []string
+    } `yaml:"on_call"`
     Database struct {
         User     string
         Password string
@@ -35,6 +107,8 @@ type Config struct {
 var config Config
 var slackClient *slack.Client
 var repo *repository.Repository
+var outbox *OutboxWorker
+var onCall *OnCallCalendar
 
 func main() {
 
@@ -60,22 +134,48 @@ func main() {
     )
 
     repo = repository.New(db)
+    smsSenders = newSMSSenders()
+
+    if config.OnCall.Calendar != "" {
+        onCall = NewOnCallCalendar(
+            config.OnCall.Calendar,
+            config.OnCall.Users,
+            time.Duration(config.OnCall.RefreshInterval)*time.Minute,
+        )
+    }
+
+    outbox = NewOutboxWorker()
+    go outbox.Run(context.Background())
+    go runExpiryScheduler(context.Background())
+    go runRecurringScheduler(context.Background())
 
-    http.HandleFunc("/slash", slashCommandHandler)
-    http.HandleFunc("/interactivity", interactivityHandler)
+    verificationToken := ""
+    if config.Slack.AllowVerificationToken {
+        verificationToken = config.Slack.VerificationToken
+    }
+    slackVerifier := SlackVerifier(config.Slack.SigningSecret, verificationToken)
+
+    http.HandleFunc("/slash", slackVerifier(slashCommandHandler))
+    http.HandleFunc("/interactivity", slackVerifier(interactivityHandler))
+    http.HandleFunc("/events", slackVerifier(eventsHandler))
 
     http.Handle("/sms", WebhookHandler[model.SMS]{
-        Parser:  ParseTwilioSMS,
-        Handler: handleIncomingSMSContext,
+        Verifier: TwilioSignatureVerifier(config.Twilio.AuthToken),
+        Parser:   ParseTwilioSMS,
+        Handler:  handleIncomingSMSContext,
     })
 
+    nexmoVerifier := NexmoSignatureVerifier(config.Nexmo.SignatureSecret, config.Nexmo.SignatureMethod)
+
     http.Handle("/nexmo/sms", WebhookHandler[model.SMS]{
-        Parser:  ParseNexmoSMS,
-        Handler: handleIncomingSMSContext,
+        Verifier: nexmoVerifier,
+        Parser:   ParseNexmoSMS,
+        Handler:  handleIncomingSMSContext,
     })
     http.Handle("/nexmo/phone", WebhookHandler[model.PhoneCallEvent]{
-        Parser:  ParseNexmoPhoneCallEvent,
-        Handler: handleIncomingPhoneCallEventContext,
+        Verifier: nexmoVerifier,
+        Parser:   ParseNexmoPhoneCallEvent,
+        Handler:  handleIncomingPhoneCallEventContext,
     })
 
     port := os.Getenv("PORT")
@@ -94,11 +194,21 @@ type WebhookData interface {
 }
 
 type WebhookHandler[T WebhookData] struct {
-    Parser  func(r *http.Request) (T, error)
-    Handler func(ctx context.Context, message T) error
+    // Verifier, when set, authenticates the request before it is parsed.
+    Verifier func(r *http.Request) error
+    Parser   func(r *http.Request) (T, error)
+    Handler  func(ctx context.Context, message T) error
 }
 
 func (h WebhookHandler[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if h.Verifier != nil {
+        if err := h.Verifier(r); err != nil {
+            log.Printf("Rejected incoming webhook on %q: %s", r.RequestURI, err)
+            w.WriteHeader(http.StatusForbidden)
+            return
+        }
+    }
+
     message, err := h.Parser(r)
     if err != nil {
         w.WriteHeader(http.StatusInternalServerError)
@@ -109,12 +219,30 @@ func (h WebhookHandler[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
     err = h.Handler(r.Context(), message)
     if err != nil {
+        // The provider will retry the webhook
         fmt.Printf("Failed to handle incoming webhook on %q: %s\n", err, r.RequestURI)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
     }
 
     fmt.Fprintf(w, "")
 }
 
+// readBody returns the request body and rewinds it for the next reader.
+func readBody(r *http.Request) ([]byte, error) {
+    if r.Body == nil {
+        return nil, nil
+    }
+
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read request body: %w", err)
+    }
+    r.Body = io.NopCloser(bytes.NewReader(body))
+
+    return body, nil
+}
+
 func slashCommandHandler(w http.ResponseWriter, r *http.Request) {
     command, err := slack.SlashCommandParse(r)
     if err != nil {
@@ -123,11 +251,6 @@ func slashCommandHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if !command.ValidateToken(config.Slack.VerificationToken) {
-        w.WriteHeader(http.StatusUnauthorized)
-        return
-    }
-
     parts := strings.SplitN(command.Text, " ", 2)
 
     if parts[0] == "" || parts[0] == "help" {
@@ -136,15 +259,7 @@ func slashCommandHandler(w http.ResponseWriter, r *http.Request) {
     }
 
     if parts[0] == "start" {
-        durationInMinutes := 60
-        if len(parts) > 1 {
-            durationInMinutes, err = parseDuration(parts[1])
-            if err != nil {
-                fmt.Fprintf(w, err.Error())
-                return
-            }
-        }
-        startSMSForward(r.Context(), w, command.UserID, command.UserName, durationInMinutes)
+        startCommand(r.Context(), w, command, parts[1:])
         return
     }
 
@@ -153,27 +268,90 @@ func slashCommandHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    if parts[0] == "schedule" {
+        if len(parts) < 2 {
+            fmt.Fprintf(w, "When do you want to receive texts? %s", windowHelp)
+            return
+        }
+        scheduleCommand(r.Context(), w, command, parts[1])
+        return
+    }
+
+    if parts[0] == "rules" {
+        args := ""
+        if len(parts) > 1 {
+            args = parts[1]
+        }
+        rulesCommand(r.Context(), w, command, args)
+        return
+    }
+
+    if parts[0] == "status" {
+        showStatus(r.Context(), w, command.UserID)
+        return
+    }
+
+    if parts[0] == "extend" {
+        extendSMSForward(r.Context(), w, command.UserID, parts[1:])
+        return
+    }
+
     _, err = fmt.Fprint(w, "Hello, World!")
 }
 
-func handleIncomingSMSContext(ctx context.Context, message model.SMS) error {
-    activeRequests, _ := repo.GetActiveForwardingRequests(ctx)
+func handleIncomingSMSContext(ctx context.Context, sms model.SMS) error {
+    message := repository.NewMessage(sms)
+
+    rules, err := repo.GetRoutingRules(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to get routing rules: %w", err)
+    }
+
+    if rule := matchRoutingRule(rules, sms); rule != nil {
+        fmt.Printf("SMS routed by rule %s\n", rule.Id)
+        return saveMessage(ctx, message, routedOutboxEntries(message, rule, numberChannel(sms.To)))
+    }
+
+    activeRequests, err := repo.GetActiveForwardingRequests(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to get active forwarding requests: %w", err)
+    }
+    activeRequests = filterRequestsByNumber(activeRequests, sms.To)
     fmt.Printf("%d active requests", len(activeRequests))
 
     uniqueUsers := uniqueUserIds(activeRequests)
+    for _, userId := range onCallUserIds(ctx, sms.To) {
+        if !contains(uniqueUsers, userId) {
+            uniqueUsers = append(uniqueUsers, userId)
+        }
+    }
+    requestsByUser := groupByUserId(activeRequests)
 
+    var entries []*model.OutboxEntry
     for _, userId := range uniqueUsers {
-        notifyUserBlock(ctx, userId, messages.SmsUserNotifyMessage(message))
+        entry := repository.NewOutboxEntry(message.Id, model.OutboxKindUser, userId)
+        for _, request := range requestsByUser[userId] {
+            entry.ForwardingRequestIds = append(entry.ForwardingRequestIds, request.Id)
+        }
+        entries = append(entries, entry)
     }
 
-    _, _, err := slackClient.PostMessage(
-        config.Slack.Channel,
-        slack.MsgOptionBlocks(messages.SmsChannelNotifyMessage(message, uniqueUsers).Blocks.BlockSet...),
-    )
+    entry := repository.NewOutboxEntry(message.Id, model.OutboxKindChannel, numberChannel(sms.To))
+    entry.UserIds = uniqueUsers
+    entries = append(entries, entry)
+
+    return saveMessage(ctx, message, entries)
+}
+
+// saveMessage stores the SMS with its Slack posts and wakes the outbox up.
+func saveMessage(ctx context.Context, message *model.SMS, entries []*model.OutboxEntry) error {
+    err := repo.SaveMessageWithOutbox(ctx, message, entries)
     if err != nil {
-        return fmt.Errorf("failed to publish SMS to Slack: %w", err)
+        return fmt.Errorf("failed to save SMS: %w", err)
     }
 
+    outbox.Notify()
+
     return nil
 }
 
@@ -193,6 +371,16 @@ func uniqueUserIds(requests []*model.ForwardingRequest) []string {
     return userIds
 }
 
+func groupByUserId(requests []*model.ForwardingRequest) map[string][]*model.ForwardingRequest {
+    requestsByUser := make(map[string][]*model.ForwardingRequest)
+
+    for _, request := range requests {
+        requestsByUser[request.RequesterId] = append(requestsByUser[request.RequesterId], request)
+    }
+
+    return requestsByUser
+}
+
 func handleIncomingPhoneCallEventContext(ctx context.Context, event model.PhoneCallEvent) error {
     if event.Status != "ok" {
         fmt.Printf("Ignoring phone call event with status %q\n", event.Status)
@@ -200,7 +388,7 @@ func handleIncomingPhoneCallEventContext(ctx context.Context, event model.PhoneC
     }
 
     _, _, err := slackClient.PostMessage(
-        config.Slack.Channel,
+        numberChannel(event.To),
         slack.MsgOptionBlocks(messages.PhoneCallChannelNotifyMessage(event).Blocks.BlockSet...),
     )
     if err != nil {
@@ -221,11 +409,6 @@ func interactivityHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if message.Token != config.Slack.VerificationToken {
-        w.WriteHeader(http.StatusUnauthorized)
-        return
-    }
-
     switch message.Type {
     case slack.InteractionTypeBlockActions:
         fmt.Println("block actions")
@@ -241,6 +424,15 @@ func interactivityHandler(w http.ResponseWriter, r *http.Request) {
             handleActionFromCallbackID(message, r, w)
         }
 
+        return
+    case slack.InteractionTypeViewSubmission:
+        switch message.View.CallbackID {
+        case messages.StartRequestCallbackID:
+            handleStartRequestSubmission(message, r, w)
+        case messages.ReplyCallbackID:
+            handleReplySubmission(message, r, w)
+        }
+
         return
     }
 }
@@ -252,15 +444,21 @@ func handleActionFromCallbackID(message slack.InteractionCallback, r *http.Reque
 
         if action == "stop" {
             requestId := message.ActionCallback.BlockActions[0].Value
-            stopRequest(r.Context(), requestId)
+            stopRequest(r.Context(), requestId, message.User.ID)
+            UpdateHome(r.Context(), message.User.ID)
+        }
+
+        if action == "delete_schedule" {
+            scheduleId := message.ActionCallback.BlockActions[0].Value
+            deleteSchedule(r.Context(), scheduleId, message.User.ID)
             UpdateHome(r.Context(), message.User.ID)
         }
 
     }
 }
 
-func stopRequest(ctx context.Context, requestId string) {
-    repo.StopForwardingRequest(ctx, requestId)
+func stopRequest(ctx context.Context, requestId string, stoppedBy string) {
+    repo.StopForwardingRequest(ctx, requestId, stoppedBy)
 }
 
 func handleActionFromBlockId(message slack.InteractionCallback, r *http.Request, w http.ResponseWriter) {
@@ -269,6 +467,14 @@ func handleActionFromBlockId(message slack.InteractionCallback, r *http.Request,
     switch action.BlockID {
     case "forwarding_request":
         handleForwardingRequestActions(message, r, w)
+    case "extend_request":
+        handleExtendRequestActions(message, r, w)
+    case "extension_request":
+        handleExtensionRequestActions(message, r, w)
+    case "recurring_schedule":
+        handleRecurringScheduleActions(message, r, w)
+    case "sms_message":
+        handleSmsMessageActions(message, r, w)
     }
 }
 
@@ -276,16 +482,72 @@ func handleForwardingRequestActions(message slack.InteractionCallback, r *http.R
     action := message.ActionCallback.BlockActions[0].ActionID
     requestId := message.ActionCallback.BlockActions[0].Value
 
-    if action == "accept" {
+    request, err := repo.GetForwardingRequest(r.Context(), requestId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    allowed, reason, err := canAnswer(r.Context(), message.User.ID, action, request.RequesterId, request.Number)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+    if !allowed {
+        rejectAction(message, reason)
+        return
+    }
+
+    switch action {
+    case "accept":
         acceptForwardingRequest(r.Context(), message, requestId)
-    } else {
+    case "refuse":
         refuseForwardingRequest(r.Context(), message, requestId)
+    case "revoke":
+        revokeForwardingRequest(r.Context(), message, requestId)
+    }
+}
+
+func revokeForwardingRequest(ctx context.Context, message slack.InteractionCallback, requestId string) {
+    err := repo.StopForwardingRequest(ctx, requestId, message.User.ID)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        rejectAction(message, fmt.Sprintf("Oops. Something went wrong :sad:. Error: %s", err))
+        return
     }
 
+    request, err := repo.GetForwardingRequest(ctx, requestId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        rejectAction(message, fmt.Sprintf("Oops. Something went wrong :sad:. Error: %s", err))
+        return
+    }
+    // The request may have expired or been stopped in the meantime
+    if request.IsRevoked() && request.StoppedBy == message.User.ID {
+        notifyUser(
+            ctx,
+            request.RequesterId,
+            fmt.Sprintf("Your forwarding request has been revoked by <@%s>, you won't receive texts anymore.", message.User.ID),
+        )
+    }
+    slackClient.PostMessage(
+        message.Channel.GroupConversation.Conversation.ID,
+        slack.MsgOptionBlocks(messages.AcceptRefuseRequestMessage(request).Blocks.BlockSet...),
+        slack.MsgOptionReplaceOriginal(message.ResponseURL),
+    )
+    err = UpdateHome(ctx, request.RequesterId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+    }
 }
 
 func refuseForwardingRequest(ctx context.Context, message slack.InteractionCallback, requestId string) {
-    repo.RefuseForwardingRequest(ctx, requestId, message.User.ID)
+    err := repo.RefuseForwardingRequest(ctx, requestId, message.User.ID)
+    if err != nil {
+        rejectAnswer(message, err)
+        return
+    }
+
     request, _ := repo.GetForwardingRequest(ctx, requestId)
     notifyUser(
         ctx,
@@ -297,14 +559,19 @@ func refuseForwardingRequest(ctx context.Context, message slack.InteractionCallb
         slack.MsgOptionBlocks(messages.AcceptRefuseRequestMessage(request).Blocks.BlockSet...),
         slack.MsgOptionReplaceOriginal(message.ResponseURL),
     )
-    err := UpdateHome(ctx, request.RequesterId)
+    err = UpdateHome(ctx, request.RequesterId)
     if err != nil {
         fmt.Printf("Error: %v", err)
     }
 }
 
 func acceptForwardingRequest(ctx context.Context, message slack.InteractionCallback, requestId string) {
-    repo.AcceptForwardingRequest(ctx, requestId, message.User.ID)
+    err := repo.AcceptForwardingRequest(ctx, requestId, message.User.ID)
+    if err != nil {
+        rejectAnswer(message, err)
+        return
+    }
+
     request, _ := repo.GetForwardingRequest(ctx, requestId)
     notifyUserBlock(ctx, request.RequesterId, messages.AcceptedRequestMessage(request))
     slackClient.PostMessage(
@@ -312,12 +579,23 @@ func acceptForwardingRequest(ctx context.Context, message slack.InteractionCallb
         slack.MsgOptionBlocks(messages.AcceptRefuseRequestMessage(request).Blocks.BlockSet...),
         slack.MsgOptionReplaceOriginal(message.ResponseURL),
     )
-    err := UpdateHome(ctx, request.RequesterId)
+    err = UpdateHome(ctx, request.RequesterId)
     if err != nil {
         fmt.Printf("Error: %v", err)
     }
 }
 
+// rejectAnswer tells the admin why their answer to a request was not recorded.
+func rejectAnswer(message slack.InteractionCallback, err error) {
+    if err == repository.AlreadyAnswered {
+        rejectAction(message, "This request has already been answered or cancelled.")
+        return
+    }
+
+    fmt.Printf("Error: %v", err)
+    rejectAction(message, fmt.Sprintf("Oops. Something went wrong :sad:. Error: %s", err))
+}
+
 func notifyUser(ctx context.Context, slackId string, message string) error {
     c, _, _, err := slackClient.OpenConversationContext(ctx, &slack.OpenConversationParameters{
         ReturnIM: true,
@@ -347,10 +625,13 @@ func notifyUserBlock(ctx context.Context, slackId string, message slack.Message)
         return err
     }
 
-    _, _, _, err = slackClient.SendMessage(
-        c.ID,
-        slack.MsgOptionBlocks(message.Blocks.BlockSet...),
-    )
+    options := []slack.MsgOption{slack.MsgOptionBlocks(message.Blocks.BlockSet...)}
+    if message.Text != "" {
+        // Shown in the notifications
+        options = append(options, slack.MsgOptionText(message.Text, false))
+    }
+
+    _, _, _, err = slackClient.SendMessage(c.ID, options...)
     if err != nil {
         return err
     }
@@ -358,40 +639,193 @@ func notifyUserBlock(ctx context.Context, slackId string, message slack.Message)
     return nil
 }
 
-func startSMSForward(context context.Context, w http.ResponseWriter, userId string, userName string, duration int) {
-    request := repository.NewForwardingRequest(userId, userName, duration)
-    err := repo.SaveForwardingRequest(context, request)
+// startCommand handles `/sms start [number] [duration]`. When several numbers
+// are registered and none is given, the requester picks one in a modal.
+func startCommand(ctx context.Context, w http.ResponseWriter, command slack.SlashCommand, args []string) {
+    number := ""
+    if len(args) > 0 {
+        words := strings.SplitN(args[0], " ", 2)
+        if phoneNumber := lookupNumber(words[0]); phoneNumber != nil {
+            number = phoneNumber.Number
+            args = words[1:]
+        }
+    }
+
+    if number == "" && len(config.Numbers) > 1 {
+        duration := ""
+        if len(args) > 0 {
+            duration = args[0]
+        }
+
+        _, err := slackClient.OpenViewContext(ctx, command.TriggerID, messages.StartRequestModal(numberOptions(), duration))
+        if err != nil {
+            fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        }
+        return
+    }
+
+    if number == "" && len(config.Numbers) == 1 {
+        number = config.Numbers[0].Number
+    }
+
+    duration, err := parseCommandDuration(ctx, command.UserID, args)
+    if err != nil {
+        fmt.Fprintf(w, err.Error())
+        return
+    }
+
+    startSMSForward(ctx, w, command.UserID, command.UserName, number, duration)
+}
+
+func startSMSForward(context context.Context, w http.ResponseWriter, userId string, userName string, number string, duration time.Duration) {
+    request := repository.NewForwardingRequest(userId, userName, int(duration/time.Minute))
+    request.Number = number
+    submitForwardingRequest(context, w, request)
+}
+
+// handleStartRequestSubmission creates the request chosen in the start
+// modal.
+func handleStartRequestSubmission(message slack.InteractionCallback, r *http.Request, w http.ResponseWriter) {
+    ctx := r.Context()
+    values := message.View.State.Values
+    number := values[messages.StartNumberBlockID][messages.StartNumberActionID].SelectedOption.Value
+
+    var args []string
+    if duration := strings.TrimSpace(values[messages.StartDurationBlockID][messages.StartDurationActionID].Value); duration != "" {
+        args = append(args, duration)
+    }
+
+    duration, err := parseCommandDuration(ctx, message.User.ID, args)
+    if err != nil {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(slack.NewErrorsViewSubmissionResponse(map[string]string{
+            messages.StartDurationBlockID: err.Error(),
+        }))
+        return
+    }
+
+    request := repository.NewForwardingRequest(message.User.ID, message.User.Name, int(duration/time.Minute))
+    request.Number = number
+
+    request, err = createForwardingRequest(ctx, request)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        notifyUser(ctx, message.User.ID, fmt.Sprintf("Oops. Something went wrong :sad:. Error: %s", err))
+        return
+    }
+
+    if request.AcceptedAt != nil {
+        notifyUser(ctx, message.User.ID, messages.AcceptedRequestText(request))
+        return
+    }
+
+    notifyUser(ctx, message.User.ID, "I have forwarded your request to the admins")
+}
+
+func scheduleSMSForward(ctx context.Context, w http.ResponseWriter, userId string, userName string, number string, window string) {
+    startsAt, duration, err := parseWindow(window, time.Now(), userLocation(ctx, userId))
+    if err != nil {
+        fmt.Fprintf(w, err.Error())
+        return
+    }
+
+    err = validateDuration(duration)
+    if err != nil {
+        fmt.Fprintf(w, err.Error())
+        return
+    }
+
+    request := repository.NewScheduledForwardingRequest(userId, userName, startsAt, int(duration/time.Minute))
+    request.Number = number
+    submitForwardingRequest(ctx, w, request)
+}
+
+// submitForwardingRequest saves a new request and asks the admins to answer
+// it, unless an auto-approval policy accepts it.
+func submitForwardingRequest(context context.Context, w http.ResponseWriter, request *model.ForwardingRequest) {
+    request, err := createForwardingRequest(context, request)
     if err != nil {
         fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
         return
     }
 
-    _, _, err = slackClient.PostMessage(
-        config.Slack.Channel,
+    if request.AcceptedAt != nil {
+        fmt.Fprint(w, messages.AcceptedRequestText(request))
+        return
+    }
+
+    fmt.Fprintf(w, "I have forwarded your request to the admins")
+}
+
+// createForwardingRequest saves the request, applies the auto-approval
+// policies to pending requests and posts the request to the admin channel.
+func createForwardingRequest(context context.Context, request *model.ForwardingRequest) (*model.ForwardingRequest, error) {
+    err := repo.SaveForwardingRequest(context, request)
+    if err != nil {
+        return nil, err
+    }
+
+    if request.AcceptedAt == nil {
+        policy, err := matchingPolicy(context, request)
+        if err != nil {
+            fmt.Printf("Failed to evaluate auto-approval policies: %v\n", err)
+        }
+
+        if policy != nil {
+            err = repo.AcceptForwardingRequest(context, request.Id, model.PolicyAnswer(policy.Name))
+            if err != nil {
+                return nil, err
+            }
+
+            request, err = repo.GetForwardingRequest(context, request.Id)
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    err = postApprovalMessage(context, request)
+    if err != nil {
+        return nil, err
+    }
+
+    return request, nil
+}
+
+// postApprovalMessage posts the request to the admins of its number and
+// refreshes the home of the requester.
+func postApprovalMessage(context context.Context, request *model.ForwardingRequest) error {
+    channel, ts, err := slackClient.PostMessage(
+        numberChannel(request.Number),
         slack.MsgOptionBlocks(messages.AcceptRefuseRequestMessage(request).Blocks.BlockSet...),
     )
     if err != nil {
-        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
-        return
+        return err
     }
 
-    err = UpdateHome(context, userId)
+    err = repo.SetForwardingRequestApprovalMessage(context, request.Id, channel, ts)
     if err != nil {
         fmt.Printf("Error: %v", err)
     }
 
-    fmt.Fprintf(w, "I have forwarded your request to the admins")
+    err = UpdateHome(context, request.RequesterId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+    }
+
+    return nil
 }
 
 func UpdateHome(context context.Context, userId string) error {
     requests, _ := repo.GetForwardingRequests(context, userId)
+    schedules, _ := repo.GetRecurringSchedules(context, userId)
 
     _, err := slackClient.PublishViewContext(
         context,
         userId,
         slack.HomeTabViewRequest{
             Type:       slack.VTHomeTab,
-            Blocks:     messages.HomeMessage(requests).Blocks,
+            Blocks:     messages.HomeMessage(requests, schedules).Blocks,
             CallbackID: "home",
         },
         "",
@@ -399,13 +833,27 @@ func UpdateHome(context context.Context, userId string) error {
     return err
 }
 
+func showStatus(ctx context.Context, w http.ResponseWriter, requesterId string) {
+    requests, err := repo.GetForwardingRequests(ctx, requesterId)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(slack.Msg{
+        ResponseType: slack.ResponseTypeEphemeral,
+        Blocks:       messages.StatusMessage(requests).Blocks,
+    })
+}
+
 func stopSMSForward(ctx context.Context, w http.ResponseWriter, requesterId string) {
     requests, _ := repo.GetForwardingRequests(ctx, requesterId)
 
     stopped := 0
     for _, request := range requests {
-        if request.IsActive() {
-            stopRequest(ctx, request.Id)
+        if request.IsCancelable() {
+            stopRequest(ctx, request.Id, requesterId)
             stopped++
         }
     }
@@ -415,44 +863,6 @@ func stopSMSForward(ctx context.Context, w http.ResponseWriter, requesterId stri
     fmt.Fprintf(w, "I stopped %d forwarding request(s)", stopped)
 }
 
-func parseDuration(durationStr string) (int, error) {
-    pattern := regexp.MustCompile("([0-9]+)\\s*([a-zA-Z]*)")
-    result := pattern.FindStringSubmatch(durationStr)
-    fmt.Printf("%v", result)
-
-    if len(result) == 0 {
-        return 0, fmt.Errorf("I don't understand the duration you want. Please enter a number followed by a unit:\n- `m`, `min`, `minute`, `minutes` for minutes\n- `h`, `hour`, `hours` for hours\n- `d`, `day`, `days` for days\n\nNote: you can omit the unit for minutes")
-    }
-
-    var err error
-
-    duration64, err := strconv.ParseInt(result[1], 10, 32)
-    duration := int(duration64)
-    if err != nil {
-        return 0, fmt.Errorf("I don't understand the duration you want. %q is not a number.", result[1])
-    }
-
-    minutesPerUnit, err := getMinutesPerUnit(result[2])
-    if err != nil {
-        return 0, fmt.Errorf("I don't understand the duration you want. %q is not a valid unit. Please use:\n- `m`, `min`, `minute`, `minutes` for minutes\n- `h`, `hour`, `hours` for hours\n- `d`, `day`, `days` for days\n", result[2])
-    }
-
-    return duration * minutesPerUnit, nil
-}
-
-func getMinutesPerUnit(unit string) (int, error) {
-    switch unit {
-    case "", "m", "min", "minute", "minutes":
-        return 1, nil
-    case "h", "hour", "hours":
-        return 60, nil
-    case "d", "day", "days":
-        return 24 * 60, nil
-    }
-
-    return 0, fmt.Errorf("%q is not a valid unit", unit)
-}
-
 func showHelp(w http.ResponseWriter) {
-    fmt.Fprintf(w, "Available commands:\n`/sms help` - display this help message\n`/sms start [duration]` - ask to start texts forwarding for [duration] (default duration is 1h)\n`/sms stop` - stop texts forwarding")
+    fmt.Fprintf(w, "Available commands:\n`/sms help` - display this help message\n`/sms start [number] [duration]` - ask to start texts forwarding of [number], a phone number or its alias, for [duration], e.g. `1h30m` or `until 18:00` (default duration is 1h)\n`/sms schedule [number] [window]` - ask to receive texts during a future window, e.g. `tomorrow 14:00-16:00`\n`/sms schedule [number] every [days] [window]` - ask to receive texts regularly, e.g. `every weekdays 19:00-08:00`\n`/sms schedule list` - list your recurring schedules\n`/sms schedule delete [id]` - delete one of your recurring schedules\n`/sms rules` - list the routing rules, admins can `add` and `delete` them\n`/sms stop` - stop texts forwarding and cancel your pending requests\n`/sms status` - show your active, scheduled and pending texts forwarding requests\n`/sms extend [number] [duration]` - extend your active texts forwarding of [number] by [duration] (default duration is 1h)")
 }
@@ -2,11 +2,18 @@ package main
 
 import (
     "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/messages"
     "github.com/CedricFinance/phone_operator/model"
+    "github.com/slack-go/slack"
     "io"
     "net/http"
     "net/http/httptest"
+    "strings"
     "testing"
+    "time"
 )
 
 func parseSMS(_ *http.Request) (model.SMS, error) {
@@ -48,3 +55,391 @@ func TestSMSHandler_ServeHTTP(t *testing.T) {
     }
 
 }
+
+func TestSMSHandler_ServeHTTP_RejectedByVerifier(t *testing.T) {
+    var handler = WebhookHandler[model.SMS]{
+        Verifier: func(r *http.Request) error {
+            return errors.New("invalid signature")
+        },
+        Parser: parseSMS,
+        Handler: func(ctx context.Context, message model.SMS) error {
+            t.Errorf("handler should not be called when verification fails")
+            return nil
+        },
+    }
+
+    r, _ := http.NewRequest("POST", "http://localhost", nil)
+    w := httptest.NewRecorder()
+
+    handler.ServeHTTP(w, r)
+
+    res := w.Result()
+    if res.StatusCode != http.StatusForbidden {
+        t.Errorf("Expected HTTP Code 403, got: %d", res.StatusCode)
+    }
+}
+
+func TestGroupByUserId(t *testing.T) {
+    requests := []*model.ForwardingRequest{
+        {Id: "1", RequesterId: "U1"},
+        {Id: "2", RequesterId: "U2"},
+        {Id: "3", RequesterId: "U1"},
+    }
+
+    requestsByUser := groupByUserId(requests)
+
+    if len(requestsByUser) != 2 {
+        t.Errorf("Expected 2 users, got: %d", len(requestsByUser))
+    }
+
+    if len(requestsByUser["U1"]) != 2 || requestsByUser["U1"][0].Id != "1" || requestsByUser["U1"][1].Id != "3" {
+        t.Errorf("Expected requests 1 and 3 for U1, got: %+v", requestsByUser["U1"])
+    }
+
+    if len(requestsByUser["U2"]) != 1 || requestsByUser["U2"][0].Id != "2" {
+        t.Errorf("Expected request 2 for U2, got: %+v", requestsByUser["U2"])
+    }
+}
+
+func TestSMSHandler_ServeHTTP_HandlerError(t *testing.T) {
+    var handler = WebhookHandler[model.SMS]{
+        Parser: parseSMS,
+        Handler: func(ctx context.Context, message model.SMS) error {
+            return errors.New("database unavailable")
+        },
+    }
+
+    r, _ := http.NewRequest("POST", "http://localhost", nil)
+    w := httptest.NewRecorder()
+
+    handler.ServeHTTP(w, r)
+
+    res := w.Result()
+    if res.StatusCode != http.StatusInternalServerError {
+        t.Errorf("Expected HTTP Code 500 so that the provider retries, got: %d", res.StatusCode)
+    }
+}
+
+func TestRevokeForwardingRequest(t *testing.T) {
+    db := newFakeRepository(t)
+    fake := newFakeSlack(t)
+
+    request := activeRequest()
+    stoppedAt := time.Now().UTC()
+    request.ExpiresAt = &stoppedAt
+    request.StoppedAt = &stoppedAt
+    request.StoppedBy = "UADMIN"
+    db.OnQuery("FROM ForwardingRequests WHERE id = ?", requestRow(request))
+
+    message := slack.InteractionCallback{User: slack.User{ID: "UADMIN"}, ResponseURL: fake.URL + "/response"}
+    revokeForwardingRequest(context.Background(), message, request.Id)
+
+    stopped := db.Executed("UPDATE ForwardingRequests SET expires_at = ?, stopped_at = ?, stopped_by = ?")
+    if len(stopped) != 1 || stopped[0].Args[2] != "UADMIN" {
+        t.Errorf("expected the request to be stopped by UADMIN, got: %v", stopped)
+    }
+
+    if len(fake.Calls("conversations.open")) != 1 {
+        t.Errorf("expected the requester to be notified of the revocation")
+    }
+
+    if len(fake.Calls("response")) != 1 || len(fake.Calls("views.publish")) != 1 {
+        t.Errorf("expected the approval message and the home of the requester to be updated")
+    }
+}
+
+func TestRevokeForwardingRequest_Errors(t *testing.T) {
+    tests := map[string]func(db *fakeDB){
+        "unknown request": func(db *fakeDB) {},
+        "stop failure": func(db *fakeDB) {
+            db.OnExec("UPDATE ForwardingRequests SET expires_at", 0, errors.New("database unavailable"))
+            db.OnQuery("FROM ForwardingRequests WHERE id = ?", requestRow(activeRequest()))
+        },
+    }
+
+    for name, setup := range tests {
+        t.Run(name, func(t *testing.T) {
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+            setup(db)
+
+            message := slack.InteractionCallback{User: slack.User{ID: "UADMIN"}, ResponseURL: fake.URL + "/response"}
+            revokeForwardingRequest(context.Background(), message, "REQUEST")
+
+            if len(fake.Calls("conversations.open")) != 0 {
+                t.Errorf("expected the requester not to be notified")
+            }
+
+            responses := fake.Calls("response")
+            if len(responses) != 1 || !strings.Contains(responses[0].Body, "Something went wrong") {
+                t.Errorf("expected the error to be shown to the admin, got: %v", responses)
+            }
+        })
+    }
+}
+
+func TestScheduleSMSForward(t *testing.T) {
+    db := newFakeRepository(t)
+    newFakeSlack(t)
+
+    w := httptest.NewRecorder()
+    scheduleSMSForward(context.Background(), w, "UREQUESTER", "requester", "", "tomorrow 14:00-16:00")
+
+    inserted := db.Executed("INSERT INTO ForwardingRequests")
+    if len(inserted) != 1 {
+        t.Fatalf("expected a request to be saved, got: %d (%q)", len(inserted), w.Body.String())
+    }
+
+    // The window is understood in the timezone of the requester
+    location, _ := time.LoadLocation("Europe/Paris")
+    tomorrow := time.Now().In(location).AddDate(0, 0, 1)
+    expected := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 14, 0, 0, 0, location)
+
+    startsAt, ok := inserted[0].Args[9].(time.Time)
+    if !ok || !startsAt.Equal(expected) {
+        t.Fatalf("expected the request to start at %s, got: %v", expected, inserted[0].Args[9])
+    }
+    if inserted[0].Args[3] != int64(120) {
+        t.Errorf("expected the request to last 120 minutes, got: %v", inserted[0].Args[3])
+    }
+
+    // Once accepted, the request only forwards the texts from its start
+    acceptedAt := time.Now().UTC()
+    expiresAt := startsAt.Add(2 * time.Hour)
+    request := &model.ForwardingRequest{Id: "REQUEST", RequesterId: "UREQUESTER", Duration: 120, CreatedAt: acceptedAt, AcceptedAt: &acceptedAt, StartsAt: &startsAt, ExpiresAt: &expiresAt}
+    if request.IsActive() || !request.IsScheduled() {
+        t.Errorf("expected the request to be scheduled, got active: %t, scheduled: %t", request.IsActive(), request.IsScheduled())
+    }
+
+    home, err := json.Marshal(messages.HomeMessage([]*model.ForwardingRequest{request}, nil).Blocks)
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    scheduled := strings.Index(string(home), "*Your scheduled requests*")
+    window := strings.Index(string(home), fmt.Sprintf("from \\u003c!date^%d^", startsAt.Unix()))
+    if scheduled < 0 || window < scheduled {
+        t.Errorf("expected the home to list the request as scheduled, got: %s", home)
+    }
+}
+
+func TestAnswerForwardingRequest_AlreadyAnswered(t *testing.T) {
+    tests := []struct {
+        action    string
+        statement string
+        answer    func(ctx context.Context, message slack.InteractionCallback, requestId string)
+    }{
+        {"accept", "UPDATE ForwardingRequests SET accepted_at", acceptForwardingRequest},
+        {"refuse", "UPDATE ForwardingRequests SET refused_at", refuseForwardingRequest},
+    }
+
+    for _, test := range tests {
+        t.Run(test.action, func(t *testing.T) {
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+
+            // Another admin answered first
+            db.OnExec(test.statement, 0, nil)
+            db.OnQuery("FROM ForwardingRequests WHERE id = ?", requestRow(activeRequest()))
+
+            message := slack.InteractionCallback{User: slack.User{ID: "UADMIN"}, ResponseURL: fake.URL + "/response"}
+            test.answer(context.Background(), message, "REQUEST")
+
+            if len(fake.Calls("conversations.open")) != 0 {
+                t.Errorf("expected the requester not to be notified")
+            }
+
+            responses := fake.Calls("response")
+            if len(responses) != 1 || !strings.Contains(responses[0].Body, "already been answered") {
+                t.Errorf("expected the admin to be told the request was already answered, got: %v", responses)
+            }
+        })
+    }
+}
+
+func TestStopSMSForward(t *testing.T) {
+    db := newFakeRepository(t)
+    newFakeSlack(t)
+
+    now := time.Now().UTC()
+    startsAt := now.Add(24 * time.Hour)
+    expiresAt := startsAt.Add(time.Hour)
+
+    active := activeRequest()
+    scheduled := model.ForwardingRequest{Id: "SCHEDULED", RequesterId: "UREQUESTER", Duration: 60, CreatedAt: now, AcceptedAt: &now, StartsAt: &startsAt, ExpiresAt: &expiresAt}
+    pending := model.ForwardingRequest{Id: "PENDING", RequesterId: "UREQUESTER", Duration: 60, CreatedAt: now}
+    refused := model.ForwardingRequest{Id: "REFUSED", RequesterId: "UREQUESTER", Duration: 60, CreatedAt: now, RefusedAt: &now}
+    db.OnQuery("WHERE requester_id = ?", requestRow(active), requestRow(scheduled), requestRow(pending), requestRow(refused))
+
+    w := httptest.NewRecorder()
+    stopSMSForward(context.Background(), w, "UREQUESTER")
+
+    var stopped []string
+    for _, statement := range db.Executed("UPDATE ForwardingRequests SET expires_at = ?, stopped_at = ?") {
+        stopped = append(stopped, statement.Args[3].(string))
+    }
+
+    if strings.Join(stopped, ",") != "REQUEST,SCHEDULED,PENDING" {
+        t.Errorf("expected the active, scheduled and pending requests to be stopped, got: %v", stopped)
+    }
+
+    if w.Body.String() != "I stopped 3 forwarding request(s)" {
+        t.Errorf("invalid response: %q", w.Body.String())
+    }
+}
+
+func TestStartCommand(t *testing.T) {
+    tests := []struct {
+        name     string
+        numbers  []PhoneNumber
+        args     []string
+        number   string
+        duration int64
+        modal    string
+    }{
+        {"alias and duration", twoNumbers(), []string{"bank-line 2h"}, "+33612345678", 120, ""},
+        {"number without duration", twoNumbers(), []string{"+33698765432"}, "+33698765432", 60, ""},
+        {"number picked in a modal", twoNumbers(), []string{"30m"}, "", 0, "30m"},
+        {"single number", []PhoneNumber{{Number: "+33612345678"}}, []string{"30m"}, "+33612345678", 30, ""},
+        {"no registered number", nil, []string{"1h30m"}, "", 90, ""},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            config.Numbers = test.numbers
+            defer func() { config.Numbers = nil }()
+
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+
+            w := httptest.NewRecorder()
+            startCommand(context.Background(), w, slack.SlashCommand{UserID: "UREQUESTER", UserName: "requester", TriggerID: "TRIGGER"}, test.args)
+
+            inserted := db.Executed("INSERT INTO ForwardingRequests")
+            views := fake.Calls("views.open")
+
+            if test.modal != "" {
+                if len(inserted) != 0 {
+                    t.Errorf("expected no request before the number is picked, got: %d", len(inserted))
+                }
+                if len(views) != 1 || !strings.Contains(views[0].Body, `"initial_value":"`+test.modal+`"`) {
+                    t.Errorf("expected the modal to be prefilled with %q, got: %v", test.modal, views)
+                }
+                return
+            }
+
+            if len(views) != 0 {
+                t.Errorf("expected no modal, got: %v", views)
+            }
+            if len(inserted) != 1 {
+                t.Fatalf("expected one request, got: %d: %s", len(inserted), w.Body.String())
+            }
+            if inserted[0].Args[3] != test.duration {
+                t.Errorf("invalid duration, expected: %d, got: %v", test.duration, inserted[0].Args[3])
+            }
+            if inserted[0].Args[11] != test.number {
+                t.Errorf("invalid number, expected: %q, got: %v", test.number, inserted[0].Args[11])
+            }
+            if w.Body.String() != "I have forwarded your request to the admins" {
+                t.Errorf("invalid response: %q", w.Body.String())
+            }
+        })
+    }
+}
+
+func TestStartCommand_InvalidDuration(t *testing.T) {
+    config.Numbers = twoNumbers()
+    defer func() { config.Numbers = nil }()
+
+    db := newFakeRepository(t)
+    newFakeSlack(t)
+
+    w := httptest.NewRecorder()
+    startCommand(context.Background(), w, slack.SlashCommand{UserID: "UREQUESTER"}, []string{"bank-line forever"})
+
+    if len(db.Executed("INSERT INTO ForwardingRequests")) != 0 {
+        t.Errorf("expected no request for an invalid duration")
+    }
+    if !strings.HasPrefix(w.Body.String(), "I don't understand the duration you want.") {
+        t.Errorf("expected the invalid duration to be reported, got: %q", w.Body.String())
+    }
+}
+
+func twoNumbers() []PhoneNumber {
+    return []PhoneNumber{
+        {Number: "+33612345678", Alias: "bank-line"},
+        {Number: "+33698765432"},
+    }
+}
+
+func startSubmission(number string, duration string) slack.InteractionCallback {
+    message := slack.InteractionCallback{User: slack.User{ID: "UREQUESTER", Name: "requester"}}
+    message.View.State = &slack.ViewState{Values: map[string]map[string]slack.BlockAction{
+        messages.StartNumberBlockID:   {messages.StartNumberActionID: {SelectedOption: slack.OptionBlockObject{Value: number}}},
+        messages.StartDurationBlockID: {messages.StartDurationActionID: {Value: duration}},
+    }}
+
+    return message
+}
+
+func TestHandleStartRequestSubmission(t *testing.T) {
+    config.Numbers = twoNumbers()
+    defer func() { config.Numbers = nil }()
+
+    tests := []struct {
+        duration string
+        expected int64
+    }{
+        {"2h", 120},
+        // The duration is optional
+        {" ", 60},
+    }
+
+    for _, test := range tests {
+        db := newFakeRepository(t)
+        fake := newFakeSlack(t)
+
+        w := httptest.NewRecorder()
+        handleStartRequestSubmission(startSubmission("+33698765432", test.duration), httptest.NewRequest("POST", "/interactive", nil), w)
+
+        if w.Body.Len() != 0 {
+            t.Errorf("expected the modal to be closed, got: %q", w.Body.String())
+        }
+
+        inserted := db.Executed("INSERT INTO ForwardingRequests")
+        if len(inserted) != 1 {
+            t.Fatalf("expected one request, got: %d", len(inserted))
+        }
+        if inserted[0].Args[3] != test.expected || inserted[0].Args[11] != "+33698765432" {
+            t.Errorf("invalid request, expected %d minute(s) on +33698765432, got: %v", test.expected, inserted[0].Args)
+        }
+
+        // The requester is told in a direct message
+        posts := fake.Calls("chat.postMessage")
+        if len(posts) != 2 || posts[1].Values.Get("channel") != "DFAKE" || !strings.Contains(posts[1].Values.Get("text"), "forwarded your request") {
+            t.Errorf("expected the requester to be notified, got: %v", posts)
+        }
+    }
+}
+
+func TestHandleStartRequestSubmission_InvalidDuration(t *testing.T) {
+    db := newFakeRepository(t)
+    newFakeSlack(t)
+
+    w := httptest.NewRecorder()
+    handleStartRequestSubmission(startSubmission("+33698765432", "forever"), httptest.NewRequest("POST", "/interactive", nil), w)
+
+    if len(db.Executed("INSERT INTO ForwardingRequests")) != 0 {
+        t.Errorf("expected no request for an invalid duration")
+    }
+
+    var response slack.ViewSubmissionResponse
+    err := json.Unmarshal(w.Body.Bytes(), &response)
+    if err != nil {
+        t.Fatalf("invalid response %q: %s", w.Body.String(), err)
+    }
+
+    if response.ResponseAction != slack.RAErrors || response.Errors[messages.StartDurationBlockID] == "" {
+        t.Errorf("expected the duration input to show the error, got: %+v", response)
+    }
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/model"
+    "time"
+)
+
+// AutoApprovalPolicy accepts forwarding requests without waiting for an
+// admin. Every non-empty criterion must match for the policy to apply.
+type AutoApprovalPolicy struct {
+    Name string
+    // Users and Groups (Slack user group ids) the policy applies to. A policy
+    // without users nor groups applies to everyone.
+    Users  []string
+    Groups []string
+    // MaxDuration is the longest duration, in minutes, accepted by the policy
+    MaxDuration int `yaml:"max_duration"`
+    // From and To ("15:04") restrict the time of the day the requests are
+    // accepted, in Timezone (UTC by default). The window may span midnight.
+    From     string
+    To       string
+    Timezone string
+    // Numbers, or their aliases, the policy applies to. A policy without
+    // numbers only applies to the numbers answered by the Slack admins, the
+    // admins of the other numbers answer their requests themselves.
+    Numbers []string
+}
+
+// matches tells whether the policy accepts the request. groupMembers returns
+// the members of a Slack user group.
+func (p AutoApprovalPolicy) matches(request *model.ForwardingRequest, now time.Time, groupMembers func(group string) ([]string, error)) (bool, error) {
+    if p.MaxDuration > 0 && request.Duration > p.MaxDuration {
+        return false, nil
+    }
+
+    if !p.coversNumber(request.Number) {
+        return false, nil
+    }
+
+    inWindow, err := p.inTimeWindow(now)
+    if err != nil || !inWindow {
+        return false, err
+    }
+
+    if len(p.Users) == 0 && len(p.Groups) == 0 {
+        return true, nil
+    }
+
+    if contains(p.Users, request.RequesterId) {
+        return true, nil
+    }
+
+    for _, group := range p.Groups {
+        members, err := groupMembers(group)
+        if err != nil {
+            return false, err
+        }
+
+        if contains(members, request.RequesterId) {
+            return true, nil
+        }
+    }
+
+    return false, nil
+}
+
+// coversNumber tells whether the policy applies to the requests of the number.
+// Requests without a number forward every number, so only the policies
+// without numbers apply to them, and only when no number has its own admins.
+func (p AutoApprovalPolicy) coversNumber(number string) bool {
+    if len(p.Numbers) == 0 {
+        if number != "" {
+            return !hasOwnAdmins(number)
+        }
+
+        for _, phoneNumber := range config.Numbers {
+            if hasOwnAdmins(phoneNumber.Number) {
+                return false
+            }
+        }
+        return true
+    }
+
+    if number == "" {
+        return false
+    }
+
+    for _, name := range p.Numbers {
+        if phoneNumber := lookupNumber(name); phoneNumber != nil {
+            name = phoneNumber.Number
+        }
+
+        if normalizeNumber(name) == normalizeNumber(number) {
+            return true
+        }
+    }
+
+    return false
+}
+
+func (p AutoApprovalPolicy) inTimeWindow(now time.Time) (bool, error) {
+    if p.From == "" && p.To == "" {
+        return true, nil
+    }
+
+    location, err := time.LoadLocation(p.Timezone)
+    if err != nil {
+        return false, fmt.Errorf("invalid timezone for policy %q: %w", p.Name, err)
+    }
+
+    from, err := minutesOfDay(p.From, 0)
+    if err != nil {
+        return false, fmt.Errorf("invalid start time for policy %q: %w", p.Name, err)
+    }
+
+    to, err := minutesOfDay(p.To, 24*60)
+    if err != nil {
+        return false, fmt.Errorf("invalid end time for policy %q: %w", p.Name, err)
+    }
+
+    local := now.In(location)
+    current := local.Hour()*60 + local.Minute()
+
+    if from <= to {
+        return current >= from && current < to, nil
+    }
+
+    return current >= from || current < to, nil
+}
+
+func minutesOfDay(value string, defaultValue int) (int, error) {
+    if value == "" {
+        return defaultValue, nil
+    }
+
+    t, err := time.Parse("15:04", value)
+    if err != nil {
+        return 0, err
+    }
+
+    return t.Hour()*60 + t.Minute(), nil
+}
+
+func contains(values []string, value string) bool {
+    for _, v := range values {
+        if v == value {
+            return true
+        }
+    }
+
+    return false
+}
+
+// matchingPolicy returns the first configured policy accepting the request,
+// or nil when the request must be answered by an admin. Scheduled requests
+// are evaluated at the time they start.
+func matchingPolicy(ctx context.Context, request *model.ForwardingRequest) (*AutoApprovalPolicy, error) {
+    groupMembers := func(group string) ([]string, error) {
+        return slackClient.GetUserGroupMembersContext(ctx, group)
+    }
+
+    at := time.Now()
+    if request.StartsAt != nil {
+        at = *request.StartsAt
+    }
+
+    for i, policy := range config.Policies {
+        ok, err := policy.matches(request, at, groupMembers)
+        if err != nil {
+            return nil, err
+        }
+
+        if ok {
+            return &config.Policies[i], nil
+        }
+    }
+
+    return nil, nil
+}
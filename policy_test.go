@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "github.com/CedricFinance/phone_operator/model"
+    "testing"
+    "time"
+)
+
+func TestAutoApprovalPolicy_Matches(t *testing.T) {
+    groupMembers := func(group string) ([]string, error) {
+        if group == "SONCALL" {
+            return []string{"UONCALL"}, nil
+        }
+        return nil, errors.New("unknown group")
+    }
+
+    // 2023-03-10 was a Friday, 10:30 in Paris
+    morning := time.Date(2023, 3, 10, 9, 30, 0, 0, time.UTC)
+    night := time.Date(2023, 3, 10, 22, 30, 0, 0, time.UTC)
+
+    tests := []struct {
+        name     string
+        policy   AutoApprovalPolicy
+        request  model.ForwardingRequest
+        now      time.Time
+        expected bool
+    }{
+        {"everyone", AutoApprovalPolicy{}, model.ForwardingRequest{RequesterId: "U1", Duration: 60}, morning, true},
+        {"listed user", AutoApprovalPolicy{Users: []string{"U1"}}, model.ForwardingRequest{RequesterId: "U1", Duration: 60}, morning, true},
+        {"unlisted user", AutoApprovalPolicy{Users: []string{"U1"}}, model.ForwardingRequest{RequesterId: "U2", Duration: 60}, morning, false},
+        {"group member", AutoApprovalPolicy{Groups: []string{"SONCALL"}}, model.ForwardingRequest{RequesterId: "UONCALL", Duration: 60}, morning, true},
+        {"not a group member", AutoApprovalPolicy{Groups: []string{"SONCALL"}}, model.ForwardingRequest{RequesterId: "U2", Duration: 60}, morning, false},
+        {"short duration", AutoApprovalPolicy{MaxDuration: 30}, model.ForwardingRequest{RequesterId: "U1", Duration: 30}, morning, true},
+        {"long duration", AutoApprovalPolicy{MaxDuration: 30}, model.ForwardingRequest{RequesterId: "U1", Duration: 31}, morning, false},
+        {"office hours", AutoApprovalPolicy{From: "09:00", To: "18:00", Timezone: "Europe/Paris"}, model.ForwardingRequest{RequesterId: "U1"}, morning, true},
+        {"outside office hours", AutoApprovalPolicy{From: "09:00", To: "18:00", Timezone: "Europe/Paris"}, model.ForwardingRequest{RequesterId: "U1"}, night, false},
+        {"overnight window", AutoApprovalPolicy{From: "20:00", To: "08:00"}, model.ForwardingRequest{RequesterId: "U1"}, night, true},
+        {"outside overnight window", AutoApprovalPolicy{From: "20:00", To: "08:00"}, model.ForwardingRequest{RequesterId: "U1"}, morning, false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            matches, err := test.policy.matches(&test.request, test.now, groupMembers)
+            if err != nil {
+                t.Errorf("unexpected error: %s", err)
+            }
+
+            if matches != test.expected {
+                t.Errorf("expected matches to be %t, got: %t", test.expected, matches)
+            }
+        })
+    }
+}
+
+func TestAutoApprovalPolicy_InvalidTime(t *testing.T) {
+    policy := AutoApprovalPolicy{Name: "invalid", From: "9h"}
+
+    _, err := policy.matches(&model.ForwardingRequest{RequesterId: "U1"}, time.Now(), nil)
+    if err == nil {
+        t.Errorf("expected an error for an invalid start time")
+    }
+}
+
+func TestMatchingPolicy_ScheduledRequest(t *testing.T) {
+    config.Policies = []AutoApprovalPolicy{{Name: "office hours", From: "09:00", To: "18:00"}}
+    defer func() { config.Policies = nil }()
+
+    tomorrow := time.Now().UTC().AddDate(0, 0, 1)
+    morning := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 10, 0, 0, 0, time.UTC)
+    night := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 22, 0, 0, 0, time.UTC)
+
+    policy, err := matchingPolicy(context.Background(), &model.ForwardingRequest{RequesterId: "U1", StartsAt: &morning})
+    if err != nil || policy == nil {
+        t.Errorf("expected a request starting during office hours to be accepted, got: %v, %v", policy, err)
+    }
+
+    policy, err = matchingPolicy(context.Background(), &model.ForwardingRequest{RequesterId: "U1", StartsAt: &night})
+    if err != nil || policy != nil {
+        t.Errorf("expected a request starting at night to need an admin, got: %v, %v", policy, err)
+    }
+}
+
+func TestAutoApprovalPolicy_Numbers(t *testing.T) {
+    config.Numbers = []PhoneNumber{
+        {Number: "+33612345678", Alias: "bank-line", Admins: []string{"UFINANCE"}},
+        {Number: "+33698765432"},
+    }
+    defer func() { config.Numbers = nil }()
+
+    tests := []struct {
+        name     string
+        policy   AutoApprovalPolicy
+        number   string
+        expected bool
+    }{
+        {"number of the slack admins", AutoApprovalPolicy{}, "+33698765432", true},
+        // The admins of the number must answer its requests
+        {"number with its own admins", AutoApprovalPolicy{}, "+33612345678", false},
+        {"every number", AutoApprovalPolicy{}, "", false},
+        {"listed alias", AutoApprovalPolicy{Numbers: []string{"bank-line"}}, "+33612345678", true},
+        {"unlisted number", AutoApprovalPolicy{Numbers: []string{"bank-line"}}, "+33698765432", false},
+        {"every number with listed numbers", AutoApprovalPolicy{Numbers: []string{"bank-line"}}, "", false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            matches, err := test.policy.matches(&model.ForwardingRequest{RequesterId: "U1", Duration: 60, Number: test.number}, time.Now(), nil)
+            if err != nil {
+                t.Errorf("unexpected error: %s", err)
+            }
+
+            if matches != test.expected {
+                t.Errorf("expected matches to be %t, got: %t", test.expected, matches)
+            }
+        })
+    }
+}
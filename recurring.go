@@ -0,0 +1,450 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/CedricFinance/phone_operator/repository"
+    "github.com/slack-go/slack"
+    "log"
+    "net/http"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+)
+
+const (
+    recurringCheckInterval = 5 * time.Minute
+    // recurringLookahead is how long in advance the forwarding requests are
+    // created, leaving time for the admins to accept the pending ones.
+    recurringLookahead = 12 * time.Hour
+)
+
+const recurringHelp = "Please enter days followed by a window, e.g. `every weekdays 19:00-08:00`, `every mon,wed,fri 9am to 11am` or `every daily 22:00-06:00`. Days can be `daily`, `weekdays`, `weekends`, day names (`mon`, `tuesday`) separated by commas, or ranges (`mon-fri`)."
+
+var recurringPattern = regexp.MustCompile(`^(\S+)\s+(\S+?)\s*(?:-|–|\sto\s)\s*(\S+(?:\s*[ap]m)?)$`)
+
+// occurrence is a forwarding window created by a recurring schedule
+type occurrence struct {
+    start    time.Time
+    duration time.Duration
+}
+
+func scheduleCommand(ctx context.Context, w http.ResponseWriter, command slack.SlashCommand, args string) {
+    parts := strings.SplitN(args, " ", 2)
+
+    // The number, or its alias, can lead the schedule, e.g. `bank-line every weekdays 19:00-08:00`
+    number := ""
+    if phoneNumber := lookupNumber(parts[0]); phoneNumber != nil && len(parts) > 1 {
+        number = phoneNumber.Number
+        args = parts[1]
+        parts = strings.SplitN(args, " ", 2)
+    } else if len(config.Numbers) == 1 {
+        number = config.Numbers[0].Number
+    }
+
+    if number == "" && len(config.Numbers) > 1 && parts[0] != "list" && parts[0] != "delete" {
+        fmt.Fprintf(w, "Which number do you want to receive texts from? Use `/sms schedule [number] [window]` with one of %s.", describeNumbers())
+        return
+    }
+
+    switch parts[0] {
+    case "every":
+        spec := ""
+        if len(parts) > 1 {
+            spec = parts[1]
+        }
+        createRecurringSchedule(ctx, w, command.UserID, command.UserName, number, spec)
+    case "list":
+        listRecurringSchedules(ctx, w, command.UserID)
+    case "delete":
+        if len(parts) < 2 {
+            fmt.Fprint(w, "Which schedule do you want to delete? Use `/sms schedule list` to find its id.")
+            return
+        }
+        deleteRecurringSchedule(ctx, w, command.UserID, strings.TrimSpace(parts[1]))
+    default:
+        scheduleSMSForward(ctx, w, command.UserID, command.UserName, number, args)
+    }
+}
+
+func createRecurringSchedule(ctx context.Context, w http.ResponseWriter, userId string, userName string, number string, spec string) {
+    weekdays, startTime, endTime, err := parseRecurringSpec(spec)
+    if err != nil {
+        fmt.Fprint(w, err.Error())
+        return
+    }
+
+    schedule := repository.NewRecurringSchedule(userId, userName, weekdays, startTime, endTime, userLocation(ctx, userId).String())
+    schedule.Number = number
+    err = repo.SaveRecurringSchedule(ctx, schedule)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    _, _, err = slackClient.PostMessage(
+        numberChannel(schedule.Number),
+        slack.MsgOptionBlocks(messages.AcceptRefuseScheduleMessage(schedule).Blocks.BlockSet...),
+    )
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    err = UpdateHome(ctx, userId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+    }
+
+    fmt.Fprintf(w, "I have forwarded your schedule to the admins. Until they accept it, each forwarding will need to be accepted.")
+}
+
+func listRecurringSchedules(ctx context.Context, w http.ResponseWriter, userId string) {
+    schedules, err := repo.GetRecurringSchedules(ctx, userId)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    if len(schedules) == 0 {
+        fmt.Fprint(w, "You don't have any recurring schedule.")
+        return
+    }
+
+    lines := []string{"Your recurring schedules:"}
+    for _, schedule := range schedules {
+        lines = append(lines, fmt.Sprintf("- `%s` %s", shortId(schedule.Id), messages.DescribeSchedule(schedule)))
+    }
+
+    fmt.Fprint(w, strings.Join(lines, "\n"))
+}
+
+func deleteRecurringSchedule(ctx context.Context, w http.ResponseWriter, userId string, id string) {
+    schedules, err := repo.GetRecurringSchedules(ctx, userId)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    for _, schedule := range schedules {
+        if strings.HasPrefix(schedule.Id, id) {
+            deleteSchedule(ctx, schedule.Id, userId)
+            UpdateHome(ctx, userId)
+            fmt.Fprintf(w, "I deleted your schedule %s", messages.DescribeSchedule(schedule))
+            return
+        }
+    }
+
+    fmt.Fprintf(w, "You don't have any schedule with id %q. Use `/sms schedule list` to find its id.", id)
+}
+
+// deleteSchedule deletes the schedule if it belongs to the user. The
+// forwarding requests it created which haven't started yet are cancelled, the
+// current one keeps running until it ends or is stopped.
+func deleteSchedule(ctx context.Context, scheduleId string, userId string) {
+    schedule, err := repo.GetRecurringSchedule(ctx, scheduleId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    if schedule.RequesterId != userId {
+        fmt.Printf("%s tried to delete schedule %s of %s\n", userId, scheduleId, schedule.RequesterId)
+        return
+    }
+
+    err = repo.DeleteRecurringSchedule(ctx, scheduleId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    err = repo.StopScheduleForwardingRequests(ctx, scheduleId, userId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+    }
+}
+
+func shortId(id string) string {
+    if len(id) > 8 {
+        return id[:8]
+    }
+    return id
+}
+
+// parseRecurringSpec understands specs such as `weekdays 19:00-08:00`. Times
+// are returned in the "15:04" format.
+func parseRecurringSpec(spec string) ([]time.Weekday, string, string, error) {
+    result := recurringPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(spec)))
+    if result == nil {
+        return nil, "", "", fmt.Errorf("I don't understand the schedule you want. %s", recurringHelp)
+    }
+
+    weekdays, err := parseWeekdays(result[1])
+    if err != nil {
+        return nil, "", "", err
+    }
+
+    startHour, startMinute, err := parseTimeOfDay(strings.ReplaceAll(result[2], " ", ""))
+    if err != nil {
+        return nil, "", "", err
+    }
+
+    endHour, endMinute, err := parseTimeOfDay(strings.ReplaceAll(result[3], " ", ""))
+    if err != nil {
+        return nil, "", "", err
+    }
+
+    if startHour == endHour && startMinute == endMinute {
+        return nil, "", "", fmt.Errorf("The window must end after it starts.")
+    }
+
+    return weekdays, fmt.Sprintf("%02d:%02d", startHour, startMinute), fmt.Sprintf("%02d:%02d", endHour, endMinute), nil
+}
+
+func parseWeekdays(spec string) ([]time.Weekday, error) {
+    switch spec {
+    case "daily", "day", "days":
+        return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, nil
+    case "weekdays", "weeknights":
+        return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, nil
+    case "weekends", "weekend":
+        return []time.Weekday{time.Sunday, time.Saturday}, nil
+    }
+
+    selected := make(map[time.Weekday]bool)
+    for _, part := range strings.Split(spec, ",") {
+        bounds := strings.SplitN(part, "-", 2)
+
+        first, ok := parseWeekday(bounds[0])
+        if !ok {
+            return nil, fmt.Errorf("%q is not a valid day. %s", bounds[0], recurringHelp)
+        }
+
+        last := first
+        if len(bounds) == 2 {
+            last, ok = parseWeekday(bounds[1])
+            if !ok {
+                return nil, fmt.Errorf("%q is not a valid day. %s", bounds[1], recurringHelp)
+            }
+        }
+
+        for weekday := first; ; weekday = (weekday + 1) % 7 {
+            selected[weekday] = true
+            if weekday == last {
+                break
+            }
+        }
+    }
+
+    var weekdays []time.Weekday
+    for weekday := range selected {
+        weekdays = append(weekdays, weekday)
+    }
+    sort.Slice(weekdays, func(i, j int) bool { return weekdays[i] < weekdays[j] })
+
+    return weekdays, nil
+}
+
+// scheduleOccurrences returns the windows of the schedule starting after from
+// and until to.
+func scheduleOccurrences(schedule *model.RecurringSchedule, location *time.Location, from time.Time, to time.Time) []occurrence {
+    startHour, startMinute, err := parseTimeOfDay(schedule.StartTime)
+    if err != nil {
+        return nil
+    }
+
+    endHour, endMinute, err := parseTimeOfDay(schedule.EndTime)
+    if err != nil {
+        return nil
+    }
+
+    var occurrences []occurrence
+
+    day := from.In(location)
+    for i := 0; i <= int(to.Sub(from)/(24*time.Hour))+1; i++ {
+        date := time.Date(day.Year(), day.Month(), day.Day()+i, 0, 0, 0, 0, location)
+        if !containsWeekday(schedule.Weekdays, date.Weekday()) {
+            continue
+        }
+
+        start := time.Date(date.Year(), date.Month(), date.Day(), startHour, startMinute, 0, 0, location)
+        if !start.After(from) || start.After(to) {
+            continue
+        }
+
+        end := time.Date(date.Year(), date.Month(), date.Day(), endHour, endMinute, 0, 0, location)
+        if !end.After(start) {
+            end = time.Date(date.Year(), date.Month(), date.Day()+1, endHour, endMinute, 0, 0, location)
+        }
+
+        occurrences = append(occurrences, occurrence{start: start, duration: end.Sub(start)})
+    }
+
+    return occurrences
+}
+
+func containsWeekday(weekdays []time.Weekday, weekday time.Weekday) bool {
+    for _, w := range weekdays {
+        if w == weekday {
+            return true
+        }
+    }
+
+    return false
+}
+
+// runRecurringScheduler periodically creates the forwarding requests of the
+// recurring schedules.
+func runRecurringScheduler(ctx context.Context) {
+    ticker := time.NewTicker(recurringCheckInterval)
+    defer ticker.Stop()
+
+    for {
+        materializeSchedules(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func materializeSchedules(ctx context.Context) {
+    schedules, err := repo.GetEnabledRecurringSchedules(ctx)
+    if err != nil {
+        log.Printf("Failed to get recurring schedules: %v", err)
+        return
+    }
+
+    for _, schedule := range schedules {
+        err = materializeSchedule(ctx, schedule, time.Now().UTC())
+        if err != nil {
+            log.Printf("Failed to create the requests of schedule %s: %v", schedule.Id, err)
+        }
+    }
+}
+
+func materializeSchedule(ctx context.Context, schedule *model.RecurringSchedule, now time.Time) error {
+    location, err := time.LoadLocation(schedule.Timezone)
+    if err != nil {
+        return err
+    }
+
+    until := now.Add(recurringLookahead)
+    if !until.After(schedule.MaterializedUntil) {
+        return nil
+    }
+
+    // The horizon only moves once every occurrence exists and was posted to
+    // the admins, the failed ones are retried on the next run. Occurrences
+    // have a stable id, so the ones already created, possibly by another
+    // instance, are only posted when that failed.
+    failed := 0
+    for _, occurrence := range scheduleOccurrences(schedule, location, schedule.MaterializedUntil, until) {
+        request := repository.NewOccurrenceForwardingRequest(
+            schedule,
+            occurrence.start,
+            int(occurrence.duration/time.Minute),
+        )
+
+        if schedule.IsApproved() {
+            expiresAt := request.StartsAt.Add(occurrence.duration)
+            request.AcceptedAt = &now
+            request.ExpiresAt = &expiresAt
+            request.AnsweredBy = schedule.AnsweredBy
+        }
+
+        _, err = createForwardingRequest(ctx, request)
+        if err == repository.DuplicateEntry {
+            err = postMissingApprovalMessage(ctx, request.Id)
+        }
+        if err != nil {
+            log.Printf("Failed to create the request of schedule %s starting %s: %v", schedule.Id, occurrence.start, err)
+            failed++
+        }
+    }
+
+    if failed > 0 {
+        return fmt.Errorf("failed to create %d request(s), they will be retried", failed)
+    }
+
+    _, err = repo.AdvanceRecurringSchedule(ctx, schedule, until)
+    return err
+}
+
+// postMissingApprovalMessage posts the approval message of a request created
+// by a previous run which failed to post it.
+func postMissingApprovalMessage(ctx context.Context, requestId string) error {
+    request, err := repo.GetForwardingRequest(ctx, requestId)
+    if err != nil {
+        return err
+    }
+
+    if request.ApprovalTs != "" {
+        return nil
+    }
+
+    return postApprovalMessage(ctx, request)
+}
+
+// handleRecurringScheduleActions handles the admins' answer to a recurring
+// schedule.
+func handleRecurringScheduleActions(message slack.InteractionCallback, r *http.Request, w http.ResponseWriter) {
+    ctx := r.Context()
+    action := message.ActionCallback.BlockActions[0].ActionID
+    scheduleId := message.ActionCallback.BlockActions[0].Value
+
+    schedule, err := repo.GetRecurringSchedule(ctx, scheduleId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    allowed, reason, err := canAnswer(ctx, message.User.ID, action, schedule.RequesterId, schedule.Number)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+    if !allowed {
+        rejectAction(message, reason)
+        return
+    }
+
+    if action == "accept" {
+        err = repo.AcceptRecurringSchedule(ctx, scheduleId, message.User.ID)
+    } else {
+        err = repo.RefuseRecurringSchedule(ctx, scheduleId, message.User.ID)
+    }
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    schedule, err = repo.GetRecurringSchedule(ctx, scheduleId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    if schedule.IsApproved() {
+        notifyUser(ctx, schedule.RequesterId, fmt.Sprintf("Your schedule has been accepted. You'll receive texts %s.", messages.DescribeSchedule(schedule)))
+    } else {
+        notifyUser(ctx, schedule.RequesterId, "Sorry, your schedule has been refused.")
+    }
+
+    slackClient.PostMessage(
+        message.Channel.GroupConversation.Conversation.ID,
+        slack.MsgOptionBlocks(messages.AcceptRefuseScheduleMessage(schedule).Blocks.BlockSet...),
+        slack.MsgOptionReplaceOriginal(message.ResponseURL),
+    )
+
+    err = UpdateHome(ctx, schedule.RequesterId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+    }
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+    "context"
+    "database/sql/driver"
+    "errors"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/go-sql-driver/mysql"
+    "github.com/slack-go/slack"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseRecurringSpec(t *testing.T) {
+    weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+    tests := []struct {
+        input     string
+        weekdays  []time.Weekday
+        startTime string
+        endTime   string
+    }{
+        {"weekdays 19:00-08:00", weekdays, "19:00", "08:00"},
+        {"mon-fri 19:00 - 8:00", weekdays, "19:00", "08:00"},
+        {"Mon,Wed,Fri 9am to 11:30am", []time.Weekday{time.Monday, time.Wednesday, time.Friday}, "09:00", "11:30"},
+        {"weekends 22h–6h", []time.Weekday{time.Sunday, time.Saturday}, "22:00", "06:00"},
+        {"fri-mon 18:00-20:00", []time.Weekday{time.Sunday, time.Monday, time.Friday, time.Saturday}, "18:00", "20:00"},
+        {"daily 0:00-23:59", []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, "00:00", "23:59"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.input, func(t *testing.T) {
+            weekdays, startTime, endTime, err := parseRecurringSpec(test.input)
+            if err != nil {
+                t.Fatalf("failed to parse %q: %s", test.input, err)
+            }
+
+            if !reflect.DeepEqual(weekdays, test.weekdays) {
+                t.Errorf("invalid weekdays for %q, expected: %v, got: %v", test.input, test.weekdays, weekdays)
+            }
+
+            if startTime != test.startTime || endTime != test.endTime {
+                t.Errorf("invalid window for %q, expected: %s-%s, got: %s-%s", test.input, test.startTime, test.endTime, startTime, endTime)
+            }
+        })
+    }
+}
+
+func TestParseRecurringSpec_Invalid(t *testing.T) {
+    tests := []string{
+        "",
+        "weekdays",
+        "weekdays 19:00",
+        "someday 19:00-08:00",
+        "mon-funday 19:00-08:00",
+        "daily 25:00-08:00",
+        "daily 8:00-8:00",
+    }
+
+    for _, input := range tests {
+        t.Run(input, func(t *testing.T) {
+            _, _, _, err := parseRecurringSpec(input)
+            if err == nil {
+                t.Errorf("expected an error for %q", input)
+            }
+        })
+    }
+}
+
+func TestScheduleOccurrences(t *testing.T) {
+    paris, _ := time.LoadLocation("Europe/Paris")
+
+    schedule := &model.RecurringSchedule{
+        Weekdays:  []time.Weekday{time.Friday, time.Saturday, time.Sunday},
+        StartTime: "22:00",
+        EndTime:   "06:00",
+    }
+
+    // Friday 2023-03-24 23:00 in Paris, the clocks change on Sunday 26 at 2:00
+    from := time.Date(2023, 3, 24, 22, 0, 0, 0, time.UTC)
+    to := from.Add(3 * 24 * time.Hour)
+
+    expected := []occurrence{
+        {time.Date(2023, 3, 25, 22, 0, 0, 0, paris), 7 * time.Hour},
+        {time.Date(2023, 3, 26, 22, 0, 0, 0, paris), 8 * time.Hour},
+    }
+
+    occurrences := scheduleOccurrences(schedule, paris, from, to)
+    if len(occurrences) != len(expected) {
+        t.Fatalf("expected %d occurrences, got: %v", len(expected), occurrences)
+    }
+
+    for i, occurrence := range occurrences {
+        if !occurrence.start.Equal(expected[i].start) || occurrence.duration != expected[i].duration {
+            t.Errorf("invalid occurrence %d, expected: %s for %s, got: %s for %s", i, expected[i].start, expected[i].duration, occurrence.start, occurrence.duration)
+        }
+    }
+}
+
+func TestMaterializeSchedule(t *testing.T) {
+    now := time.Date(2023, 3, 24, 12, 0, 0, 0, time.UTC)
+    approvedAt := now.Add(-24 * time.Hour)
+
+    schedule := &model.RecurringSchedule{
+        Id:                "SCHEDULE",
+        RequesterId:       "UREQUESTER",
+        Number:            "+33612345678",
+        Weekdays:          []time.Weekday{time.Friday},
+        StartTime:         "22:00",
+        EndTime:           "06:00",
+        Timezone:          "UTC",
+        ApprovedAt:        &approvedAt,
+        MaterializedUntil: now,
+    }
+
+    duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+
+    tests := []struct {
+        name       string
+        err        error
+        approvalTs string
+        failPost   bool
+        posts      int
+        advanced   bool
+    }{
+        {"created", nil, "", false, 1, true},
+        // The horizon stays so that the occurrence is created by the next run
+        {"failure", errors.New("database unavailable"), "", false, 0, false},
+        {"post failure", nil, "", true, 1, false},
+        // Another run already created and posted it
+        {"duplicate", duplicate, "1600000000.000100", false, 0, true},
+        // Another run created it but failed to post it
+        {"duplicate not posted", duplicate, "", false, 1, true},
+        {"duplicate post failure", duplicate, "", true, 1, false},
+    }
+
+    var requestIds []interface{}
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+            if test.err != nil {
+                db.OnExec("INSERT INTO ForwardingRequests", 0, test.err)
+            }
+            if test.failPost {
+                fake.Fail("chat.postMessage")
+            }
+            db.OnQuery("FROM ForwardingRequests WHERE id = ?", requestRow(model.ForwardingRequest{Id: "OCCURRENCE", RequesterId: schedule.RequesterId, ScheduleId: schedule.Id, ApprovalTs: test.approvalTs}))
+
+            err := materializeSchedule(context.Background(), schedule, now)
+            if (err == nil) != test.advanced {
+                t.Errorf("unexpected error: %v", err)
+            }
+
+            inserted := db.Executed("INSERT INTO ForwardingRequests")
+            if len(inserted) != 1 {
+                t.Fatalf("expected one occurrence, got: %d", len(inserted))
+            }
+            requestIds = append(requestIds, inserted[0].Args[0])
+
+            if inserted[0].Args[11] != schedule.Number {
+                t.Errorf("expected the occurrence to forward %s, got: %v", schedule.Number, inserted[0].Args[11])
+            }
+
+            if posts := len(fake.Calls("chat.postMessage")); posts != test.posts {
+                t.Errorf("expected %d approval message(s), got: %d", test.posts, posts)
+            }
+
+            if advanced := len(db.Executed("UPDATE RecurringSchedules SET materialized_until")) == 1; advanced != test.advanced {
+                t.Errorf("expected advanced to be %t, got: %t", test.advanced, advanced)
+            }
+        })
+    }
+
+    for _, requestId := range requestIds {
+        if requestId != requestIds[0] {
+            t.Errorf("expected the occurrence to keep its id, got: %v", requestIds)
+        }
+    }
+}
+
+func scheduleRow(schedule model.RecurringSchedule) []driver.Value {
+    return []driver.Value{schedule.Id, schedule.RequesterId, schedule.RequesterName, schedule.Number, "5", schedule.StartTime, schedule.EndTime, schedule.Timezone, schedule.CreatedAt, nil, nil, "", nil, schedule.MaterializedUntil}
+}
+
+func TestHandleRecurringScheduleActions(t *testing.T) {
+    config.Slack.Admins = []string{"UADMIN"}
+    config.Numbers = []PhoneNumber{{Number: "+33612345678", Admins: []string{"UFINANCE"}}}
+    defer func() {
+        config.Slack.Admins = nil
+        config.Numbers = nil
+    }()
+
+    tests := []struct {
+        userId   string
+        number   string
+        accepted bool
+    }{
+        {"UFINANCE", "+33612345678", true},
+        {"UADMIN", "+33612345678", false},
+        // Schedules without a number also forward the number of UFINANCE
+        {"UADMIN", "", false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.userId+" "+test.number, func(t *testing.T) {
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+
+            schedule := model.RecurringSchedule{Id: "SCHEDULE", RequesterId: "UREQUESTER", Number: test.number, StartTime: "19:00", EndTime: "08:00", Timezone: "UTC"}
+            db.OnQuery("FROM RecurringSchedules WHERE id = ?", scheduleRow(schedule))
+
+            message := slack.InteractionCallback{User: slack.User{ID: test.userId}, ResponseURL: fake.URL + "/response"}
+            message.ActionCallback.BlockActions = []*slack.BlockAction{{ActionID: "accept", Value: schedule.Id}}
+
+            handleRecurringScheduleActions(message, httptest.NewRequest("POST", "/interactive", nil), httptest.NewRecorder())
+
+            if accepted := len(db.Executed("UPDATE RecurringSchedules SET approved_at")) == 1; accepted != test.accepted {
+                t.Errorf("expected accepted to be %t, got: %t", test.accepted, accepted)
+            }
+        })
+    }
+}
+
+func TestDeleteSchedule(t *testing.T) {
+    tests := []struct {
+        userId  string
+        deleted bool
+    }{
+        {"UREQUESTER", true},
+        {"UOTHER", false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.userId, func(t *testing.T) {
+            db := newFakeRepository(t)
+
+            schedule := model.RecurringSchedule{Id: "SCHEDULE", RequesterId: "UREQUESTER", StartTime: "19:00", EndTime: "08:00", Timezone: "UTC"}
+            db.OnQuery("FROM RecurringSchedules WHERE id = ?", scheduleRow(schedule))
+
+            deleteSchedule(context.Background(), schedule.Id, test.userId)
+
+            if deleted := len(db.Executed("UPDATE RecurringSchedules SET deleted_at")) == 1; deleted != test.deleted {
+                t.Errorf("expected deleted to be %t, got: %t", test.deleted, deleted)
+            }
+
+            // The requests it created which haven't started yet are cancelled
+            stopped := db.Executed("UPDATE ForwardingRequests SET expires_at = ?, stopped_at = ?, stopped_by = ? WHERE schedule_id = ?")
+            if (len(stopped) == 1) != test.deleted {
+                t.Fatalf("expected the requests to be stopped: %t, got: %d", test.deleted, len(stopped))
+            }
+            if test.deleted && (stopped[0].Args[2] != test.userId || stopped[0].Args[3] != schedule.Id) {
+                t.Errorf("unexpected arguments: %v", stopped[0].Args)
+            }
+        })
+    }
+}
+
+func TestScheduleCommand_Number(t *testing.T) {
+    config.Numbers = twoNumbers()
+    defer func() { config.Numbers = nil }()
+
+    tests := []struct {
+        name      string
+        args      string
+        statement string
+        column    int
+        number    string
+    }{
+        // Texts of several numbers can be forwarded, the requester must pick one
+        {"window without number", "tomorrow 14:00-16:00", "INSERT INTO ForwardingRequests", 11, ""},
+        {"schedule without number", "every weekdays 19:00-08:00", "INSERT INTO RecurringSchedules", 3, ""},
+        {"window", "bank-line tomorrow 14:00-16:00", "INSERT INTO ForwardingRequests", 11, "+33612345678"},
+        {"schedule", "+33698765432 every weekdays 19:00-08:00", "INSERT INTO RecurringSchedules", 3, "+33698765432"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newFakeRepository(t)
+            newFakeSlack(t)
+
+            w := httptest.NewRecorder()
+            scheduleCommand(context.Background(), w, slack.SlashCommand{UserID: "UREQUESTER", UserName: "requester"}, test.args)
+
+            saved := db.Executed(test.statement)
+            if test.number == "" {
+                if len(saved) != 0 || !strings.HasPrefix(w.Body.String(), "Which number") {
+                    t.Errorf("expected the requester to be asked for a number, got: %q", w.Body.String())
+                }
+                return
+            }
+
+            if len(saved) != 1 || saved[0].Args[test.column] != test.number {
+                t.Errorf("expected %s to be forwarded, got: %v (%q)", test.number, saved, w.Body.String())
+            }
+        })
+    }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/CedricFinance/phone_operator/repository"
+    "github.com/slack-go/slack"
+    "log"
+)
+
+func redaction() messages.Redaction {
+    return messages.Redaction{
+        Enabled:  config.Redaction.Enabled,
+        AllCodes: config.Redaction.AllCodes,
+        Keywords: config.Redaction.Keywords,
+    }
+}
+
+// canAccessMessage tells whether the user can see the redacted codes of the
+// message and reply to it: the admins of the number and the users the message
+// was sent to. When no admin is configured, only the latter can.
+func canAccessMessage(ctx context.Context, userId string, message *model.SMS) (bool, error) {
+    if hasConfiguredAdmins(message.To) {
+        admin, err := isAdmin(ctx, userId, message.To)
+        if err != nil || admin {
+            return admin, err
+        }
+    }
+
+    entries, err := repo.GetMessageOutboxEntries(ctx, message.Id)
+    if err != nil {
+        return false, err
+    }
+
+    for _, entry := range entries {
+        if entry.Kind == model.OutboxKindUser && entry.Recipient == userId {
+            return true, nil
+        }
+    }
+
+    return false, nil
+}
+
+// revealMessage shows the full text, and only to the user, if they are
+// allowed to see it. Every reveal is recorded, the text isn't shown when it
+// can't be.
+func revealMessage(ctx context.Context, message slack.InteractionCallback, messageId string) {
+    sms, err := repo.GetMessage(ctx, messageId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    allowed, err := canAccessMessage(ctx, message.User.ID, sms)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+    if !allowed {
+        log.Printf("%s was denied the reveal of SMS %s", message.User.ID, sms.Id)
+        rejectAction(message, ":no_entry_sign: You are not allowed to reveal this text.")
+        return
+    }
+
+    err = repo.SaveReveal(ctx, repository.NewReveal(sms.Id, message.User.ID))
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        rejectAction(message, fmt.Sprintf("Oops. Something went wrong :sad:. Error: %s", err))
+        return
+    }
+
+    log.Printf("%s revealed SMS %s from %s", message.User.ID, sms.Id, sms.From)
+
+    slackClient.PostMessage(
+        message.Channel.GroupConversation.Conversation.ID,
+        slack.MsgOptionBlocks(messages.SmsUserNotifyMessage(*sms).Blocks.BlockSet...),
+        slack.MsgOptionResponseURL(message.ResponseURL, slack.ResponseTypeEphemeral),
+    )
+}
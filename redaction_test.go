@@ -0,0 +1,124 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/slack-go/slack"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestRedaction_Redact(t *testing.T) {
+    keywordsOnly := messages.Redaction{Enabled: true}
+    allCodes := messages.Redaction{Enabled: true, AllCodes: true}
+    custom := messages.Redaction{Enabled: true, Keywords: []string{"Kennwort"}}
+
+    tests := []struct {
+        redaction messages.Redaction
+        body      string
+        expected  string
+        redacted  bool
+    }{
+        {keywordsOnly, "Your verification code is 123456", "Your verification code is ••••••", true},
+        {keywordsOnly, "123-456 is your Acme login code", "••••••• is your Acme login code", true},
+        {keywordsOnly, "G-483920 is your OTP", "G-•••••• is your OTP", true},
+        {keywordsOnly, "Your parcel 1234 arrives on 2023-03-10", "Your parcel 1234 arrives on 2023-03-10", false},
+        {keywordsOnly, "Use code 12 for a discount", "Use code 12 for a discount", false},
+        {allCodes, "Your parcel 1234 arrives today", "Your parcel •••• arrives today", true},
+        {custom, "Ihr Kennwort: 8842", "Ihr Kennwort: ••••", true},
+        {keywordsOnly, "Your order is shipping, tracking 48213", "Your order is shipping, tracking 48213", false},
+        {custom, "Kennwortwechsel am 2023-03-10, Filiale 8842", "Kennwortwechsel am 2023-03-10, Filiale 8842", false},
+        {keywordsOnly, "Authentication code: 5531", "Authentication code: ••••", true},
+        {messages.Redaction{}, "Your verification code is 123456", "Your verification code is 123456", false},
+    }
+
+    for _, test := range tests {
+        body, redacted := test.redaction.Redact(test.body)
+        if body != test.expected || redacted != test.redacted {
+            t.Errorf("invalid redaction of %q, expected: %q (%t), got: %q (%t)", test.body, test.expected, test.redacted, body, redacted)
+        }
+    }
+}
+
+func TestSmsChannelNotifyMessage_RevealButton(t *testing.T) {
+    sms := model.SMS{Id: "message", From: "ACME", Body: "Your verification code is 123456"}
+
+    message := messages.SmsChannelNotifyMessage(sms, nil, messages.Redaction{Enabled: true})
+    if !hasButton(message, "reveal") {
+        t.Errorf("expected a reveal button, got: %v", message.Blocks.BlockSet)
+    }
+
+    message = messages.SmsChannelNotifyMessage(sms, nil, messages.Redaction{})
+    if hasButton(message, "reveal") {
+        t.Errorf("expected no reveal button when redaction is disabled")
+    }
+}
+
+func hasButton(message slack.Message, actionId string) bool {
+    for _, block := range message.Blocks.BlockSet {
+        actions, ok := block.(*slack.ActionBlock)
+        if !ok {
+            continue
+        }
+
+        for _, element := range actions.Elements.ElementSet {
+            if button, ok := element.(*slack.ButtonBlockElement); ok && button.ActionID == actionId {
+                return true
+            }
+        }
+    }
+
+    return false
+}
+
+func TestRevealMessage(t *testing.T) {
+    defer func() { config.Slack.Admins = nil }()
+
+    tests := []struct {
+        name     string
+        admins   []string
+        userId   string
+        saveErr  error
+        revealed bool
+    }{
+        {"recipient", nil, "UALICE", nil, true},
+        // Everyone is an admin when no admin is configured
+        {"anyone", nil, "UBOB", nil, false},
+        {"admin", []string{"UADMIN"}, "UADMIN", nil, true},
+        {"recipient with admins", []string{"UADMIN"}, "UALICE", nil, true},
+        {"unrecorded", nil, "UALICE", errors.New("database unavailable"), false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            config.Slack.Admins = test.admins
+
+            db := newFakeRepository(t)
+            fake := newFakeSlack(t)
+            db.OnQuery("FROM Messages WHERE id = ?", messageRow(model.SMS{Id: "MESSAGE", From: "ACME", To: "33612345678", Body: "Your verification code is 123456", ReceivedAt: time.Now().UTC()}))
+            db.OnQuery("FROM OutboxEntries", recipientRow("MESSAGE", "UALICE"))
+            if test.saveErr != nil {
+                db.OnExec("INSERT INTO Reveals", 0, test.saveErr)
+            }
+
+            message := slack.InteractionCallback{User: slack.User{ID: test.userId}, ResponseURL: fake.URL + "/response"}
+            revealMessage(context.Background(), message, "MESSAGE")
+
+            reveals := db.Executed("INSERT INTO Reveals")
+            if recorded := len(reveals) == 1 && reveals[0].Args[2] == test.userId; recorded != (test.revealed || test.saveErr != nil) {
+                t.Errorf("expected the reveal to be recorded: %t, got: %v", test.revealed, reveals)
+            }
+
+            responses := fake.Calls("response")
+            if len(responses) != 1 {
+                t.Fatalf("expected an answer to %s, got: %v", test.userId, responses)
+            }
+            if revealed := strings.Contains(responses[0].Body, "123456"); revealed != test.revealed {
+                t.Errorf("expected revealed to be %t, got: %s", test.revealed, responses[0].Body)
+            }
+        })
+    }
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/CedricFinance/phone_operator/repository"
+    "github.com/slack-go/slack"
+    "github.com/slack-go/slack/slackevents"
+    "log"
+    "net/http"
+    "strings"
+)
+
+var errReplyNotAllowed = errors.New(":no_entry_sign: You are not allowed to reply to this text.")
+
+func handleSmsMessageActions(message slack.InteractionCallback, r *http.Request, w http.ResponseWriter) {
+    action := message.ActionCallback.BlockActions[0]
+
+    switch action.ActionID {
+    case "reveal":
+        revealMessage(r.Context(), message, action.Value)
+    case "reply":
+        openReplyModal(r.Context(), message, action.Value)
+    }
+}
+
+// openReplyModal asks the user for their reply, if they can send one.
+func openReplyModal(ctx context.Context, message slack.InteractionCallback, messageId string) {
+    sms, err := repo.GetMessage(ctx, messageId)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+
+    allowed, err := canAccessMessage(ctx, message.User.ID, sms)
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+        return
+    }
+    if !allowed {
+        rejectAction(message, errReplyNotAllowed.Error())
+        return
+    }
+
+    _, err = slackClient.OpenViewContext(ctx, message.TriggerID, messages.ReplyModal(*sms))
+    if err != nil {
+        fmt.Printf("Error: %v", err)
+    }
+}
+
+func handleReplySubmission(message slack.InteractionCallback, r *http.Request, w http.ResponseWriter) {
+    ctx := r.Context()
+    body := strings.TrimSpace(message.View.State.Values["reply"]["body"].Value)
+
+    replyError := func(err error) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(slack.NewErrorsViewSubmissionResponse(map[string]string{
+            "reply": err.Error(),
+        }))
+    }
+
+    sms, err := repo.GetMessage(ctx, message.View.PrivateMetadata)
+    if err != nil {
+        replyError(err)
+        return
+    }
+
+    err = replySMS(ctx, sms, message.User.ID, body)
+    if err != nil {
+        replyError(err)
+        return
+    }
+
+    if sms.SlackTs != "" {
+        threadTs := sms.SlackThreadTs
+        if threadTs == "" {
+            threadTs = sms.SlackTs
+        }
+
+        _, _, err = slackClient.PostMessageContext(
+            ctx,
+            sms.SlackChannel,
+            slack.MsgOptionText(fmt.Sprintf(":outbox_tray: <@%s> replied:\n```\n%s\n```", message.User.ID, body), false),
+            slack.MsgOptionTS(threadTs),
+        )
+        if err != nil {
+            fmt.Printf("Error: %v", err)
+        }
+    }
+}
+
+// replySMS sends the body back to the sender of the SMS, through the provider
+// which delivered it.
+func replySMS(ctx context.Context, sms *model.SMS, userId string, body string) error {
+    if body == "" {
+        return errors.New("The reply is empty.")
+    }
+
+    allowed, err := canAccessMessage(ctx, userId, sms)
+    if err != nil {
+        return err
+    }
+    if !allowed {
+        return errReplyNotAllowed
+    }
+
+    sender, ok := smsSenders[sms.Provider]
+    if !ok {
+        return fmt.Errorf("Replies through %q are not configured.", sms.Provider)
+    }
+
+    sendErr := sender.SendSMS(ctx, sms.To, sms.From, body)
+
+    err = repo.SaveReply(ctx, repository.NewReply(sms.Id, userId, body, sendErr))
+    if err != nil {
+        log.Printf("Failed to save the reply of %s to SMS %s: %v", userId, sms.Id, err)
+    }
+
+    if sendErr != nil {
+        return sendErr
+    }
+
+    log.Printf("%s replied to SMS %s from %s", userId, sms.Id, sms.From)
+
+    return nil
+}
+
+// eventsHandler receives the Slack events. The replies posted in the thread
+// of a SMS are sent to its sender.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+    body, err := readBody(r)
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        return
+    }
+
+    // The request is verified by SlackVerifier
+    event, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+    if err != nil {
+        log.Printf("Failed to parse Slack event: %v", err)
+        w.WriteHeader(http.StatusBadRequest)
+        return
+    }
+
+    switch event.Type {
+    case slackevents.URLVerification:
+        verification, ok := event.Data.(*slackevents.EventsAPIURLVerificationEvent)
+        if !ok {
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        w.Header().Set("Content-Type", "text/plain")
+        fmt.Fprint(w, verification.Challenge)
+
+    case slackevents.CallbackEvent:
+        // Retries would send the reply twice
+        if r.Header.Get("X-Slack-Retry-Num") != "" {
+            return
+        }
+
+        message, ok := event.InnerEvent.Data.(*slackevents.MessageEvent)
+        if ok && config.Slack.ThreadReplies && isThreadReply(message) {
+            // Slack expects an answer within 3 seconds
+            go handleThreadReply(context.Background(), message)
+        }
+    }
+}
+
+// isThreadReply tells whether the event is a reply written by a user in a
+// thread.
+func isThreadReply(message *slackevents.MessageEvent) bool {
+    return message.ThreadTimeStamp != "" &&
+        message.ThreadTimeStamp != message.TimeStamp &&
+        message.SubType == "" &&
+        message.BotID == "" &&
+        message.User != ""
+}
+
+func handleThreadReply(ctx context.Context, message *slackevents.MessageEvent) {
+    sms, err := repo.GetThreadMessage(ctx, message.Channel, message.ThreadTimeStamp)
+    if _, ok := err.(repository.NotFound); ok {
+        // Not a SMS thread
+        return
+    }
+    if err != nil {
+        log.Printf("Failed to find the SMS of thread %s: %v", message.ThreadTimeStamp, err)
+        return
+    }
+
+    err = replySMS(ctx, sms, message.User, unescapeSlackText(message.Text))
+    if err != nil {
+        log.Printf("Failed to send the reply of %s to SMS %s: %v", message.User, sms.Id, err)
+        slackClient.PostEphemeralContext(
+            ctx,
+            message.Channel,
+            message.User,
+            slack.MsgOptionText(fmt.Sprintf("Your reply was not sent: %s", err), false),
+            slack.MsgOptionTS(message.ThreadTimeStamp),
+        )
+        return
+    }
+
+    err = slackClient.AddReactionContext(ctx, "outbox_tray", slack.NewRefToMessage(message.Channel, message.TimeStamp))
+    if err != nil {
+        log.Printf("Failed to acknowledge the reply of %s: %v", message.User, err)
+    }
+}
+
+// unescapeSlackText reverts the escaping of the control characters of Slack.
+// See https://api.slack.com/reference/surfaces/formatting#escaping
+func unescapeSlackText(text string) string {
+    return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
+}
@@ -7,11 +7,16 @@ import (
 	"github.com/CedricFinance/phone_operator/model"
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
+	"strings"
 	"time"
 )
 
 const (
 	ForwardingRequestType = "ForwardingRequestType"
+	MessageType           = "MessageType"
+	ExtensionType         = "ExtensionType"
+	ScheduleType          = "ScheduleType"
+	RoutingRuleType       = "RoutingRuleType"
 )
 
 type Repository struct {
@@ -36,6 +41,24 @@ func (e duplicateEntry) Error() string {
 
 var DuplicateEntry = duplicateEntry{}
 
+type inactiveRequest struct {
+}
+
+func (e inactiveRequest) Error() string {
+	return "the forwarding request is not active"
+}
+
+var InactiveRequest = inactiveRequest{}
+
+type alreadyAnswered struct {
+}
+
+func (e alreadyAnswered) Error() string {
+	return "the forwarding request has already been answered"
+}
+
+var AlreadyAnswered = alreadyAnswered{}
+
 func New(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
@@ -43,7 +66,7 @@ func New(db *sql.DB) *Repository {
 func (r *Repository) SaveForwardingRequest(ctx context.Context, request *model.ForwardingRequest) error {
 	res, err := r.db.ExecContext(
 		ctx,
-		"INSERT INTO ForwardingRequests(id, requester_id, requester_name, duration, created_at, accepted_at, refused_at, expires_at) VALUES(?,?,?,?,?,?,?,?)",
+		"INSERT INTO ForwardingRequests(id, requester_id, requester_name, duration, created_at, accepted_at, refused_at, expires_at, answered_by, starts_at, schedule_id, number) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)",
 		request.Id,
 		request.RequesterId,
 		request.RequesterName,
@@ -52,6 +75,10 @@ func (r *Repository) SaveForwardingRequest(ctx context.Context, request *model.F
 		request.AcceptedAt,
 		request.RefusedAt,
 		request.ExpiresAt,
+		request.AnsweredBy,
+		request.StartsAt,
+		request.ScheduleId,
+		request.Number,
 	)
 
 	_ = res
@@ -65,57 +92,253 @@ func (r *Repository) SaveForwardingRequest(ctx context.Context, request *model.F
 	return err
 }
 
+// AcceptForwardingRequest starts the forwarding for the requested duration.
+// Scheduled requests end at the end of their window, whenever they are
+// accepted. It returns AlreadyAnswered when the request was accepted, refused
+// or cancelled before.
 func (r *Repository) AcceptForwardingRequest(ctx context.Context, requestId string, answeredBy string) error {
 	now := time.Now().UTC()
-	_, err := r.db.ExecContext(
+	res, err := r.db.ExecContext(
 		ctx,
-		"UPDATE ForwardingRequests SET accepted_at = ?, expires_at = DATE_ADD(?, INTERVAL duration minute), answered_by = ? WHERE id = ?",
+		"UPDATE ForwardingRequests SET accepted_at = ?, expires_at = DATE_ADD(COALESCE(starts_at, ?), INTERVAL duration minute), answered_by = ? WHERE id = ? AND accepted_at IS NULL AND refused_at IS NULL AND stopped_at IS NULL",
 		now,
 		now,
 		answeredBy,
 		requestId,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return checkAnswered(res)
 }
 
+// RefuseForwardingRequest returns AlreadyAnswered when the request was
+// accepted, refused or cancelled before.
 func (r *Repository) RefuseForwardingRequest(ctx context.Context, requestId string, answeredBy string) error {
-	_, err := r.db.ExecContext(
+	res, err := r.db.ExecContext(
 		ctx,
-		"UPDATE ForwardingRequests SET refused_at = ?, answered_by = ? WHERE id = ?",
+		"UPDATE ForwardingRequests SET refused_at = ?, answered_by = ? WHERE id = ? AND accepted_at IS NULL AND refused_at IS NULL AND stopped_at IS NULL",
 		time.Now().UTC(),
 		answeredBy,
 		requestId,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return checkAnswered(res)
+}
+
+func checkAnswered(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != 1 {
+		return AlreadyAnswered
+	}
+
+	return nil
 }
 
+const forwardingRequestColumns = "id, requester_id, requester_name, duration, created_at, accepted_at, refused_at, expires_at, COALESCE(answered_by, ''), stopped_at, stopped_by, approval_channel, approval_ts, starts_at, schedule_id, number"
+
 func (r *Repository) GetForwardingRequest(ctx context.Context, requestId string) (*model.ForwardingRequest, error) {
-	q := "SELECT id, requester_id, requester_name, duration, created_at, accepted_at, refused_at, expires_at, answered_by FROM ForwardingRequests WHERE id = ? LIMIT 1"
-	row, err := r.db.QueryContext(ctx, q, requestId)
+	q := "SELECT " + forwardingRequestColumns + " FROM ForwardingRequests WHERE id = ? LIMIT 1"
+
+	results, err := r.queryForwardingRequests(ctx, q, requestId)
 	if err != nil {
 		return nil, err
 	}
-	defer row.Close()
 
-	if !row.Next() {
+	if len(results) == 0 {
 		return nil, NotFound{ID: requestId, Type: ForwardingRequestType}
 	}
 
-	var result model.ForwardingRequest
+	return results[0], nil
+}
 
-	err = row.Scan(
+func (r *Repository) GetActiveForwardingRequests(ctx context.Context) ([]*model.ForwardingRequest, error) {
+	q := "SELECT " + forwardingRequestColumns + " FROM ForwardingRequests WHERE expires_at > NOW() AND (starts_at IS NULL OR starts_at <= NOW())"
+
+	return r.queryForwardingRequests(ctx, q)
+}
+
+func (r *Repository) GetForwardingRequests(ctx context.Context, requesterId string) ([]*model.ForwardingRequest, error) {
+	q := "SELECT " + forwardingRequestColumns + "\n  FROM ForwardingRequests\n WHERE requester_id = ?\n ORDER BY created_at DESC\n LIMIT 10"
+
+	return r.queryForwardingRequests(ctx, q, requesterId)
+}
+
+// GetExpiredForwardingRequests returns the accepted requests that expired
+// since the last call to MarkForwardingRequestExpirationHandled. Requests
+// which expired more than maxAge ago are ignored, so that older rows are
+// never handled.
+func (r *Repository) GetExpiredForwardingRequests(ctx context.Context, maxAge time.Duration) ([]*model.ForwardingRequest, error) {
+	q := "SELECT " + forwardingRequestColumns + "\n  FROM ForwardingRequests\n WHERE expires_at > ? AND expires_at <= ? AND expiration_handled_at IS NULL\n ORDER BY expires_at"
+
+	now := time.Now().UTC()
+	return r.queryForwardingRequests(ctx, q, now.Add(-maxAge), now)
+}
+
+// MarkForwardingRequestExpirationHandled returns false when the expiration
+// has already been handled, possibly by another instance.
+func (r *Repository) MarkForwardingRequestExpirationHandled(ctx context.Context, requestId string) (bool, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE ForwardingRequests SET expiration_handled_at = ? WHERE id = ? AND expiration_handled_at IS NULL",
+		time.Now().UTC(),
+		requestId,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// GetExpiringForwardingRequests returns the active requests expiring within
+// the given delay which haven't been warned about it yet. Requests shorter
+// than the delay are ignored.
+func (r *Repository) GetExpiringForwardingRequests(ctx context.Context, within time.Duration) ([]*model.ForwardingRequest, error) {
+	now := time.Now().UTC()
+	q := "SELECT " + forwardingRequestColumns + "\n  FROM ForwardingRequests\n WHERE expires_at > ? AND expires_at <= ? AND duration > ? AND expiry_warning_sent_at IS NULL\n ORDER BY expires_at"
+
+	return r.queryForwardingRequests(ctx, q, now, now.Add(within), int(within.Minutes()))
+}
+
+// MarkForwardingRequestExpiryWarningSent returns false when the warning has
+// already been sent, possibly by another instance.
+func (r *Repository) MarkForwardingRequestExpiryWarningSent(ctx context.Context, requestId string) (bool, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE ForwardingRequests SET expiry_warning_sent_at = ? WHERE id = ? AND expiry_warning_sent_at IS NULL",
+		time.Now().UTC(),
+		requestId,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// ExtendForwardingRequest pushes out the expiration of an active request and
+// records the extension as accepted. It returns InactiveRequest when the
+// request is not active anymore.
+func (r *Repository) ExtendForwardingRequest(ctx context.Context, extension *model.Extension) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = extendForwardingRequest(ctx, tx, extension.ForwardingRequestId, extension.Duration)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	extension.AcceptedAt = &now
+
+	err = insertExtension(ctx, tx, extension)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveExtension records an extension waiting for an admin's answer.
+func (r *Repository) SaveExtension(ctx context.Context, extension *model.Extension) error {
+	return insertExtension(ctx, r.db, extension)
+}
+
+// AcceptExtension applies a pending extension. It returns InactiveRequest when
+// the extended request is not active anymore.
+func (r *Repository) AcceptExtension(ctx context.Context, extensionId string, answeredBy string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var requestId string
+	var duration int
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT forwarding_request_id, duration FROM Extensions WHERE id = ? AND accepted_at IS NULL AND refused_at IS NULL FOR UPDATE",
+		extensionId,
+	).Scan(&requestId, &duration)
+	if err == sql.ErrNoRows {
+		return NotFound{ID: extensionId, Type: ExtensionType}
+	}
+	if err != nil {
+		return err
+	}
+
+	err = extendForwardingRequest(ctx, tx, requestId, duration)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE Extensions SET accepted_at = ?, answered_by = ? WHERE id = ?",
+		time.Now().UTC(),
+		answeredBy,
+		extensionId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) RefuseExtension(ctx context.Context, extensionId string, answeredBy string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE Extensions SET refused_at = ?, answered_by = ? WHERE id = ? AND accepted_at IS NULL AND refused_at IS NULL",
+		time.Now().UTC(),
+		answeredBy,
+		extensionId,
+	)
+
+	return err
+}
+
+func (r *Repository) GetExtension(ctx context.Context, extensionId string) (*model.Extension, error) {
+	var result model.Extension
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, forwarding_request_id, duration, requested_by, created_at, accepted_at, refused_at, answered_by FROM Extensions WHERE id = ? LIMIT 1",
+		extensionId,
+	).Scan(
 		&result.Id,
-		&result.RequesterId,
-		&result.RequesterName,
+		&result.ForwardingRequestId,
 		&result.Duration,
+		&result.RequestedBy,
 		&result.CreatedAt,
 		&result.AcceptedAt,
 		&result.RefusedAt,
-		&result.ExpiresAt,
 		&result.AnsweredBy,
 	)
+	if err == sql.ErrNoRows {
+		return nil, NotFound{ID: extensionId, Type: ExtensionType}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -123,10 +346,68 @@ func (r *Repository) GetForwardingRequest(ctx context.Context, requestId string)
 	return &result, nil
 }
 
-func (r *Repository) GetActiveForwardingRequests(ctx context.Context) ([]*model.ForwardingRequest, error) {
-	q := "SELECT id, requester_id, requester_name, duration, created_at, accepted_at, refused_at, expires_at, answered_by FROM ForwardingRequests WHERE expires_at > NOW()"
+func extendForwardingRequest(ctx context.Context, tx *sql.Tx, requestId string, duration int) error {
+	res, err := tx.ExecContext(
+		ctx,
+		"UPDATE ForwardingRequests SET expires_at = DATE_ADD(expires_at, INTERVAL ? minute), duration = duration + ?, expiry_warning_sent_at = NULL WHERE id = ? AND expires_at > ?",
+		duration,
+		duration,
+		requestId,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
 
-	rows, err := r.db.QueryContext(ctx, q)
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != 1 {
+		return InactiveRequest
+	}
+
+	return nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertExtension(ctx context.Context, db execer, extension *model.Extension) error {
+	_, err := db.ExecContext(
+		ctx,
+		"INSERT INTO Extensions(id, forwarding_request_id, duration, requested_by, created_at, accepted_at, refused_at, answered_by) VALUES(?,?,?,?,?,?,?,?)",
+		extension.Id,
+		extension.ForwardingRequestId,
+		extension.Duration,
+		extension.RequestedBy,
+		extension.CreatedAt,
+		extension.AcceptedAt,
+		extension.RefusedAt,
+		extension.AnsweredBy,
+	)
+
+	return err
+}
+
+// SetForwardingRequestApprovalMessage stores the location of the message
+// asking the admins to accept or refuse the request.
+func (r *Repository) SetForwardingRequestApprovalMessage(ctx context.Context, requestId string, channel string, ts string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE ForwardingRequests SET approval_channel = ?, approval_ts = ? WHERE id = ?",
+		channel,
+		ts,
+		requestId,
+	)
+
+	return err
+}
+
+func (r *Repository) queryForwardingRequests(ctx context.Context, q string, args ...interface{}) ([]*model.ForwardingRequest, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +428,143 @@ func (r *Repository) GetActiveForwardingRequests(ctx context.Context) ([]*model.
 			&result.RefusedAt,
 			&result.ExpiresAt,
 			&result.AnsweredBy,
+			&result.StoppedAt,
+			&result.StoppedBy,
+			&result.ApprovalChannel,
+			&result.ApprovalTs,
+			&result.StartsAt,
+			&result.ScheduleId,
+			&result.Number,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, &result)
+	}
+
+	return results, rows.Err()
+}
+
+// StopForwardingRequest ends an active or scheduled request, or cancels a
+// pending one. stoppedBy is either the requester or the admin who revoked the
+// request.
+func (r *Repository) StopForwardingRequest(ctx context.Context, requestId string, stoppedBy string) error {
+	now := time.Now().UTC()
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE ForwardingRequests SET expires_at = ?, stopped_at = ?, stopped_by = ? WHERE id = ? AND (expires_at > ? OR (accepted_at IS NULL AND refused_at IS NULL AND stopped_at IS NULL))",
+		now,
+		now,
+		stoppedBy,
+		requestId,
+		now,
+	)
+
+	return err
+}
+
+// StopScheduleForwardingRequests cancels the requests of the schedule which
+// haven't started yet.
+func (r *Repository) StopScheduleForwardingRequests(ctx context.Context, scheduleId string, stoppedBy string) error {
+	now := time.Now().UTC()
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE ForwardingRequests SET expires_at = ?, stopped_at = ?, stopped_by = ? WHERE schedule_id = ? AND starts_at > ? AND stopped_at IS NULL AND refused_at IS NULL",
+		now,
+		now,
+		stoppedBy,
+		scheduleId,
+		now,
+	)
+
+	return err
+}
+
+func (r *Repository) SaveMessage(ctx context.Context, message *model.SMS) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO Messages(id, provider, from_number, to_number, body, received_at) VALUES(?,?,?,?,?,?)",
+		message.Id,
+		message.Provider,
+		message.From,
+		message.To,
+		message.Body,
+		message.ReceivedAt,
+	)
+
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		if mysqlErr.Number == 1062 {
+			return DuplicateEntry
+		}
+	}
+
+	return err
+}
+
+const messageColumns = "id, provider, from_number, to_number, body, received_at, slack_channel, slack_ts, slack_thread_ts"
+
+func (r *Repository) GetMessage(ctx context.Context, messageId string) (*model.SMS, error) {
+	q := "SELECT " + messageColumns + " FROM Messages WHERE id = ? LIMIT 1"
+	row, err := r.db.QueryContext(ctx, q, messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+
+	if !row.Next() {
+		return nil, NotFound{ID: messageId, Type: MessageType}
+	}
+
+	var result model.SMS
+
+	err = row.Scan(
+		&result.Id,
+		&result.Provider,
+		&result.From,
+		&result.To,
+		&result.Body,
+		&result.ReceivedAt,
+		&result.SlackChannel,
+		&result.SlackTs,
+		&result.SlackThreadTs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetMessages returns the messages received since the given date, most recent first.
+func (r *Repository) GetMessages(ctx context.Context, since time.Time, limit int) ([]*model.SMS, error) {
+	q := "SELECT " + messageColumns + "\n  FROM Messages\n WHERE received_at >= ?\n ORDER BY received_at DESC\n LIMIT ?"
+
+	rows, err := r.db.QueryContext(ctx, q, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*model.SMS
+
+	for rows.Next() {
+		result := model.SMS{}
+
+		err = rows.Scan(
+			&result.Id,
+			&result.Provider,
+			&result.From,
+			&result.To,
+			&result.Body,
+			&result.ReceivedAt,
+			&result.SlackChannel,
+			&result.SlackTs,
+			&result.SlackThreadTs,
 		)
+		if err != nil {
+			return nil, err
+		}
 
 		results = append(results, &result)
 	}
@@ -155,31 +572,163 @@ func (r *Repository) GetActiveForwardingRequests(ctx context.Context) ([]*model.
 	return results, nil
 }
 
-func (r *Repository) GetForwardingRequests(ctx context.Context, requesterId string) ([]*model.ForwardingRequest, error) {
-	q := "SELECT id, requester_id, requester_name, duration, created_at, accepted_at, refused_at, expires_at, answered_by\n  FROM ForwardingRequests\n WHERE requester_id = ?\n ORDER BY created_at DESC\n LIMIT 10"
+// SetMessageSlackPost records where the SMS was posted in the channel.
+func (r *Repository) SetMessageSlackPost(ctx context.Context, messageId string, channel string, ts string, threadTs string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE Messages SET slack_channel = ?, slack_ts = ?, slack_thread_ts = ? WHERE id = ?",
+		channel,
+		ts,
+		threadTs,
+		messageId,
+	)
+
+	return err
+}
 
-	rows, err := r.db.QueryContext(ctx, q, requesterId)
+// GetThreadMessage returns the SMS starting the Slack thread. The following
+// texts of the sender may have been sent to another number sharing the
+// channel, so only the first one tells which number the thread is about.
+func (r *Repository) GetThreadMessage(ctx context.Context, channel string, threadTs string) (*model.SMS, error) {
+	q := "SELECT " + messageColumns + "\n  FROM Messages\n WHERE slack_channel = ? AND slack_ts = ? AND slack_thread_ts = ''"
+
+	var result model.SMS
+	err := r.db.QueryRowContext(ctx, q, channel, threadTs).Scan(
+		&result.Id,
+		&result.Provider,
+		&result.From,
+		&result.To,
+		&result.Body,
+		&result.ReceivedAt,
+		&result.SlackChannel,
+		&result.SlackTs,
+		&result.SlackThreadTs,
+	)
+	if err == sql.ErrNoRows {
+		return nil, NotFound{ID: threadTs, Type: MessageType}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetSenderPosts returns the messages of the sender posted to the recipient
+// channel since the given date, most recent first.
+func (r *Repository) GetSenderPosts(ctx context.Context, from string, recipient string, since time.Time) ([]*model.SMS, error) {
+	q := "SELECT m.id, m.provider, m.from_number, m.to_number, m.body, m.received_at, m.slack_channel, m.slack_ts, m.slack_thread_ts\n  FROM Messages m\n  JOIN OutboxEntries o ON o.message_id = m.id AND o.kind = ?\n WHERE m.from_number = ? AND o.recipient = ? AND m.slack_ts <> '' AND m.received_at >= ?\n ORDER BY m.received_at DESC"
+
+	rows, err := r.db.QueryContext(ctx, q, model.OutboxKindChannel, from, recipient, since)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var results []*model.ForwardingRequest
+	var results []*model.SMS
 
 	for rows.Next() {
-		result := model.ForwardingRequest{}
+		result := model.SMS{}
 
 		err = rows.Scan(
 			&result.Id,
-			&result.RequesterId,
-			&result.RequesterName,
-			&result.Duration,
+			&result.Provider,
+			&result.From,
+			&result.To,
+			&result.Body,
+			&result.ReceivedAt,
+			&result.SlackChannel,
+			&result.SlackTs,
+			&result.SlackThreadTs,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, &result)
+	}
+
+	return results, rows.Err()
+}
+
+func (r *Repository) SaveDelivery(ctx context.Context, delivery *model.Delivery) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO Deliveries(id, message_id, forwarding_request_id, user_id, created_at, error) VALUES(?,?,?,?,?,?)",
+		delivery.Id,
+		delivery.MessageId,
+		delivery.ForwardingRequestId,
+		delivery.UserId,
+		delivery.CreatedAt,
+		delivery.Error,
+	)
+
+	return err
+}
+
+func (r *Repository) SaveReply(ctx context.Context, reply *model.Reply) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO Replies(id, message_id, user_id, body, created_at, error) VALUES(?,?,?,?,?,?)",
+		reply.Id,
+		reply.MessageId,
+		reply.UserId,
+		reply.Body,
+		reply.CreatedAt,
+		reply.Error,
+	)
+
+	return err
+}
+
+func (r *Repository) SaveReveal(ctx context.Context, reveal *model.Reveal) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO Reveals(id, message_id, user_id, created_at) VALUES(?,?,?,?)",
+		reveal.Id,
+		reveal.MessageId,
+		reveal.UserId,
+		reveal.CreatedAt,
+	)
+
+	return err
+}
+
+func (r *Repository) GetMessageDeliveries(ctx context.Context, messageId string) ([]*model.Delivery, error) {
+	q := "SELECT id, message_id, forwarding_request_id, user_id, created_at, error\n  FROM Deliveries\n WHERE message_id = ?\n ORDER BY created_at"
+
+	return r.queryDeliveries(ctx, q, messageId)
+}
+
+func (r *Repository) GetForwardingRequestDeliveries(ctx context.Context, requestId string) ([]*model.Delivery, error) {
+	q := "SELECT id, message_id, forwarding_request_id, user_id, created_at, error\n  FROM Deliveries\n WHERE forwarding_request_id = ?\n ORDER BY created_at DESC"
+
+	return r.queryDeliveries(ctx, q, requestId)
+}
+
+func (r *Repository) queryDeliveries(ctx context.Context, q string, args ...interface{}) ([]*model.Delivery, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*model.Delivery
+
+	for rows.Next() {
+		result := model.Delivery{}
+
+		err = rows.Scan(
+			&result.Id,
+			&result.MessageId,
+			&result.ForwardingRequestId,
+			&result.UserId,
 			&result.CreatedAt,
-			&result.AcceptedAt,
-			&result.RefusedAt,
-			&result.ExpiresAt,
-			&result.AnsweredBy,
+			&result.Error,
 		)
+		if err != nil {
+			return nil, err
+		}
 
 		results = append(results, &result)
 	}
@@ -187,17 +736,269 @@ func (r *Repository) GetForwardingRequests(ctx context.Context, requesterId stri
 	return results, nil
 }
 
-func (r *Repository) StopForwardingRequest(ctx context.Context, requestId string) error {
+func NewDelivery(messageId string, requestId string, userId string, deliveryErr error) *model.Delivery {
+	delivery := &model.Delivery{
+		Id:                  uuid.New().String(),
+		MessageId:           messageId,
+		ForwardingRequestId: requestId,
+		UserId:              userId,
+		CreatedAt:           time.Now().UTC(),
+	}
+
+	if deliveryErr != nil {
+		errorMessage := deliveryErr.Error()
+		delivery.Error = &errorMessage
+	}
+
+	return delivery
+}
+
+func NewReply(messageId string, userId string, body string, sendErr error) *model.Reply {
+	reply := &model.Reply{
+		Id:        uuid.New().String(),
+		MessageId: messageId,
+		UserId:    userId,
+		Body:      body,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if sendErr != nil {
+		errorMessage := sendErr.Error()
+		reply.Error = &errorMessage
+	}
+
+	return reply
+}
+
+func NewReveal(messageId string, userId string) *model.Reveal {
+	return &model.Reveal{
+		Id:        uuid.New().String(),
+		MessageId: messageId,
+		UserId:    userId,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// SaveMessageWithOutbox stores the message and the Slack posts to deliver in a
+// single transaction, so that no message is lost once it has been saved.
+func (r *Repository) SaveMessageWithOutbox(ctx context.Context, message *model.SMS, entries []*model.OutboxEntry) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO Messages(id, provider, from_number, to_number, body, received_at) VALUES(?,?,?,?,?,?)",
+		message.Id,
+		message.Provider,
+		message.From,
+		message.To,
+		message.Body,
+		message.ReceivedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO OutboxEntries(id, message_id, kind, recipient, user_ids, forwarding_request_ids, attempts, next_attempt_at, created_at) VALUES(?,?,?,?,?,?,?,?,?)",
+			entry.Id,
+			entry.MessageId,
+			entry.Kind,
+			entry.Recipient,
+			strings.Join(entry.UserIds, ","),
+			strings.Join(entry.ForwardingRequestIds, ","),
+			entry.Attempts,
+			entry.NextAttemptAt,
+			entry.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetDueOutboxEntries returns the pending entries whose next attempt is due.
+func (r *Repository) GetDueOutboxEntries(ctx context.Context, limit int) ([]*model.OutboxEntry, error) {
+	q := "SELECT " + outboxEntryColumns + "\n  FROM OutboxEntries\n WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?\n ORDER BY next_attempt_at\n LIMIT ?"
+
+	return r.queryOutboxEntries(ctx, q, time.Now().UTC(), limit)
+}
+
+// GetMessageOutboxEntries returns the posts queued for the message, whatever
+// their state.
+func (r *Repository) GetMessageOutboxEntries(ctx context.Context, messageId string) ([]*model.OutboxEntry, error) {
+	q := "SELECT " + outboxEntryColumns + "\n  FROM OutboxEntries\n WHERE message_id = ?\n ORDER BY created_at"
+
+	return r.queryOutboxEntries(ctx, q, messageId)
+}
+
+const outboxEntryColumns = "id, message_id, kind, recipient, user_ids, forwarding_request_ids, attempts, next_attempt_at, created_at, sent_at, failed_at, last_error"
+
+func (r *Repository) queryOutboxEntries(ctx context.Context, q string, args ...interface{}) ([]*model.OutboxEntry, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*model.OutboxEntry
+
+	for rows.Next() {
+		result := model.OutboxEntry{}
+		var userIds, requestIds string
+
+		err = rows.Scan(
+			&result.Id,
+			&result.MessageId,
+			&result.Kind,
+			&result.Recipient,
+			&userIds,
+			&requestIds,
+			&result.Attempts,
+			&result.NextAttemptAt,
+			&result.CreatedAt,
+			&result.SentAt,
+			&result.FailedAt,
+			&result.LastError,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.UserIds = splitIds(userIds)
+		result.ForwardingRequestIds = splitIds(requestIds)
+
+		results = append(results, &result)
+	}
+
+	return results, nil
+}
+
+// ClaimOutboxEntry postpones the next attempt of the entry to leaseUntil so
+// that other workers skip it while it is being delivered. It returns false
+// when another worker claimed the entry first.
+func (r *Repository) ClaimOutboxEntry(ctx context.Context, entry *model.OutboxEntry, leaseUntil time.Time) (bool, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE OutboxEntries SET next_attempt_at = ? WHERE id = ? AND next_attempt_at = ? AND sent_at IS NULL AND failed_at IS NULL",
+		leaseUntil,
+		entry.Id,
+		entry.NextAttemptAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (r *Repository) MarkOutboxEntrySent(ctx context.Context, entryId string) error {
 	_, err := r.db.ExecContext(
 		ctx,
-		"UPDATE ForwardingRequests SET expires_at = ? WHERE id = ?",
+		"UPDATE OutboxEntries SET sent_at = ?, attempts = attempts + 1 WHERE id = ?",
 		time.Now().UTC(),
-		requestId,
+		entryId,
+	)
+
+	return err
+}
+
+func (r *Repository) RescheduleOutboxEntry(ctx context.Context, entryId string, nextAttemptAt time.Time, lastError string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE OutboxEntries SET next_attempt_at = ?, attempts = attempts + 1, last_error = ? WHERE id = ?",
+		nextAttemptAt,
+		lastError,
+		entryId,
+	)
+
+	return err
+}
+
+func (r *Repository) AbandonOutboxEntry(ctx context.Context, entryId string, lastError string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE OutboxEntries SET failed_at = ?, attempts = attempts + 1, last_error = ? WHERE id = ?",
+		time.Now().UTC(),
+		lastError,
+		entryId,
 	)
 
 	return err
 }
 
+func splitIds(ids string) []string {
+	if ids == "" {
+		return nil
+	}
+
+	return strings.Split(ids, ",")
+}
+
+func NewOutboxEntry(messageId string, kind string, recipient string) *model.OutboxEntry {
+	now := time.Now().UTC()
+
+	return &model.OutboxEntry{
+		Id:            uuid.New().String(),
+		MessageId:     messageId,
+		Kind:          kind,
+		Recipient:     recipient,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+func NewExtension(requestId string, duration int, requestedBy string) *model.Extension {
+	return &model.Extension{
+		Id:                  uuid.New().String(),
+		ForwardingRequestId: requestId,
+		Duration:            duration,
+		RequestedBy:         requestedBy,
+		CreatedAt:           time.Now().UTC(),
+	}
+}
+
+func NewMessage(message model.SMS) *model.SMS {
+	message.Id = uuid.New().String()
+	message.ReceivedAt = time.Now().UTC()
+
+	return &message
+}
+
+// NewScheduledForwardingRequest creates a request for a window starting in the
+// future.
+func NewScheduledForwardingRequest(requesterId string, requesterName string, startsAt time.Time, duration int) *model.ForwardingRequest {
+	request := NewForwardingRequest(requesterId, requesterName, duration)
+	startsAt = startsAt.UTC()
+	request.StartsAt = &startsAt
+
+	return request
+}
+
+// NewOccurrenceForwardingRequest creates the request of an occurrence of a
+// recurring schedule. Its id only depends on the schedule and the start, so
+// saving the same occurrence twice fails with DuplicateEntry.
+func NewOccurrenceForwardingRequest(schedule *model.RecurringSchedule, startsAt time.Time, duration int) *model.ForwardingRequest {
+	request := NewScheduledForwardingRequest(schedule.RequesterId, schedule.RequesterName, startsAt, duration)
+	request.Id = uuid.NewSHA1(uuid.NameSpaceURL, []byte("schedule:"+schedule.Id+"/"+request.StartsAt.Format(time.RFC3339))).String()
+	request.ScheduleId = schedule.Id
+	request.Number = schedule.Number
+
+	return request
+}
+
 func NewForwardingRequest(requesterId string, requesterName string, duration int) *model.ForwardingRequest {
 	return &model.ForwardingRequest{
 		Id:            uuid.New().String(),
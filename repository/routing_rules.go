@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"github.com/CedricFinance/phone_operator/model"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+const routingRuleColumns = "id, sender_pattern, body_pattern, number, user_ids, channel, created_by, created_at"
+
+func (r *Repository) SaveRoutingRule(ctx context.Context, rule *model.RoutingRule) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO RoutingRules(id, sender_pattern, body_pattern, number, user_ids, channel, created_by, created_at) VALUES(?,?,?,?,?,?,?,?)",
+		rule.Id,
+		rule.SenderPattern,
+		rule.BodyPattern,
+		rule.Number,
+		strings.Join(rule.UserIds, ","),
+		rule.Channel,
+		rule.CreatedBy,
+		rule.CreatedAt,
+	)
+
+	return err
+}
+
+// GetRoutingRules returns the rules which haven't been deleted, in the order
+// they must be evaluated.
+func (r *Repository) GetRoutingRules(ctx context.Context) ([]*model.RoutingRule, error) {
+	q := "SELECT " + routingRuleColumns + "\n  FROM RoutingRules\n WHERE deleted_at IS NULL\n ORDER BY created_at"
+
+	rows, err := r.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*model.RoutingRule
+
+	for rows.Next() {
+		result := model.RoutingRule{}
+		var userIds string
+
+		err = rows.Scan(
+			&result.Id,
+			&result.SenderPattern,
+			&result.BodyPattern,
+			&result.Number,
+			&userIds,
+			&result.Channel,
+			&result.CreatedBy,
+			&result.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.UserIds = splitIds(userIds)
+
+		results = append(results, &result)
+	}
+
+	return results, rows.Err()
+}
+
+func (r *Repository) DeleteRoutingRule(ctx context.Context, ruleId string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE RoutingRules SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL",
+		time.Now().UTC(),
+		ruleId,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return NotFound{ID: ruleId, Type: RoutingRuleType}
+	}
+
+	return nil
+}
+
+func NewRoutingRule(senderPattern string, bodyPattern string, number string, userIds []string, channel string, createdBy string) *model.RoutingRule {
+	return &model.RoutingRule{
+		Id:            uuid.New().String(),
+		SenderPattern: senderPattern,
+		BodyPattern:   bodyPattern,
+		Number:        number,
+		UserIds:       userIds,
+		Channel:       channel,
+		CreatedBy:     createdBy,
+		CreatedAt:     time.Now().UTC(),
+	}
+}
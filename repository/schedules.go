@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"github.com/CedricFinance/phone_operator/model"
+	"github.com/google/uuid"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const scheduleColumns = "id, requester_id, requester_name, number, weekdays, start_time, end_time, timezone, created_at, approved_at, refused_at, answered_by, deleted_at, materialized_until"
+
+func (r *Repository) SaveRecurringSchedule(ctx context.Context, schedule *model.RecurringSchedule) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO RecurringSchedules(id, requester_id, requester_name, number, weekdays, start_time, end_time, timezone, created_at, materialized_until) VALUES(?,?,?,?,?,?,?,?,?,?)",
+		schedule.Id,
+		schedule.RequesterId,
+		schedule.RequesterName,
+		schedule.Number,
+		formatWeekdays(schedule.Weekdays),
+		schedule.StartTime,
+		schedule.EndTime,
+		schedule.Timezone,
+		schedule.CreatedAt,
+		schedule.MaterializedUntil,
+	)
+
+	return err
+}
+
+func (r *Repository) GetRecurringSchedule(ctx context.Context, scheduleId string) (*model.RecurringSchedule, error) {
+	q := "SELECT " + scheduleColumns + " FROM RecurringSchedules WHERE id = ? LIMIT 1"
+
+	results, err := r.querySchedules(ctx, q, scheduleId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, NotFound{ID: scheduleId, Type: ScheduleType}
+	}
+
+	return results[0], nil
+}
+
+// GetRecurringSchedules returns the schedules of the requester which haven't
+// been deleted.
+func (r *Repository) GetRecurringSchedules(ctx context.Context, requesterId string) ([]*model.RecurringSchedule, error) {
+	q := "SELECT " + scheduleColumns + "\n  FROM RecurringSchedules\n WHERE requester_id = ? AND deleted_at IS NULL\n ORDER BY created_at"
+
+	return r.querySchedules(ctx, q, requesterId)
+}
+
+// GetEnabledRecurringSchedules returns the schedules which must still create
+// forwarding requests.
+func (r *Repository) GetEnabledRecurringSchedules(ctx context.Context) ([]*model.RecurringSchedule, error) {
+	q := "SELECT " + scheduleColumns + "\n  FROM RecurringSchedules\n WHERE deleted_at IS NULL AND refused_at IS NULL"
+
+	return r.querySchedules(ctx, q)
+}
+
+func (r *Repository) AcceptRecurringSchedule(ctx context.Context, scheduleId string, answeredBy string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE RecurringSchedules SET approved_at = ?, answered_by = ? WHERE id = ? AND approved_at IS NULL AND refused_at IS NULL",
+		time.Now().UTC(),
+		answeredBy,
+		scheduleId,
+	)
+
+	return err
+}
+
+func (r *Repository) RefuseRecurringSchedule(ctx context.Context, scheduleId string, answeredBy string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE RecurringSchedules SET refused_at = ?, answered_by = ? WHERE id = ? AND approved_at IS NULL AND refused_at IS NULL",
+		time.Now().UTC(),
+		answeredBy,
+		scheduleId,
+	)
+
+	return err
+}
+
+func (r *Repository) DeleteRecurringSchedule(ctx context.Context, scheduleId string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE RecurringSchedules SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL",
+		time.Now().UTC(),
+		scheduleId,
+	)
+
+	return err
+}
+
+// AdvanceRecurringSchedule moves the materialization horizon of the schedule
+// to until. It returns false when another instance moved it first.
+func (r *Repository) AdvanceRecurringSchedule(ctx context.Context, schedule *model.RecurringSchedule, until time.Time) (bool, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE RecurringSchedules SET materialized_until = ? WHERE id = ? AND materialized_until = ?",
+		until,
+		schedule.Id,
+		schedule.MaterializedUntil,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (r *Repository) querySchedules(ctx context.Context, q string, args ...interface{}) ([]*model.RecurringSchedule, error) {
+	rows, err := r.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*model.RecurringSchedule
+
+	for rows.Next() {
+		result := model.RecurringSchedule{}
+		var weekdays string
+
+		err = rows.Scan(
+			&result.Id,
+			&result.RequesterId,
+			&result.RequesterName,
+			&result.Number,
+			&weekdays,
+			&result.StartTime,
+			&result.EndTime,
+			&result.Timezone,
+			&result.CreatedAt,
+			&result.ApprovedAt,
+			&result.RefusedAt,
+			&result.AnsweredBy,
+			&result.DeletedAt,
+			&result.MaterializedUntil,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Weekdays = parseWeekdays(weekdays)
+
+		results = append(results, &result)
+	}
+
+	return results, rows.Err()
+}
+
+func formatWeekdays(weekdays []time.Weekday) string {
+	values := make([]string, len(weekdays))
+	for i, weekday := range weekdays {
+		values[i] = strconv.Itoa(int(weekday))
+	}
+
+	return strings.Join(values, ",")
+}
+
+func parseWeekdays(value string) []time.Weekday {
+	var weekdays []time.Weekday
+	for _, id := range splitIds(value) {
+		weekday, err := strconv.Atoi(id)
+		if err == nil {
+			weekdays = append(weekdays, time.Weekday(weekday))
+		}
+	}
+
+	return weekdays
+}
+
+func NewRecurringSchedule(requesterId string, requesterName string, weekdays []time.Weekday, startTime string, endTime string, timezone string) *model.RecurringSchedule {
+	now := time.Now().UTC()
+
+	return &model.RecurringSchedule{
+		Id:                uuid.New().String(),
+		RequesterId:       requesterId,
+		RequesterName:     requesterName,
+		Weekdays:          weekdays,
+		StartTime:         startTime,
+		EndTime:           endTime,
+		Timezone:          timezone,
+		CreatedAt:         now,
+		MaterializedUntil: now,
+	}
+}
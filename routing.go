@@ -0,0 +1,343 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/CedricFinance/phone_operator/repository"
+    "github.com/slack-go/slack"
+    "log"
+    "net/http"
+    "regexp"
+    "strings"
+)
+
+const rulesHelp = "Use `/sms rules add from=[sender] body=[regexp] number=[number] to=[users and channel]`, e.g. `/sms rules add from=BANK* body=\"code \\d+\" to=@alice,#finance`. `from` accepts `*` wildcards, at least one of `from`, `body` or `number` is required and the recipients must be mentioned."
+
+const rulesNotAllowed = ":no_entry_sign: You are not allowed to manage the routing rules."
+
+var (
+    userMentionPattern    = regexp.MustCompile(`^<@([UW][A-Z0-9]+)(?:\|[^>]*)?>$`)
+    channelMentionPattern = regexp.MustCompile(`^<#([CG][A-Z0-9]+)(?:\|[^>]*)?>$`)
+)
+
+// ruleMatches tells whether the SMS matches every pattern of the rule. Empty
+// patterns match any SMS.
+func ruleMatches(rule *model.RoutingRule, sms model.SMS) (bool, error) {
+    if rule.Number != "" && normalizeNumber(rule.Number) != normalizeNumber(sms.To) {
+        return false, nil
+    }
+
+    if rule.SenderPattern != "" && !senderPatternRegexp(rule.SenderPattern).MatchString(sms.From) {
+        return false, nil
+    }
+
+    if rule.BodyPattern != "" {
+        body, err := regexp.Compile(rule.BodyPattern)
+        if err != nil {
+            return false, fmt.Errorf("invalid body pattern %q: %w", rule.BodyPattern, err)
+        }
+
+        if !body.MatchString(sms.Body) {
+            return false, nil
+        }
+    }
+
+    return true, nil
+}
+
+// senderPatternRegexp turns a sender pattern such as `BANK*` into a case
+// insensitive regular expression.
+func senderPatternRegexp(pattern string) *regexp.Regexp {
+    parts := strings.Split(pattern, "*")
+    for i, part := range parts {
+        parts[i] = regexp.QuoteMeta(part)
+    }
+
+    return regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+}
+
+// matchRoutingRule returns the first rule matching the SMS, nil when the SMS
+// must be sent to the forwarders.
+func matchRoutingRule(rules []*model.RoutingRule, sms model.SMS) *model.RoutingRule {
+    for _, rule := range rules {
+        ok, err := ruleMatches(rule, sms)
+        if err != nil {
+            log.Printf("Ignoring routing rule %s: %v", rule.Id, err)
+            continue
+        }
+
+        if ok {
+            return rule
+        }
+    }
+
+    return nil
+}
+
+// routedOutboxEntries queues the posts of a SMS matched by the rule. The
+// rule's channel replaces the channel of the number when it is set.
+func routedOutboxEntries(message *model.SMS, rule *model.RoutingRule, defaultChannel string) []*model.OutboxEntry {
+    var entries []*model.OutboxEntry
+    for _, userId := range rule.UserIds {
+        entries = append(entries, repository.NewOutboxEntry(message.Id, model.OutboxKindUser, userId))
+    }
+
+    channel := rule.Channel
+    if channel == "" {
+        channel = defaultChannel
+    }
+
+    entry := repository.NewOutboxEntry(message.Id, model.OutboxKindChannel, channel)
+    entry.UserIds = rule.UserIds
+    entries = append(entries, entry)
+
+    return entries
+}
+
+// parseRoutingRule parses the `key=value` arguments of `/sms rules add`.
+// Values containing spaces must be quoted.
+func parseRoutingRule(args string) (*model.RoutingRule, error) {
+    rule := &model.RoutingRule{}
+
+    tokens, err := splitArguments(args)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, token := range tokens {
+        keyValue := strings.SplitN(token, "=", 2)
+        if len(keyValue) != 2 {
+            return nil, fmt.Errorf("I don't understand %q. %s", token, rulesHelp)
+        }
+
+        key, value := strings.ToLower(keyValue[0]), keyValue[1]
+        switch key {
+        case "from":
+            if strings.Trim(value, "*") == "" {
+                return nil, fmt.Errorf("%q would match every sender. %s", value, rulesHelp)
+            }
+            rule.SenderPattern = value
+        case "body":
+            body, err := regexp.Compile(value)
+            if err != nil {
+                return nil, fmt.Errorf("%q is not a valid regular expression: %s", value, err)
+            }
+            if body.MatchString("") {
+                return nil, fmt.Errorf("%q would match every text. %s", value, rulesHelp)
+            }
+            rule.BodyPattern = value
+        case "number":
+            phoneNumber := lookupNumber(value)
+            if phoneNumber == nil {
+                return nil, fmt.Errorf("%q is not a registered number.", value)
+            }
+            rule.Number = phoneNumber.Number
+        case "to":
+            for _, target := range strings.Split(value, ",") {
+                if result := userMentionPattern.FindStringSubmatch(target); result != nil {
+                    rule.UserIds = append(rule.UserIds, result[1])
+                } else if result := channelMentionPattern.FindStringSubmatch(target); result != nil {
+                    if rule.Channel != "" {
+                        return nil, fmt.Errorf("A rule can only post to one channel.")
+                    }
+                    rule.Channel = result[1]
+                } else {
+                    return nil, fmt.Errorf("%q is neither a user nor a channel. %s", target, rulesHelp)
+                }
+            }
+        default:
+            return nil, fmt.Errorf("I don't understand %q. %s", key, rulesHelp)
+        }
+    }
+
+    if rule.SenderPattern == "" && rule.BodyPattern == "" && rule.Number == "" {
+        return nil, fmt.Errorf("The rule would match every text. %s", rulesHelp)
+    }
+
+    if len(rule.UserIds) == 0 && rule.Channel == "" {
+        return nil, fmt.Errorf("Who should receive the texts? %s", rulesHelp)
+    }
+
+    return rule, nil
+}
+
+// splitArguments splits args on spaces, except inside double quotes.
+func splitArguments(args string) ([]string, error) {
+    var tokens []string
+    var token strings.Builder
+    inQuotes := false
+
+    for _, c := range args {
+        switch {
+        case c == '"':
+            inQuotes = !inQuotes
+        case c == ' ' && !inQuotes:
+            if token.Len() > 0 {
+                tokens = append(tokens, token.String())
+                token.Reset()
+            }
+        default:
+            token.WriteRune(c)
+        }
+    }
+
+    if inQuotes {
+        return nil, fmt.Errorf("A quote is missing. %s", rulesHelp)
+    }
+
+    if token.Len() > 0 {
+        tokens = append(tokens, token.String())
+    }
+
+    return tokens, nil
+}
+
+func describeRoutingRule(rule *model.RoutingRule) string {
+    var conditions []string
+    if rule.SenderPattern != "" {
+        conditions = append(conditions, fmt.Sprintf("from `%s`", rule.SenderPattern))
+    }
+    if rule.BodyPattern != "" {
+        conditions = append(conditions, fmt.Sprintf("body matching `%s`", rule.BodyPattern))
+    }
+    if rule.Number != "" {
+        conditions = append(conditions, fmt.Sprintf("sent to `%s`", rule.Number))
+    }
+
+    var targets []string
+    for _, userId := range rule.UserIds {
+        targets = append(targets, fmt.Sprintf("<@%s>", userId))
+    }
+    if rule.Channel != "" {
+        targets = append(targets, fmt.Sprintf("<#%s>", rule.Channel))
+    }
+
+    return fmt.Sprintf("texts %s go to %s", strings.Join(conditions, ", "), strings.Join(targets, ", "))
+}
+
+// canManageRules tells whether the user can add or delete the rules of the
+// number. As rules reroute texts without any approval, they can only be
+// managed by explicitly configured admins.
+func canManageRules(ctx context.Context, userId string, number string) (bool, error) {
+    if !hasConfiguredAdmins(number) {
+        return false, nil
+    }
+
+    return isAdmin(ctx, userId, number)
+}
+
+// rulesCommand handles `/sms rules`. Only the admins of the rule's number can
+// add or delete it.
+func rulesCommand(ctx context.Context, w http.ResponseWriter, command slack.SlashCommand, args string) {
+    parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+
+    switch parts[0] {
+    case "", "list":
+        listRoutingRules(ctx, w)
+        return
+    case "add", "delete":
+    default:
+        fmt.Fprint(w, rulesHelp)
+        return
+    }
+
+    if len(parts) < 2 {
+        fmt.Fprint(w, rulesHelp)
+        return
+    }
+
+    if parts[0] == "delete" {
+        deleteRoutingRule(ctx, w, command.UserID, strings.TrimSpace(parts[1]))
+        return
+    }
+
+    rule, err := parseRoutingRule(parts[1])
+    if err != nil {
+        fmt.Fprint(w, err.Error())
+        return
+    }
+
+    admin, err := canManageRules(ctx, command.UserID, rule.Number)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+    if !admin {
+        fmt.Fprint(w, rulesNotAllowed)
+        return
+    }
+
+    rule = repository.NewRoutingRule(rule.SenderPattern, rule.BodyPattern, rule.Number, rule.UserIds, rule.Channel, command.UserID)
+    err = repo.SaveRoutingRule(ctx, rule)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    fmt.Fprintf(w, "Rule `%s` added: %s", shortId(rule.Id), describeRoutingRule(rule))
+}
+
+func listRoutingRules(ctx context.Context, w http.ResponseWriter) {
+    rules, err := repo.GetRoutingRules(ctx)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    if len(rules) == 0 {
+        fmt.Fprintf(w, "There is no routing rule, texts go to the forwarders of the number. %s", rulesHelp)
+        return
+    }
+
+    lines := []string{"Routing rules, the first matching rule applies:"}
+    for _, rule := range rules {
+        lines = append(lines, fmt.Sprintf("- `%s` %s", shortId(rule.Id), describeRoutingRule(rule)))
+    }
+
+    fmt.Fprint(w, strings.Join(lines, "\n"))
+}
+
+func deleteRoutingRule(ctx context.Context, w http.ResponseWriter, userId string, id string) {
+    rules, err := repo.GetRoutingRules(ctx)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    var matching []*model.RoutingRule
+    for _, rule := range rules {
+        if strings.HasPrefix(rule.Id, id) {
+            matching = append(matching, rule)
+        }
+    }
+
+    if len(matching) == 0 {
+        fmt.Fprintf(w, "There is no rule with id %q. Use `/sms rules` to find its id.", id)
+        return
+    }
+
+    if len(matching) > 1 {
+        fmt.Fprintf(w, "Several rules have an id starting with %q, please give a longer id.", id)
+        return
+    }
+
+    rule := matching[0]
+    admin, err := canManageRules(ctx, userId, rule.Number)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+    if !admin {
+        fmt.Fprint(w, rulesNotAllowed)
+        return
+    }
+
+    err = repo.DeleteRoutingRule(ctx, rule.Id)
+    if err != nil {
+        fmt.Fprintf(w, "Oops. Something went wrong :sad:. Error: %s", err)
+        return
+    }
+
+    fmt.Fprintf(w, "Rule `%s` deleted", shortId(rule.Id))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+    "context"
+    "database/sql/driver"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/slack-go/slack"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestMatchRoutingRule(t *testing.T) {
+    rules := []*model.RoutingRule{
+        {Id: "invalid", BodyPattern: "("},
+        {Id: "bank", SenderPattern: "BANK*", BodyPattern: `code \d+`},
+        {Id: "carrier", SenderPattern: "+3361*", Number: "+33698765432"},
+        {Id: "bank-line", Number: "+33612345678"},
+    }
+
+    tests := []struct {
+        sms      model.SMS
+        expected string
+    }{
+        {model.SMS{From: "BANKXYZ", To: "+33698765432", Body: "Your code 123456"}, "bank"},
+        {model.SMS{From: "bankxyz", To: "+33698765432", Body: "Your code 123456"}, "bank"},
+        {model.SMS{From: "BANKXYZ", To: "+33698765432", Body: "Your balance is low"}, ""},
+        {model.SMS{From: "MYBANK", To: "+33698765432", Body: "Your code 123456"}, ""},
+        {model.SMS{From: "+33611111111", To: "33698765432", Body: "Your plan changed"}, "carrier"},
+        {model.SMS{From: "+33611111111", To: "+33700000000", Body: "Your plan changed"}, ""},
+        {model.SMS{From: "+33611111111", To: "+33612345678", Body: "Hello"}, "bank-line"},
+    }
+
+    for _, test := range tests {
+        rule := matchRoutingRule(rules, test.sms)
+
+        id := ""
+        if rule != nil {
+            id = rule.Id
+        }
+
+        if id != test.expected {
+            t.Errorf("invalid rule for %+v, expected: %q, got: %q", test.sms, test.expected, id)
+        }
+    }
+}
+
+func TestRoutedOutboxEntries(t *testing.T) {
+    message := &model.SMS{Id: "message"}
+
+    entries := routedOutboxEntries(message, &model.RoutingRule{UserIds: []string{"UFINANCE"}}, "CDEFAULT")
+    if len(entries) != 2 {
+        t.Fatalf("expected 2 entries, got: %d", len(entries))
+    }
+
+    if entries[0].Kind != model.OutboxKindUser || entries[0].Recipient != "UFINANCE" {
+        t.Errorf("expected a post to UFINANCE, got: %+v", entries[0])
+    }
+
+    if entries[1].Kind != model.OutboxKindChannel || entries[1].Recipient != "CDEFAULT" {
+        t.Errorf("expected a post to the default channel, got: %+v", entries[1])
+    }
+
+    entries = routedOutboxEntries(message, &model.RoutingRule{Channel: "CFINANCE"}, "CDEFAULT")
+    if len(entries) != 1 || entries[0].Recipient != "CFINANCE" {
+        t.Errorf("expected a single post to the rule's channel, got: %+v", entries)
+    }
+}
+
+func TestParseRoutingRule(t *testing.T) {
+    config.Numbers = []PhoneNumber{{Number: "+33612345678", Alias: "bank-line"}}
+    defer func() { config.Numbers = nil }()
+
+    rule, err := parseRoutingRule(`from=BANK* body="code \d+" number=bank-line to=<@U0ALICE|alice>,<@U0BOB>,<#C0FINANCE|finance>`)
+    if err != nil {
+        t.Fatalf("failed to parse the rule: %s", err)
+    }
+
+    expected := &model.RoutingRule{
+        SenderPattern: "BANK*",
+        BodyPattern:   `code \d+`,
+        Number:        "+33612345678",
+        UserIds:       []string{"U0ALICE", "U0BOB"},
+        Channel:       "C0FINANCE",
+    }
+    if !reflect.DeepEqual(rule, expected) {
+        t.Errorf("invalid rule, expected: %+v, got: %+v", expected, rule)
+    }
+}
+
+func TestParseRoutingRule_Invalid(t *testing.T) {
+    tests := []string{
+        "",
+        "to=<@U0ALICE>",
+        "from=BANK*",
+        "from=BANK* to=alice",
+        "body=( to=<@U0ALICE>",
+        "number=unknown to=<@U0ALICE>",
+        `body="code to=<@U0ALICE>`,
+        "from=BANK* to=<#C0ONE>,<#C0TWO>",
+        "sender=BANK* to=<@U0ALICE>",
+        "from=* to=<@U0ALICE>",
+        "from=** body=code to=<@U0ALICE>",
+        // These expressions match every text
+        "body=.* to=<@U0ALICE>",
+        "body= to=<@U0ALICE>",
+        "body=^ to=<@U0ALICE>",
+        "body=(code)? to=<@U0ALICE>",
+    }
+
+    for _, input := range tests {
+        t.Run(input, func(t *testing.T) {
+            _, err := parseRoutingRule(input)
+            if err == nil {
+                t.Errorf("expected an error for %q", input)
+            }
+        })
+    }
+}
+
+func TestRulesCommand_Admins(t *testing.T) {
+    defer func() {
+        config.Slack.Admins = nil
+        config.Numbers = nil
+    }()
+
+    tests := []struct {
+        name    string
+        admins  []string
+        userId  string
+        args    string
+        allowed bool
+    }{
+        {"admin of every number", []string{"UADMIN", "UBOTH"}, "UBOTH", "from=BANK* to=<@U0ALICE>", true},
+        {"not an admin", []string{"UADMIN"}, "UOTHER", "from=BANK* to=<@U0ALICE>", false},
+        // Everyone can answer the requests, but not reroute the texts
+        {"no admin configured", nil, "UANYONE", "from=BANK* to=<@U0ALICE>", false},
+        {"number admin of the number", []string{"UADMIN"}, "UFINANCE", "number=bank-line to=<@U0ALICE>", true},
+        {"slack admin of another number", []string{"UADMIN"}, "UADMIN", "number=bank-line to=<@U0ALICE>", false},
+        // Rules without a number also apply to the number of UFINANCE
+        {"slack admin", []string{"UADMIN"}, "UADMIN", "from=BANK* to=<@U0ALICE>", false},
+        {"number admin", []string{"UADMIN"}, "UFINANCE", "from=BANK* to=<@U0ALICE>", false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            config.Slack.Admins = test.admins
+            config.Numbers = []PhoneNumber{{Number: "+33612345678", Alias: "bank-line", Admins: []string{"UFINANCE", "UBOTH"}}}
+
+            db := newFakeRepository(t)
+
+            w := httptest.NewRecorder()
+            rulesCommand(context.Background(), w, slack.SlashCommand{UserID: test.userId}, "add "+test.args)
+
+            if added := len(db.Executed("INSERT INTO RoutingRules")) == 1; added != test.allowed {
+                t.Errorf("expected added to be %t, got: %t (%q)", test.allowed, added, w.Body.String())
+            }
+        })
+    }
+}
+
+func TestRulesCommand_Delete(t *testing.T) {
+    config.Slack.Admins = []string{"UADMIN"}
+    config.Numbers = []PhoneNumber{{Number: "+33612345678", Admins: []string{"UFINANCE"}}}
+    defer func() {
+        config.Slack.Admins = nil
+        config.Numbers = nil
+    }()
+
+    for userId, allowed := range map[string]bool{"UFINANCE": true, "UADMIN": false} {
+        db := newFakeRepository(t)
+        db.OnQuery("FROM RoutingRules", []driver.Value{"RULE1234", "", "", "+33612345678", "U0ALICE", "", "UFINANCE", time.Now().UTC()})
+
+        w := httptest.NewRecorder()
+        rulesCommand(context.Background(), w, slack.SlashCommand{UserID: userId}, "delete RULE")
+
+        if deleted := len(db.Executed("UPDATE RoutingRules SET deleted_at")) == 1; deleted != allowed {
+            t.Errorf("%s: expected deleted to be %t, got: %t (%q)", userId, allowed, deleted, w.Body.String())
+        }
+    }
+}
+
+func TestRulesCommand_DeleteAmbiguous(t *testing.T) {
+    config.Slack.Admins = []string{"UADMIN"}
+    defer func() { config.Slack.Admins = nil }()
+
+    db := newFakeRepository(t)
+    db.OnQuery(
+        "FROM RoutingRules",
+        []driver.Value{"RULE1234", "BANK*", "", "", "U0ALICE", "", "UADMIN", time.Now().UTC()},
+        []driver.Value{"RULE5678", "SHOP*", "", "", "U0ALICE", "", "UADMIN", time.Now().UTC()},
+    )
+
+    w := httptest.NewRecorder()
+    rulesCommand(context.Background(), w, slack.SlashCommand{UserID: "UADMIN"}, "delete RULE")
+
+    if deleted := db.Executed("UPDATE RoutingRules SET deleted_at"); len(deleted) != 0 {
+        t.Errorf("expected no rule to be deleted, got: %v", deleted)
+    }
+
+    if !strings.Contains(w.Body.String(), "Several rules") {
+        t.Errorf("expected the admin to be asked for a longer id, got: %q", w.Body.String())
+    }
+
+    w = httptest.NewRecorder()
+    rulesCommand(context.Background(), w, slack.SlashCommand{UserID: "UADMIN"}, "delete RULE5")
+
+    if deleted := db.Executed("UPDATE RoutingRules SET deleted_at"); len(deleted) != 1 || deleted[0].Args[1] != "RULE5678" {
+        t.Errorf("expected RULE5678 to be deleted, got: %v", deleted)
+    }
+}
+
+func TestRoutedOutboxEntries_Deliveries(t *testing.T) {
+    db := newFakeRepository(t)
+    newFakeSlack(t)
+    db.OnQuery("FROM Messages WHERE id = ?", messageRow(model.SMS{Id: "MESSAGE", From: "BANK", Body: "code 1234", ReceivedAt: time.Now().UTC()}))
+
+    entries := routedOutboxEntries(&model.SMS{Id: "MESSAGE"}, &model.RoutingRule{UserIds: []string{"U0ALICE"}}, "CDEFAULT")
+    err := deliverOutboxEntry(context.Background(), entries[0])
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    saved := db.Executed("INSERT INTO Deliveries")
+    if len(saved) != 1 || saved[0].Args[3] != "U0ALICE" {
+        t.Errorf("expected the routed text to be recorded as delivered to U0ALICE, got: %v", saved)
+    }
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/model"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+const (
+    twilioAPIURL = "https://api.twilio.com"
+    nexmoAPIURL  = "https://rest.nexmo.com"
+)
+
+// SMSSender sends texts through a provider.
+type SMSSender interface {
+    SendSMS(ctx context.Context, from string, to string, body string) error
+}
+
+// smsSenders are the configured senders, indexed by provider. Replies are
+// sent through the provider which delivered the SMS.
+var smsSenders = map[string]SMSSender{}
+
+func newSMSSenders() map[string]SMSSender {
+    senders := make(map[string]SMSSender)
+
+    if config.Twilio.AccountSid != "" {
+        senders[model.ProviderTwilio] = NewTwilioSender(config.Twilio.AccountSid, config.Twilio.AuthToken)
+    }
+
+    if config.Nexmo.ApiKey != "" {
+        senders[model.ProviderNexmo] = NewNexmoSender(config.Nexmo.ApiKey, config.Nexmo.ApiSecret)
+    }
+
+    return senders
+}
+
+// TwilioSender sends texts with the Twilio Messages API.
+// See https://www.twilio.com/docs/sms/api/message-resource#create-a-message-resource
+type TwilioSender struct {
+    AccountSid string
+    AuthToken  string
+    BaseURL    string
+    Client     *http.Client
+}
+
+func NewTwilioSender(accountSid string, authToken string) *TwilioSender {
+    return &TwilioSender{
+        AccountSid: accountSid,
+        AuthToken:  authToken,
+        BaseURL:    twilioAPIURL,
+        Client:     &http.Client{Timeout: 10 * time.Second},
+    }
+}
+
+func (s *TwilioSender) SendSMS(ctx context.Context, from string, to string, body string) error {
+    form := url.Values{}
+    form.Set("From", from)
+    form.Set("To", to)
+    form.Set("Body", body)
+
+    endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", s.BaseURL, url.PathEscape(s.AccountSid))
+    req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth(s.AccountSid, s.AuthToken)
+
+    res, err := s.Client.Do(req)
+    if err != nil {
+        return fmt.Errorf("failed to send SMS with Twilio: %w", err)
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode >= 300 {
+        var twilioErr struct {
+            Code    int    `json:"code"`
+            Message string `json:"message"`
+        }
+        responseBody, _ := io.ReadAll(res.Body)
+        if json.Unmarshal(responseBody, &twilioErr) == nil && twilioErr.Message != "" {
+            return fmt.Errorf("failed to send SMS with Twilio: %s (%d)", twilioErr.Message, twilioErr.Code)
+        }
+        return fmt.Errorf("failed to send SMS with Twilio: %s", res.Status)
+    }
+
+    return nil
+}
+
+// NexmoSender sends texts with the Vonage SMS API.
+// See https://developer.vonage.com/en/api/sms#send-an-sms
+type NexmoSender struct {
+    ApiKey    string
+    ApiSecret string
+    BaseURL   string
+    Client    *http.Client
+}
+
+func NewNexmoSender(apiKey string, apiSecret string) *NexmoSender {
+    return &NexmoSender{
+        ApiKey:    apiKey,
+        ApiSecret: apiSecret,
+        BaseURL:   nexmoAPIURL,
+        Client:    &http.Client{Timeout: 10 * time.Second},
+    }
+}
+
+func (s *NexmoSender) SendSMS(ctx context.Context, from string, to string, body string) error {
+    form := url.Values{}
+    form.Set("api_key", s.ApiKey)
+    form.Set("api_secret", s.ApiSecret)
+    // Vonage expects numbers in the E.164 format without the leading +
+    form.Set("from", strings.TrimPrefix(from, "+"))
+    form.Set("to", strings.TrimPrefix(to, "+"))
+    form.Set("text", body)
+    // Unicode messages hold 70 characters instead of 160
+    if !isGSMText(body) {
+        form.Set("type", "unicode")
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "POST", s.BaseURL+"/sms/json", strings.NewReader(form.Encode()))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+    res, err := s.Client.Do(req)
+    if err != nil {
+        return fmt.Errorf("failed to send SMS with Vonage: %w", err)
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode >= 300 {
+        return fmt.Errorf("failed to send SMS with Vonage: %s", res.Status)
+    }
+
+    var response struct {
+        Messages []struct {
+            Status    string `json:"status"`
+            ErrorText string `json:"error-text"`
+        } `json:"messages"`
+    }
+    err = json.NewDecoder(res.Body).Decode(&response)
+    if err != nil {
+        return fmt.Errorf("failed to decode the Vonage response: %w", err)
+    }
+
+    // Long texts are split in several messages, each with its own status
+    for _, message := range response.Messages {
+        if message.Status != "0" {
+            return fmt.Errorf("failed to send SMS with Vonage: %s (status %s)", message.ErrorText, message.Status)
+        }
+    }
+
+    return nil
+}
+
+// gsmCharacters are the characters of the GSM 03.38 alphabet, including its
+// extension table, which Vonage sends without the unicode type.
+const gsmCharacters = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+    "¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà" +
+    "\f^{}\\[~]|€"
+
+// isGSMText tells whether the text can be sent with the GSM alphabet.
+func isGSMText(text string) bool {
+    for _, c := range text {
+        if !strings.ContainsRune(gsmCharacters, c) {
+            return false
+        }
+    }
+
+    return true
+}
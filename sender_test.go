@@ -0,0 +1,269 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/CedricFinance/phone_operator/messages"
+    "github.com/CedricFinance/phone_operator/model"
+    "github.com/slack-go/slack"
+    "github.com/slack-go/slack/slackevents"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestTwilioSender_SendSMS(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+            t.Errorf("invalid path: %s", r.URL.Path)
+        }
+
+        user, password, ok := r.BasicAuth()
+        if !ok || user != "AC123" || password != "token" {
+            t.Errorf("invalid credentials: %q, %q", user, password)
+        }
+
+        r.ParseForm()
+        if r.Form.Get("From") != "+33612345678" || r.Form.Get("To") != "+33698765432" || r.Form.Get("Body") != "STOP" {
+            t.Errorf("invalid form: %v", r.Form)
+        }
+
+        w.WriteHeader(http.StatusCreated)
+    }))
+    defer server.Close()
+
+    sender := NewTwilioSender("AC123", "token")
+    sender.BaseURL = server.URL
+
+    err := sender.SendSMS(context.Background(), "+33612345678", "+33698765432", "STOP")
+    if err != nil {
+        t.Errorf("unexpected error: %s", err)
+    }
+}
+
+func TestTwilioSender_SendSMS_Error(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusBadRequest)
+        fmt.Fprint(w, `{"code": 21211, "message": "The 'To' number is not a valid phone number."}`)
+    }))
+    defer server.Close()
+
+    sender := NewTwilioSender("AC123", "token")
+    sender.BaseURL = server.URL
+
+    err := sender.SendSMS(context.Background(), "+33612345678", "invalid", "STOP")
+    if err == nil {
+        t.Errorf("expected an error")
+    }
+}
+
+func TestNexmoSender_SendSMS(t *testing.T) {
+    status := "0"
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        r.ParseForm()
+        if r.Form.Get("api_key") != "key" || r.Form.Get("api_secret") != "secret" {
+            t.Errorf("invalid credentials: %v", r.Form)
+        }
+
+        if r.Form.Get("from") != "33612345678" || r.Form.Get("to") != "33698765432" || r.Form.Get("text") != "YES" {
+            t.Errorf("invalid form: %v", r.Form)
+        }
+
+        // GSM texts fit 160 characters per message
+        if r.Form.Get("type") != "" {
+            t.Errorf("expected the default type, got: %q", r.Form.Get("type"))
+        }
+
+        fmt.Fprintf(w, `{"message-count": "1", "messages": [{"status": %q, "error-text": "Throttled"}]}`, status)
+    }))
+    defer server.Close()
+
+    sender := NewNexmoSender("key", "secret")
+    sender.BaseURL = server.URL
+
+    err := sender.SendSMS(context.Background(), "+33612345678", "33698765432", "YES")
+    if err != nil {
+        t.Errorf("unexpected error: %s", err)
+    }
+
+    status = "1"
+    err = sender.SendSMS(context.Background(), "+33612345678", "33698765432", "YES")
+    if err == nil {
+        t.Errorf("expected an error for a non zero status")
+    }
+}
+
+func TestReplySMS(t *testing.T) {
+    fake := &FakeSMSSender{}
+    smsSenders = map[string]SMSSender{model.ProviderNexmo: fake}
+    defer func() { smsSenders = map[string]SMSSender{} }()
+
+    // Only the recipients can reply when no admin is configured
+    config.Slack.Admins = nil
+    config.Slack.AdminGroup = ""
+
+    db := newFakeRepository(t)
+    db.OnQuery("FROM OutboxEntries", recipientRow("message", "UALICE"))
+
+    sms := &model.SMS{Id: "message", Provider: model.ProviderNexmo, From: "33698765432", To: "33612345678", Body: "Reply YES to confirm"}
+
+    if err := replySMS(context.Background(), sms, "UBOB", "YES"); err != errReplyNotAllowed {
+        t.Errorf("expected a user who didn't receive the text not to be allowed to reply, got: %v", err)
+    }
+
+    err := replySMS(context.Background(), sms, "UALICE", "YES")
+    if err != nil {
+        t.Fatalf("unexpected error: %s", err)
+    }
+
+    expected := []SentSMS{{From: "33612345678", To: "33698765432", Body: "YES"}}
+    if sent := fake.Sent(); len(sent) != 1 || sent[0] != expected[0] {
+        t.Errorf("invalid sent texts, expected: %v, got: %v", expected, sent)
+    }
+
+    replies := db.Executed("INSERT INTO Replies")
+    if len(replies) != 1 || replies[0].Args[2] != "UALICE" || replies[0].Args[3] != "YES" || replies[0].Args[5] != nil {
+        t.Errorf("expected the reply to be recorded, got: %v", replies)
+    }
+
+    if err := replySMS(context.Background(), sms, "UALICE", ""); err == nil {
+        t.Errorf("expected an error for an empty reply")
+    }
+
+    sms.Provider = model.ProviderTwilio
+    if err := replySMS(context.Background(), sms, "UALICE", "YES"); err == nil {
+        t.Errorf("expected an error for an unconfigured provider")
+    }
+
+    sms.Provider = model.ProviderNexmo
+    fake.Err = errors.New("provider unavailable")
+    if err := replySMS(context.Background(), sms, "UALICE", "YES"); err == nil {
+        t.Errorf("expected the error of the provider")
+    }
+
+    // Failed replies are recorded with their error
+    replies = db.Executed("INSERT INTO Replies")
+    if len(replies) != 2 || replies[1].Args[5] != "provider unavailable" {
+        t.Errorf("expected the failed reply to be recorded, got: %v", replies)
+    }
+}
+
+func TestOpenReplyModal_Recipients(t *testing.T) {
+    // Only the recipients can reply when no admin is configured
+    config.Slack.Admins = nil
+
+    for userId, allowed := range map[string]bool{"UALICE": true, "UBOB": false} {
+        db := newFakeRepository(t)
+        fake := newFakeSlack(t)
+        db.OnQuery("FROM Messages WHERE id = ?", messageRow(model.SMS{Id: "MESSAGE", From: "33698765432", To: "33612345678", Body: "Reply YES to confirm"}))
+        db.OnQuery("FROM OutboxEntries", recipientRow("MESSAGE", "UALICE"))
+
+        message := slack.InteractionCallback{User: slack.User{ID: userId}, TriggerID: "TRIGGER", ResponseURL: fake.URL + "/response"}
+        openReplyModal(context.Background(), message, "MESSAGE")
+
+        if opened := len(fake.Calls("views.open")) == 1; opened != allowed {
+            t.Errorf("%s: expected the reply modal to be opened: %t, got: %t", userId, allowed, opened)
+        }
+        if rejected := len(fake.Calls("response")) == 1; rejected == allowed {
+            t.Errorf("%s: expected the reply to be rejected: %t, got: %t", userId, !allowed, rejected)
+        }
+    }
+}
+
+func TestHandleThreadReply_Recipients(t *testing.T) {
+    fake := &FakeSMSSender{}
+    smsSenders = map[string]SMSSender{model.ProviderNexmo: fake}
+    defer func() { smsSenders = map[string]SMSSender{} }()
+
+    // Only the recipients can reply when no admin is configured
+    config.Slack.Admins = nil
+
+    for _, userId := range []string{"UBOB", "UALICE"} {
+        db := newFakeRepository(t)
+        slackFake := newFakeSlack(t)
+        db.OnQuery("FROM Messages\n WHERE slack_channel = ?", messageRow(model.SMS{Id: "MESSAGE", Provider: model.ProviderNexmo, From: "33698765432", To: "33612345678", SlackChannel: "CDEFAULT", SlackTs: "1600000000.000001"}))
+        db.OnQuery("FROM OutboxEntries", recipientRow("MESSAGE", "UALICE"))
+
+        handleThreadReply(context.Background(), &slackevents.MessageEvent{Channel: "CDEFAULT", User: userId, Text: "YES", TimeStamp: "1600000000.000002", ThreadTimeStamp: "1600000000.000001"})
+
+        allowed := userId == "UALICE"
+        if rejected := len(slackFake.Calls("chat.postEphemeral")) == 1; rejected == allowed {
+            t.Errorf("%s: expected the reply to be rejected: %t, got: %t", userId, !allowed, rejected)
+        }
+    }
+
+    if sent := fake.Sent(); len(sent) != 1 || sent[0].Body != "YES" {
+        t.Errorf("expected only the reply of the recipient to be sent, got: %v", sent)
+    }
+}
+
+func TestHandleThreadReply_RootNumber(t *testing.T) {
+    fake := &FakeSMSSender{}
+    smsSenders = map[string]SMSSender{model.ProviderNexmo: fake}
+    defer func() { smsSenders = map[string]SMSSender{} }()
+
+    config.Slack.Admins = nil
+
+    db := newFakeRepository(t)
+    newFakeSlack(t)
+    db.OnQuery("FROM Messages\n WHERE slack_channel = ? AND slack_ts = ?", messageRow(model.SMS{Id: "ROOT", Provider: model.ProviderNexmo, From: "33698765432", To: "33612345678", SlackChannel: "CDEFAULT", SlackTs: "1600000000.000001"}))
+    db.OnQuery("FROM OutboxEntries", recipientRow("ROOT", "UALICE"))
+
+    handleThreadReply(context.Background(), &slackevents.MessageEvent{Channel: "CDEFAULT", User: "UALICE", Text: "YES", TimeStamp: "1600000000.000003", ThreadTimeStamp: "1600000000.000001"})
+
+    // Later texts of the thread may have been sent to another number
+    lookups := db.Executed("FROM Messages\n WHERE slack_channel = ? AND slack_ts = ?")
+    if len(lookups) != 1 || lookups[0].Args[1] != "1600000000.000001" {
+        t.Errorf("expected the root of the thread to be looked up, got: %v", lookups)
+    }
+
+    if sent := fake.Sent(); len(sent) != 1 || sent[0].From != "33612345678" || sent[0].To != "33698765432" {
+        t.Errorf("expected the reply to be sent from the number of the thread, got: %v", sent)
+    }
+}
+
+func TestIsGSMText(t *testing.T) {
+    tests := []struct {
+        text string
+        gsm  bool
+    }{
+        {"YES", true},
+        {"Réponse à 18h: [OK] {merci} ~ 5€", true},
+        {"Merci 👍", false},
+        {"Спасибо", false},
+        {"Ça coûte 5 €", false},
+    }
+
+    for _, test := range tests {
+        if gsm := isGSMText(test.text); gsm != test.gsm {
+            t.Errorf("%q: expected GSM to be %t, got: %t", test.text, test.gsm, gsm)
+        }
+    }
+}
+
+func TestIsThreadReply(t *testing.T) {
+    tests := []struct {
+        message  slackevents.MessageEvent
+        expected bool
+    }{
+        {slackevents.MessageEvent{User: "UALICE", TimeStamp: "2.0", ThreadTimeStamp: "1.0"}, true},
+        {slackevents.MessageEvent{User: "UALICE", TimeStamp: "1.0"}, false},
+        {slackevents.MessageEvent{User: "UALICE", TimeStamp: "1.0", ThreadTimeStamp: "1.0"}, false},
+        {slackevents.MessageEvent{BotID: "BBOT", TimeStamp: "2.0", ThreadTimeStamp: "1.0"}, false},
+        {slackevents.MessageEvent{User: "UALICE", SubType: "message_changed", TimeStamp: "2.0", ThreadTimeStamp: "1.0"}, false},
+    }
+
+    for _, test := range tests {
+        if got := isThreadReply(&test.message); got != test.expected {
+            t.Errorf("invalid thread reply detection for %+v, expected: %t, got: %t", test.message, test.expected, got)
+        }
+    }
+}
+
+func TestSmsUserNotifyMessage_ReplyButton(t *testing.T) {
+    message := messages.SmsUserNotifyMessage(model.SMS{Id: "message", From: "ACME", Body: "Hello"})
+    if !hasButton(message, "reply") {
+        t.Errorf("expected a reply button, got: %v", message.Blocks.BlockSet)
+    }
+}
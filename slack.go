@@ -0,0 +1,106 @@
+package main
+
+import (
+    "bytes"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "github.com/slack-go/slack"
+    "log"
+    "net/http"
+    "net/url"
+)
+
+const slackSignatureHeader = "X-Slack-Signature"
+
+// SlackVerifier authenticates requests sent by Slack using the signing secret.
+// The requests must have been signed less than 5 minutes ago to prevent
+// replays. When verificationToken is not empty, unsigned requests carrying
+// this deprecated token are accepted too.
+// See https://api.slack.com/authentication/verifying-requests-from-slack
+func SlackVerifier(signingSecret string, verificationToken string) func(next http.HandlerFunc) http.HandlerFunc {
+    return func(next http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+            err := verifySlackRequest(r, signingSecret, verificationToken)
+            if err != nil {
+                log.Printf("Rejected Slack request on %q: %s", r.RequestURI, err)
+                w.WriteHeader(http.StatusUnauthorized)
+                return
+            }
+
+            next(w, r)
+        }
+    }
+}
+
+func verifySlackRequest(r *http.Request, signingSecret string, verificationToken string) error {
+    body, err := readBody(r)
+    if err != nil {
+        return err
+    }
+
+    if r.Header.Get(slackSignatureHeader) == "" && verificationToken != "" {
+        return verifySlackToken(body, verificationToken)
+    }
+
+    if signingSecret == "" {
+        return errors.New("no Slack signing secret configured")
+    }
+
+    verifier, err := slack.NewSecretsVerifier(r.Header, signingSecret)
+    if err != nil {
+        return err
+    }
+
+    _, err = verifier.Write(body)
+    if err != nil {
+        return err
+    }
+
+    return verifier.Ensure()
+}
+
+// verifySlackToken checks the legacy verification token, sent as a form value
+// by slash commands, inside the JSON payload by interactive components and in
+// the JSON body by the Events API.
+func verifySlackToken(body []byte, verificationToken string) error {
+    token, err := slackToken(body)
+    if err != nil {
+        return err
+    }
+
+    if subtle.ConstantTimeCompare([]byte(token), []byte(verificationToken)) != 1 {
+        return errors.New("invalid verification token")
+    }
+
+    return nil
+}
+
+// slackToken extracts the verification token from the body of a request.
+func slackToken(body []byte) (string, error) {
+    if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+        var event struct {
+            Token string `json:"token"`
+        }
+        if err := json.Unmarshal(trimmed, &event); err != nil {
+            return "", fmt.Errorf("failed to unmarshal event: %w", err)
+        }
+        return event.Token, nil
+    }
+
+    form, err := url.ParseQuery(string(body))
+    if err != nil {
+        return "", fmt.Errorf("failed to parse request form data: %w", err)
+    }
+
+    if payload := form.Get("payload"); payload != "" {
+        var message slack.InteractionCallback
+        if err := json.Unmarshal([]byte(payload), &message); err != nil {
+            return "", fmt.Errorf("failed to unmarshal payload: %w", err)
+        }
+        return message.Token, nil
+    }
+
+    return form.Get("token"), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+func newSignedSlackRequest(secret string, timestamp time.Time, body string) *http.Request {
+    r, _ := http.NewRequest(http.MethodPost, "http://localhost/slash", strings.NewReader(body))
+    r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+    ts := strconv.FormatInt(timestamp.Unix(), 10)
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(fmt.Sprintf("v0:%s:%s", ts, body)))
+
+    r.Header.Set("X-Slack-Request-Timestamp", ts)
+    r.Header.Set(slackSignatureHeader, "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+    return r
+}
+
+func serveSlackVerifier(r *http.Request, signingSecret string, verificationToken string) (int, string) {
+    var receivedBody string
+    handler := SlackVerifier(signingSecret, verificationToken)(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        receivedBody = string(body)
+    })
+
+    w := httptest.NewRecorder()
+    handler(w, r)
+
+    return w.Result().StatusCode, receivedBody
+}
+
+func TestSlackVerifier_Valid(t *testing.T) {
+    r := newSignedSlackRequest("secret", time.Now(), "command=%2Fsms&text=start")
+
+    status, body := serveSlackVerifier(r, "secret", "")
+    if status != http.StatusOK {
+        t.Errorf("Expected HTTP Code 200, got: %d", status)
+    }
+
+    if body != "command=%2Fsms&text=start" {
+        t.Errorf("Expected the body to be forwarded to the handler, got: %q", body)
+    }
+}
+
+func TestSlackVerifier_Tampered(t *testing.T) {
+    r := newSignedSlackRequest("secret", time.Now(), "command=%2Fsms&text=start")
+    r.Body = io.NopCloser(strings.NewReader("command=%2Fsms&text=stop"))
+
+    status, _ := serveSlackVerifier(r, "secret", "")
+    if status != http.StatusUnauthorized {
+        t.Errorf("Expected HTTP Code 401, got: %d", status)
+    }
+}
+
+func TestSlackVerifier_Replayed(t *testing.T) {
+    r := newSignedSlackRequest("secret", time.Now().Add(-10*time.Minute), "command=%2Fsms&text=start")
+
+    status, _ := serveSlackVerifier(r, "secret", "")
+    if status != http.StatusUnauthorized {
+        t.Errorf("Expected HTTP Code 401, got: %d", status)
+    }
+}
+
+func TestSlackVerifier_Unsigned(t *testing.T) {
+    r, _ := http.NewRequest(http.MethodPost, "http://localhost/slash", strings.NewReader("token=legacy&text=start"))
+
+    status, _ := serveSlackVerifier(r, "secret", "")
+    if status != http.StatusUnauthorized {
+        t.Errorf("Expected HTTP Code 401, got: %d", status)
+    }
+}
+
+func TestSlackVerifier_VerificationTokenFallback(t *testing.T) {
+    r, _ := http.NewRequest(http.MethodPost, "http://localhost/slash", strings.NewReader("token=legacy&text=start"))
+
+    status, _ := serveSlackVerifier(r, "secret", "legacy")
+    if status != http.StatusOK {
+        t.Errorf("Expected HTTP Code 200, got: %d", status)
+    }
+
+    r, _ = http.NewRequest(http.MethodPost, "http://localhost/interactivity", strings.NewReader("payload=%7B%22token%22%3A%22legacy%22%7D"))
+
+    status, _ = serveSlackVerifier(r, "secret", "legacy")
+    if status != http.StatusOK {
+        t.Errorf("Expected HTTP Code 200, got: %d", status)
+    }
+
+    r, _ = http.NewRequest(http.MethodPost, "http://localhost/slash", strings.NewReader("token=forged&text=start"))
+
+    status, _ = serveSlackVerifier(r, "secret", "legacy")
+    if status != http.StatusUnauthorized {
+        t.Errorf("Expected HTTP Code 401, got: %d", status)
+    }
+}
+
+func TestSlackVerifier_VerificationTokenEvents(t *testing.T) {
+    r, _ := http.NewRequest(http.MethodPost, "http://localhost/events", strings.NewReader(`{"token":"legacy","challenge":"abc","type":"url_verification"}`))
+    r.Header.Set("Content-Type", "application/json")
+
+    status, _ := serveSlackVerifier(r, "secret", "legacy")
+    if status != http.StatusOK {
+        t.Errorf("Expected HTTP Code 200, got: %d", status)
+    }
+
+    r, _ = http.NewRequest(http.MethodPost, "http://localhost/events", strings.NewReader(`{"token":"forged","type":"event_callback"}`))
+    r.Header.Set("Content-Type", "application/json")
+
+    status, _ = serveSlackVerifier(r, "secret", "legacy")
+    if status != http.StatusUnauthorized {
+        t.Errorf("Expected HTTP Code 401, got: %d", status)
+    }
+}
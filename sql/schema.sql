@@ -11,5 +11,116 @@ CREATE TABLE ForwardingRequests(
     accepted_at DATETIME(3),
     refused_at DATETIME(3),
     expires_at DATETIME(3),
-    answered_by VARCHAR(16)
+    starts_at DATETIME(3),
+    schedule_id CHAR(36) NOT NULL DEFAULT '',
+    number VARCHAR(32) NOT NULL DEFAULT '',
+    answered_by VARCHAR(64),
+    stopped_at DATETIME(3),
+    stopped_by VARCHAR(16) NOT NULL DEFAULT '',
+    expiration_handled_at DATETIME(3),
+    expiry_warning_sent_at DATETIME(3),
+    approval_channel VARCHAR(16) NOT NULL DEFAULT '',
+    approval_ts VARCHAR(32) NOT NULL DEFAULT ''
+) CHARACTER SET utf8mb4;
+
+
+CREATE TABLE Messages(
+    id CHAR(36) PRIMARY KEY,
+    provider VARCHAR(16) NOT NULL,
+    from_number VARCHAR(32) NOT NULL,
+    to_number VARCHAR(32) NOT NULL,
+    body TEXT NOT NULL,
+    received_at DATETIME(3) NOT NULL,
+    slack_channel VARCHAR(16) NOT NULL DEFAULT '',
+    slack_ts VARCHAR(32) NOT NULL DEFAULT '',
+    slack_thread_ts VARCHAR(32) NOT NULL DEFAULT '',
+    INDEX messages_received_at (received_at),
+    INDEX messages_from_number (from_number, received_at)
+) CHARACTER SET utf8mb4;
+
+CREATE TABLE Deliveries(
+    id CHAR(36) PRIMARY KEY,
+    message_id CHAR(36) NOT NULL,
+    forwarding_request_id CHAR(36) NOT NULL,
+    user_id VARCHAR(16) NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    error TEXT,
+    INDEX deliveries_message_id (message_id),
+    INDEX deliveries_forwarding_request_id (forwarding_request_id)
+) CHARACTER SET utf8mb4;
+
+CREATE TABLE Replies(
+    id CHAR(36) PRIMARY KEY,
+    message_id CHAR(36) NOT NULL,
+    user_id VARCHAR(16) NOT NULL,
+    body TEXT NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    error TEXT,
+    INDEX replies_message_id (message_id)
+) CHARACTER SET utf8mb4;
+
+CREATE TABLE Reveals(
+    id CHAR(36) PRIMARY KEY,
+    message_id CHAR(36) NOT NULL,
+    user_id VARCHAR(16) NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    INDEX reveals_message_id (message_id)
+) CHARACTER SET utf8mb4;
+
+CREATE TABLE OutboxEntries(
+    id CHAR(36) PRIMARY KEY,
+    message_id CHAR(36) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    recipient VARCHAR(32) NOT NULL,
+    user_ids TEXT NOT NULL,
+    forwarding_request_ids TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(3) NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    sent_at DATETIME(3),
+    failed_at DATETIME(3),
+    last_error TEXT,
+    INDEX outbox_entries_next_attempt_at (next_attempt_at)
+) CHARACTER SET utf8mb4;
+
+CREATE TABLE Extensions(
+    id CHAR(36) PRIMARY KEY,
+    forwarding_request_id CHAR(36) NOT NULL,
+    duration INT NOT NULL,
+    requested_by VARCHAR(16) NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    accepted_at DATETIME(3),
+    refused_at DATETIME(3),
+    answered_by VARCHAR(16) NOT NULL DEFAULT '',
+    INDEX extensions_forwarding_request_id (forwarding_request_id)
+) CHARACTER SET utf8mb4;
+
+CREATE TABLE RecurringSchedules(
+    id CHAR(36) PRIMARY KEY,
+    requester_id VARCHAR(16) NOT NULL,
+    requester_name VARCHAR(50) NOT NULL,
+    number VARCHAR(32) NOT NULL DEFAULT '',
+    weekdays VARCHAR(16) NOT NULL,
+    start_time CHAR(5) NOT NULL,
+    end_time CHAR(5) NOT NULL,
+    timezone VARCHAR(64) NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    approved_at DATETIME(3),
+    refused_at DATETIME(3),
+    answered_by VARCHAR(16) NOT NULL DEFAULT '',
+    deleted_at DATETIME(3),
+    materialized_until DATETIME(3) NOT NULL,
+    INDEX recurring_schedules_requester_id (requester_id)
+) CHARACTER SET utf8mb4;
+
+CREATE TABLE RoutingRules(
+    id CHAR(36) PRIMARY KEY,
+    sender_pattern VARCHAR(64) NOT NULL DEFAULT '',
+    body_pattern VARCHAR(255) NOT NULL DEFAULT '',
+    number VARCHAR(32) NOT NULL DEFAULT '',
+    user_ids TEXT NOT NULL,
+    channel VARCHAR(16) NOT NULL DEFAULT '',
+    created_by VARCHAR(16) NOT NULL,
+    created_at DATETIME(3) NOT NULL,
+    deleted_at DATETIME(3)
 ) CHARACTER SET utf8mb4;
@@ -1,11 +1,19 @@
 package main
 
 import (
+    "crypto/hmac"
+    "crypto/sha1"
+    "encoding/base64"
+    "errors"
     "fmt"
     "github.com/CedricFinance/phone_operator/model"
     "net/http"
+    "sort"
+    "strings"
 )
 
+const twilioSignatureHeader = "X-Twilio-Signature"
+
 func ParseTwilioSMS(r *http.Request) (model.SMS, error) {
     err := r.ParseForm()
     if err != nil {
@@ -13,9 +21,75 @@ func ParseTwilioSMS(r *http.Request) (model.SMS, error) {
     }
 
     message := model.SMS{
-        Body: r.FormValue("Body"),
-        From: r.FormValue("From"),
+        Provider: model.ProviderTwilio,
+        Body:     r.FormValue("Body"),
+        From:     r.FormValue("From"),
+        To:       r.FormValue("To"),
     }
 
     return message, nil
 }
+
+// TwilioSignatureVerifier checks the X-Twilio-Signature header of incoming
+// webhooks against the account auth token.
+// See https://www.twilio.com/docs/usage/security#validating-requests
+func TwilioSignatureVerifier(authToken string) func(r *http.Request) error {
+    return func(r *http.Request) error {
+        if authToken == "" {
+            return errors.New("no Twilio auth token configured")
+        }
+
+        signature := r.Header.Get(twilioSignatureHeader)
+        if signature == "" {
+            return fmt.Errorf("missing %s header", twilioSignatureHeader)
+        }
+
+        err := r.ParseForm()
+        if err != nil {
+            return fmt.Errorf("failed to parse request form data: %w", err)
+        }
+
+        expected := twilioSignature(authToken, requestURL(r), r.PostForm)
+        if !hmac.Equal([]byte(signature), []byte(expected)) {
+            return fmt.Errorf("invalid %s header", twilioSignatureHeader)
+        }
+
+        return nil
+    }
+}
+
+func twilioSignature(authToken string, url string, params map[string][]string) string {
+    keys := make([]string, 0, len(params))
+    for key := range params {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    var data strings.Builder
+    data.WriteString(url)
+    for _, key := range keys {
+        for _, value := range params[key] {
+            data.WriteString(key)
+            data.WriteString(value)
+        }
+    }
+
+    mac := hmac.New(sha1.New, []byte(authToken))
+    mac.Write([]byte(data.String()))
+
+    return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// requestURL rebuilds the full URL the provider called, taking into account
+// the TLS termination done by the App Engine frontend.
+func requestURL(r *http.Request) string {
+    scheme := "http"
+    if r.TLS != nil {
+        scheme = "https"
+    }
+    if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+        scheme = proto
+    }
+
+    return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI())
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+    "crypto/tls"
+    "github.com/CedricFinance/phone_operator/model"
+    "io"
     "net/http"
+    "net/url"
     "strings"
     "testing"
 )
 
 func TestParseTwilioSMS(t *testing.T) {
-    r, _ := http.NewRequest(http.MethodPost, "http://localhost", strings.NewReader("From=0123456789&Body=HelloWorld"))
+    r, _ := http.NewRequest(http.MethodPost, "http://localhost", strings.NewReader("From=0123456789&To=0612345678&Body=HelloWorld"))
     r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
     message, err := ParseTwilioSMS(r)
@@ -22,4 +26,73 @@ func TestParseTwilioSMS(t *testing.T) {
     if message.From != "0123456789" {
         t.Errorf("invalid From")
     }
+
+    if message.To != "0612345678" {
+        t.Errorf("invalid To")
+    }
+
+    if message.Provider != model.ProviderTwilio {
+        t.Errorf("invalid Provider")
+    }
+}
+
+func newSignedTwilioRequest(authToken string, body string) *http.Request {
+    r, _ := http.NewRequest(http.MethodPost, "https://example.com/sms", strings.NewReader(body))
+    r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+    params, _ := url.ParseQuery(body)
+    r.Header.Set(twilioSignatureHeader, twilioSignature(authToken, "https://example.com/sms", params))
+
+    return r
+}
+
+func TestTwilioSignatureVerifier_Valid(t *testing.T) {
+    r := newSignedTwilioRequest("secret", "From=0123456789&Body=HelloWorld")
+    r.TLS = &tls.ConnectionState{}
+
+    err := TwilioSignatureVerifier("secret")(r)
+    if err != nil {
+        t.Errorf("expected a valid signature, got: %s", err)
+    }
+}
+
+func TestTwilioSignatureVerifier_ForwardedProto(t *testing.T) {
+    r := newSignedTwilioRequest("secret", "From=0123456789&Body=HelloWorld")
+    r.Header.Set("X-Forwarded-Proto", "https")
+
+    err := TwilioSignatureVerifier("secret")(r)
+    if err != nil {
+        t.Errorf("expected a valid signature, got: %s", err)
+    }
+}
+
+func TestTwilioSignatureVerifier_Tampered(t *testing.T) {
+    r := newSignedTwilioRequest("secret", "From=0123456789&Body=HelloWorld")
+    r.TLS = &tls.ConnectionState{}
+    r.Body = io.NopCloser(strings.NewReader("From=0123456789&Body=Forged"))
+
+    err := TwilioSignatureVerifier("secret")(r)
+    if err == nil {
+        t.Errorf("expected tampered request to be rejected")
+    }
+}
+
+func TestTwilioSignatureVerifier_WrongToken(t *testing.T) {
+    r := newSignedTwilioRequest("other", "From=0123456789&Body=HelloWorld")
+    r.TLS = &tls.ConnectionState{}
+
+    err := TwilioSignatureVerifier("secret")(r)
+    if err == nil {
+        t.Errorf("expected request signed with another token to be rejected")
+    }
+}
+
+func TestTwilioSignatureVerifier_Missing(t *testing.T) {
+    r, _ := http.NewRequest(http.MethodPost, "https://example.com/sms", strings.NewReader("From=0123456789&Body=HelloWorld"))
+    r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+    err := TwilioSignatureVerifier("secret")(r)
+    if err == nil {
+        t.Errorf("expected unsigned request to be rejected")
+    }
 }